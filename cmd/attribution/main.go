@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/pgsql"
+)
+
+const (
+	LogTag             = "attribution"
+	defaultDatabaseURL = "postgresql:///vgdept"
+)
+
+const dateLayout = time.DateOnly
+
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+)
+
+var (
+	ErrInvalidArgs   = errors.New("invalid args")
+	ErrInvalidFormat = errors.New("invalid format")
+	ErrInvalidRange  = errors.New("invalid date range")
+)
+
+// Funnel - the attribution funnel of the partner label. The visits are
+// the start labels first visited in the range, the brigades are created
+// from them. A brigade is alive after N days if it isn't deleted within
+// N days from the creation, only the brigades created N days before
+// the report time are counted, they are the base.
+type Funnel struct {
+	PartnerID uuid.UUID `json:"partner_id"`
+	Partner   string    `json:"partner"`
+	Label     string    `json:"label"`
+	Visits    int64     `json:"visits"`
+	Brigades  int64     `json:"brigades"`
+	Base1d    int64     `json:"base_1d"`
+	Alive1d   int64     `json:"alive_1d"`
+	Base7d    int64     `json:"base_7d"`
+	Alive7d   int64     `json:"alive_7d"`
+	Base30d   int64     `json:"base_30d"`
+	Alive30d  int64     `json:"alive_30d"`
+	VIP       int64     `json:"vip"`
+	Restored  int64     `json:"restored"`
+}
+
+// Report - the funnels of the first visits range [From, To).
+type Report struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Now     time.Time `json:"now"`
+	Funnels []Funnel  `json:"funnels"`
+}
+
+func main() {
+	from, to, partnerID, format, err := parseArgs()
+	if err != nil {
+		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
+	}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = defaultDatabaseURL
+	}
+
+	db, err := pgsql.CreateDBPool(dbURL)
+	if err != nil {
+		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+	}
+
+	report, err := fetchReport(context.Background(), db, from, to, partnerID, time.Now().UTC())
+	if err != nil {
+		log.Fatalf("%s: Can't fetch report: %s\n", LogTag, err)
+	}
+
+	switch format {
+	case formatJSON:
+		payload, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("%s: Can't marshal report: %s\n", LogTag, err)
+		}
+
+		fmt.Fprintf(os.Stdout, "%s\n", payload)
+	case formatCSV:
+		if err := writeCSV(os.Stdout, report); err != nil {
+			log.Fatalf("%s: Can't write report: %s\n", LogTag, err)
+		}
+	default:
+		printReport(os.Stdout, report)
+	}
+}
+
+// sqlFunnels - the deletion time is the last delete_brigade event
+// of the brigade deleted or purged now, the restored brigade is alive.
+// The brigadier_vip row is purged after the VIP is over, so the VIP
+// brigade is the one with any VIP event.
+const sqlFunnels = `
+WITH l AS (
+	SELECT
+		sl.partner_id,
+		sl.label,
+		sl.brigade_id,
+		bi.created_at,
+		CASE
+			WHEN db.brigade_id IS NOT NULL OR bi.purged_at IS NOT NULL THEN (
+				SELECT max(a.event_time) FROM head.brigades_actions a WHERE a.brigade_id = sl.brigade_id AND a.event_name = 'delete_brigade'
+			)
+		END AS gone_at,
+		EXISTS (
+			SELECT 1 FROM head.brigadier_vip_actions va WHERE va.brigade_id = sl.brigade_id
+		) AS vip,
+		EXISTS (
+			SELECT 1 FROM head.brigades_actions a WHERE a.brigade_id = sl.brigade_id AND a.event_name = 'restore_brigade'
+		) AS restored
+	FROM
+		head.start_labels sl
+		LEFT JOIN head.brigadiers_ids bi ON bi.brigade_id = sl.brigade_id
+		LEFT JOIN head.deleted_brigadiers db ON db.brigade_id = sl.brigade_id
+	WHERE
+		sl.first_visit >= $1
+		AND sl.first_visit < $2
+		AND ($3::uuid IS NULL OR sl.partner_id = $3)
+)
+SELECT
+	l.partner_id,
+	COALESCE(p.partner, ''),
+	l.label,
+	COUNT(*),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at IS NOT NULL),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at <= $4 - interval '1 day'),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at <= $4 - interval '1 day'
+		AND (l.gone_at IS NULL OR l.gone_at >= l.created_at + interval '1 day')),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at <= $4 - interval '7 days'),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at <= $4 - interval '7 days'
+		AND (l.gone_at IS NULL OR l.gone_at >= l.created_at + interval '7 days')),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at <= $4 - interval '30 days'),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at <= $4 - interval '30 days'
+		AND (l.gone_at IS NULL OR l.gone_at >= l.created_at + interval '30 days')),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at IS NOT NULL AND l.vip),
+	COUNT(DISTINCT l.brigade_id) FILTER (WHERE l.created_at IS NOT NULL AND l.restored)
+FROM
+	l
+	LEFT JOIN head.partners p ON p.partner_id = l.partner_id
+GROUP BY
+	1, 2, 3
+ORDER BY
+	2, 3
+`
+
+func fetchReport(ctx context.Context, db *pgxpool.Pool, from, to time.Time, partnerID pgtype.UUID, now time.Time) (*Report, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlFunnels, from, to, partnerID, now)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	report := &Report{
+		From:    from,
+		To:      to,
+		Now:     now,
+		Funnels: []Funnel{},
+	}
+
+	var f Funnel
+
+	if _, err := pgx.ForEachRow(rows, []any{
+		&f.PartnerID, &f.Partner, &f.Label, &f.Visits, &f.Brigades,
+		&f.Base1d, &f.Alive1d, &f.Base7d, &f.Alive7d, &f.Base30d, &f.Alive30d,
+		&f.VIP, &f.Restored,
+	}, func() error {
+		report.Funnels = append(report.Funnels, f)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("funnels: %w", err)
+	}
+
+	return report, nil
+}
+
+var csvHeader = []string{
+	"partner_id", "partner", "label", "visits", "brigades",
+	"base_1d", "alive_1d", "base_7d", "alive_7d", "base_30d", "alive_30d",
+	"vip", "restored",
+}
+
+func writeCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	for _, f := range report.Funnels {
+		record := []string{f.PartnerID.String(), f.Partner, f.Label}
+
+		for _, n := range []int64{
+			f.Visits, f.Brigades,
+			f.Base1d, f.Alive1d, f.Base7d, f.Alive7d, f.Base30d, f.Alive30d,
+			f.VIP, f.Restored,
+		} {
+			record = append(record, strconv.FormatInt(n, 10))
+		}
+
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func printReport(w io.Writer, report *Report) {
+	fmt.Fprintf(w, "First visits: %s - %s\n\n", report.From.Format(dateLayout), report.To.Format(dateLayout))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "PARTNER\tLABEL\tVISITS\tBRIGADES\tALIVE 1D\tALIVE 7D\tALIVE 30D\tVIP\tRESTORED\n")
+
+	for _, f := range report.Funnels {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\n",
+			f.Partner, f.Label, f.Visits, f.Brigades,
+			fmtAlive(f.Alive1d, f.Base1d), fmtAlive(f.Alive7d, f.Base7d), fmtAlive(f.Alive30d, f.Base30d),
+			f.VIP, f.Restored,
+		)
+	}
+
+	tw.Flush()
+}
+
+// fmtAlive - "alive/base (percent)", "-" if no brigade is old enough.
+func fmtAlive(alive, base int64) string {
+	if base == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d/%d (%.1f%%)", alive, base, float64(alive)*100/float64(base))
+}
+
+func parseArgs() (time.Time, time.Time, pgtype.UUID, string, error) {
+	fromArg := flag.String("from", "", "first visits from date, YYYY-MM-DD (default: 30 days before -to)")
+	toArg := flag.String("to", "", "first visits to date exclusive, YYYY-MM-DD (default: tomorrow)")
+	partnerArg := flag.String("p", "", "partner id (default: all partners)")
+	format := flag.String("f", formatTable, "output format: table, csv or json")
+
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		return time.Time{}, time.Time{}, pgtype.UUID{}, "", fmt.Errorf("%w: %v", ErrInvalidArgs, flag.Args())
+	}
+
+	switch *format {
+	case formatTable, formatCSV, formatJSON:
+	default:
+		return time.Time{}, time.Time{}, pgtype.UUID{}, "", fmt.Errorf("%w: %s", ErrInvalidFormat, *format)
+	}
+
+	to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	if *toArg != "" {
+		t, err := time.Parse(dateLayout, *toArg)
+		if err != nil {
+			return time.Time{}, time.Time{}, pgtype.UUID{}, "", fmt.Errorf("to: %w", err)
+		}
+
+		to = t
+	}
+
+	from := to.AddDate(0, 0, -30)
+	if *fromArg != "" {
+		t, err := time.Parse(dateLayout, *fromArg)
+		if err != nil {
+			return time.Time{}, time.Time{}, pgtype.UUID{}, "", fmt.Errorf("from: %w", err)
+		}
+
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, pgtype.UUID{}, "", fmt.Errorf("%w: %s - %s", ErrInvalidRange, from.Format(dateLayout), to.Format(dateLayout))
+	}
+
+	var partnerID pgtype.UUID
+
+	if *partnerArg != "" {
+		id, err := uuid.Parse(*partnerArg)
+		if err != nil {
+			return time.Time{}, time.Time{}, pgtype.UUID{}, "", fmt.Errorf("partner id: %w", err)
+		}
+
+		partnerID = pgtype.UUID{Bytes: id, Valid: true}
+	}
+
+	return from, to, partnerID, *format, nil
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	jwtsvc "github.com/vpngen/keydesk/pkg/jwt"
+	"github.com/vpngen/ministry/internal/idcodec"
 	sshVng "github.com/vpngen/ministry/internal/ssh"
 )
 
@@ -20,14 +23,36 @@ const (
 	keydeskJwtPrivkeyFileName = "keydesk-jwt.key"
 	etcSubdir                 = "vg-keydesk"
 	defaultVipEndpoint        = "vip.vpn.works"
+	defaultVipSources         = sourceEndpoint + "," + sourceDB
 	listCommand               = "list_keys"
 	defaultDatabaseURL        = "postgresql:///vgdept"
+	defaultRunInterval        = time.Minute
+	defaultRunJitter          = 10 * time.Second
+	defaultRemindDays         = 3
+	defaultVerifyInterval     = time.Hour
+	commandReconcile          = "reconcile"
+)
+
+var (
+	ErrInvalidInterval = errors.New("invalid interval")
+	ErrUnknownCommand  = errors.New("unknown command")
 )
 
 type config struct {
 	debug     bool
 	onlyfetch bool
 	silent    bool
+	daemon    bool
+
+	interval time.Duration
+	jitter   time.Duration
+
+	remindDays int
+
+	verifyInterval time.Duration
+	fixUnknown     bool
+
+	reconcile reconcileOpts
 
 	jwtKeydeskIssuer jwtsvc.KeydeskTokenIssuer
 
@@ -35,7 +60,8 @@ type config struct {
 	sshKeyFn string
 
 	vipEndpoint string
-	obfsUUID    uuid.UUID
+	vipSources  []string
+	codec       *idcodec.Codec
 }
 
 func parseArgs() (config, error) {
@@ -48,17 +74,23 @@ func parseArgs() (config, error) {
 
 	cfg.vipEndpoint = vipEndpoint
 
-	obfsKey := os.Getenv("OBFS_UUID")
+	vipSources := os.Getenv("VIP_SOURCES")
+	if vipSources == "" {
+		vipSources = defaultVipSources
+	}
 
-	obfsUUID, err := uuid.Parse(obfsKey)
-	if err != nil {
-		return cfg, fmt.Errorf("parse obfs uuid: %w", err)
+	for _, spec := range strings.Split(vipSources, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			cfg.vipSources = append(cfg.vipSources, spec)
+		}
 	}
 
-	obfsUUID[6] &= 0x0F
-	obfsUUID[8] &= 0x3F
+	codec, err := idcodec.NewFromEnv()
+	if err != nil {
+		return cfg, fmt.Errorf("id codec: %w", err)
+	}
 
-	cfg.obfsUUID = obfsUUID
+	cfg.codec = codec
 
 	dbURL := os.Getenv("DB_URL")
 	if dbURL == "" {
@@ -77,12 +109,55 @@ func parseArgs() (config, error) {
 	debug := flag.Bool("debug", false, "Debug")
 	silent := flag.Bool("s", false, "Silent")
 	onlyfetch := flag.Bool("onlyfetch", false, "Only fetch and print answers")
+	daemon := flag.Bool("daemon", false, "Daemon mode, run on own schedule (SIGUSR1 to run now)")
+	interval := flag.Duration("interval", defaultRunInterval, "Daemon mode run interval")
+	jitter := flag.Duration("jitter", defaultRunJitter, "Daemon mode max random delay added to interval")
+	remindDays := flag.Int("remind", defaultRemindDays, "Remind about VIP expiration in N days (0 - off)")
+	verifyInterval := flag.Duration("verify", defaultVerifyInterval, "Verify realm VIP state every interval (0 - off)")
+	fixUnknown := flag.Bool("fix-unknown", false, "Unset realm VIP of brigades without VIP records")
 
 	flag.Parse()
 
 	cfg.debug = *debug
 	cfg.onlyfetch = *onlyfetch
 	cfg.silent = *silent && !*debug
+	cfg.daemon = *daemon
+	cfg.interval = *interval
+	cfg.jitter = *jitter
+	cfg.remindDays = *remindDays
+	cfg.verifyInterval = *verifyInterval
+	cfg.fixUnknown = *fixUnknown
+
+	if cfg.interval <= 0 {
+		return cfg, fmt.Errorf("interval: %w", ErrInvalidInterval)
+	}
+
+	if cfg.verifyInterval < 0 {
+		return cfg, fmt.Errorf("verify: %w", ErrInvalidInterval)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case commandReconcile:
+		fs := flag.NewFlagSet(commandReconcile, flag.ContinueOnError)
+
+		repair := fs.Bool("repair", false, "Repair DB and realm drift")
+		jout := fs.Bool("j", false, "JSON output")
+		onlyMismatch := fs.Bool("m", false, "Show only brigades with mismatches")
+
+		if err := fs.Parse(flag.Args()[1:]); err != nil {
+			return cfg, fmt.Errorf("%s: %w", commandReconcile, err)
+		}
+
+		cfg.reconcile = reconcileOpts{
+			enabled:      true,
+			repair:       *repair,
+			json:         *jout,
+			onlyMismatch: *onlyMismatch,
+		}
+	default:
+		return cfg, fmt.Errorf("%w: %s", ErrUnknownCommand, cmd)
+	}
 
 	sysUser, err := user.Current()
 	if err != nil {
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// runLockKey - the key of the postgres advisory lock which
+// guards ckvip phases against parallel runs ("ckvip" in hex).
+const runLockKey int64 = 0x636b766970
+
+const (
+	sqlTryRunLock = `SELECT pg_try_advisory_lock($1)`
+	sqlRunUnlock  = `SELECT pg_advisory_unlock($1)`
+)
+
+var ErrAlreadyRunning = errors.New("already running")
+
+// withRunLock - runs fn holding the session advisory lock,
+// so ckvip instances never overlap, wherever they are started from.
+func withRunLock(ctx context.Context, db *pgxpool.Pool, fn func(ctx context.Context) error) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire: %w", err)
+	}
+
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, sqlTryRunLock, runLockKey).Scan(&locked); err != nil {
+		return fmt.Errorf("try lock: %w", err)
+	}
+
+	if !locked {
+		return ErrAlreadyRunning
+	}
+
+	defer func() {
+		if _, err := conn.Exec(context.Background(), sqlRunUnlock, runLockKey); err != nil {
+			// the lock is released with the session
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// daemon - runs the job on its own jittered schedule.
+// SIGUSR1 triggers the run right now, SIGINT and SIGTERM stop the daemon
+// after the current run is finished. The runs never overlap.
+func daemon(ctx context.Context, cfg *config, job func(ctx context.Context) error) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	defer signal.Stop(stop)
+
+	trigger := make(chan os.Signal, 1)
+	signal.Notify(trigger, syscall.SIGUSR1)
+
+	defer signal.Stop(trigger)
+
+	fmt.Fprintf(os.Stderr, "%s: Daemon started, interval: %s, jitter: %s\n", LogTag, cfg.interval, cfg.jitter)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case sig := <-stop:
+			fmt.Fprintf(os.Stderr, "%s: Got %s, daemon stopped\n", LogTag, sig)
+
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-trigger:
+			fmt.Fprintf(os.Stderr, "%s: Run triggered\n", LogTag)
+		case <-timer.C:
+		}
+
+		started := time.Now()
+
+		// the job context isn't canceled by signals to let the run finish gracefully
+		switch err := job(context.WithoutCancel(ctx)); {
+		case errors.Is(err, ErrAlreadyRunning):
+			fmt.Fprintf(os.Stderr, "%s: Another instance is running, skip\n", LogTag)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s: Run failed: %s\n", LogTag, err)
+		case !cfg.silent:
+			fmt.Fprintf(os.Stderr, "%s: Run finished in %s\n", LogTag, time.Since(started))
+		}
+
+		timer.Reset(nextRunDelay(cfg.interval, cfg.jitter))
+	}
+}
+
+func nextRunDelay(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+
+	return interval + rand.N(jitter)
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"net/netip"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -21,16 +22,78 @@ import (
 
 var ErrServiceTemporarilyUnavailable = errors.New("service temporarily unavailable")
 
+var ErrInvalidVIPArgs = errors.New("invalid vip args")
+
+// safeArgs - realm command arguments must not contain any shell specials.
+var safeArgs = regexp.MustCompile(`^[A-Za-z0-9_.,\- ]*$`)
+
+// safePlan - a plan ID is a single word, no whitespace.
+var safePlan = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// safeFeatures - features are a comma list of plan ID like words.
+var safeFeatures = regexp.MustCompile(`^[A-Za-z0-9_.-]+(,[A-Za-z0-9_.-]+)*$`)
+
+// vipTarget - the brigade to viparize with its plan parameters.
+type vipTarget struct {
+	BrigadeID uuid.UUID
+	Users     int
+	Plan      string
+	Features  string
+}
+
+// vipArgs - extra realm vipon arguments, empty for brigades without a plan.
+// Plan and features come from the partner, each is checked on its own,
+// so none of them can add an argument to the vipon command line.
+func (t vipTarget) vipArgs() (string, error) {
+	if t.Plan == "" {
+		return "", nil
+	}
+
+	if !safePlan.MatchString(t.Plan) {
+		return "", fmt.Errorf("%w: plan %q", ErrInvalidVIPArgs, t.Plan)
+	}
+
+	args := fmt.Sprintf("-plan %s -seats %d", t.Plan, t.Users)
+	if t.Features != "" {
+		if !safeFeatures.MatchString(t.Features) {
+			return "", fmt.Errorf("%w: features %q", ErrInvalidVIPArgs, t.Features)
+		}
+
+		args += " -features " + t.Features
+	}
+
+	return args, nil
+}
+
 func callRealmViparizeBrigadier(ctx context.Context, sshconf *ssh.ClientConfig, tag string,
-	addr netip.AddrPort, vip bool, brigadeID uuid.UUID,
+	addr netip.AddrPort, vip bool, brigadeID uuid.UUID, args string,
 ) error {
 	bid := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(brigadeID[:])
 
+	if !safeArgs.MatchString(args) {
+		return fmt.Errorf("%w: %q", ErrInvalidVIPArgs, args)
+	}
+
 	cmd := fmt.Sprintf("vipon -id %s", bid)
+	if args != "" {
+		cmd += " " + args
+	}
+
 	if !vip {
 		cmd = fmt.Sprintf("vipoff -id %s", bid)
 	}
 
+	if _, err := runRealmCommand(ctx, sshconf, tag, addr, cmd); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// runRealmCommand - runs the command on the realm and returns its stdout.
+func runRealmCommand(ctx context.Context, sshconf *ssh.ClientConfig, tag string,
+	addr netip.AddrPort, cmd string,
+) ([]byte, error) {
 	fmt.Fprintf(os.Stderr, "%s: %s#%s -> %s\n", tag, sshconf.User, addr, cmd)
 
 	var (
@@ -43,12 +106,12 @@ func callRealmViparizeBrigadier(ctx context.Context, sshconf *ssh.ClientConfig,
 		client, err = ssh.Dial("tcp", addr.String(), sshconf)
 		if err != nil {
 			if attempts++; attempts > core.RealmConnectMaxAttempts {
-				return core.ErrAttemptLimitExceeded
+				return nil, core.ErrAttemptLimitExceeded
 			}
 
 			select {
 			case <-ctx.Done():
-				return fmt.Errorf("ssh dial: %w", ctx.Err())
+				return nil, fmt.Errorf("ssh dial: %w", ctx.Err())
 			case <-time.After(core.RealmConnectSleepTimeout):
 			}
 
@@ -62,7 +125,7 @@ func callRealmViparizeBrigadier(ctx context.Context, sshconf *ssh.ClientConfig,
 
 	session, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("ssh session: %w", err)
+		return nil, fmt.Errorf("ssh session: %w", err)
 	}
 
 	defer session.Close()
@@ -85,16 +148,17 @@ func callRealmViparizeBrigadier(ctx context.Context, sshconf *ssh.ClientConfig,
 	}()
 
 	if err := session.Run(cmd); err != nil {
-		return fmt.Errorf("ssh run: %w", err)
+		return nil, fmt.Errorf("ssh run: %w", err)
 	}
 
 	r := bufio.NewReader(&b)
 
-	if _, err := io.ReadAll(r); err != nil {
-		return fmt.Errorf("read: %w", err)
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
 	}
 
-	return nil
+	return out, nil
 }
 
 func fetchBrigadeRealm(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) (uuid.UUID, netip.AddrPort, error) {
@@ -116,13 +180,3 @@ func fetchBrigadeRealm(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUI
 
 	return realmID, addr, nil
 }
-
-func obfs2uuid(in, obfsUUID uuid.UUID) uuid.UUID {
-	var id uuid.UUID
-
-	for i := range len(in) {
-		id[i] = in[i] ^ obfsUUID[i]
-	}
-
-	return id
-}
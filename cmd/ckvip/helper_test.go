@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVipArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  vipTarget
+		want    string
+		wantErr bool
+	}{
+		{name: "no plan", target: vipTarget{Users: 3}, want: ""},
+		{name: "plan", target: vipTarget{Users: 3, Plan: "family"}, want: "-plan family -seats 3"},
+		{name: "features", target: vipTarget{Users: 5, Plan: "team.v2", Features: "tg_bot,split-dns"}, want: "-plan team.v2 -seats 5 -features tg_bot,split-dns"},
+		{name: "plan with space", target: vipTarget{Users: 3, Plan: "family -seats 100"}, wantErr: true},
+		{name: "plan with shell", target: vipTarget{Users: 3, Plan: "family;reboot"}, wantErr: true},
+		{name: "features with space", target: vipTarget{Users: 3, Plan: "family", Features: "tg_bot -seats 100"}, wantErr: true},
+		{name: "empty feature", target: vipTarget{Users: 3, Plan: "family", Features: "tg_bot,"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.target.vipArgs()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidVIPArgs) {
+					t.Fatalf("err = %v, want ErrInvalidVIPArgs", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
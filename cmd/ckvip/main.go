@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vpngen/ministry/internal/pgsql"
+	"golang.org/x/crypto/ssh"
 
 	sshVng "github.com/vpngen/ministry/internal/ssh"
 )
@@ -37,23 +42,98 @@ func main() {
 
 	if !cfg.silent {
 		fmt.Fprintf(os.Stderr, "%s: VIP Endpoint: %s\n", LogTag, cfg.vipEndpoint)
-		fmt.Fprintf(os.Stderr, "%s: OBFS UUID: %s\n", LogTag, cfg.obfsUUID)
+		fmt.Fprintf(os.Stderr, "%s: VIP Sources: %s\n", LogTag, strings.Join(cfg.vipSources, ","))
+		fmt.Fprintf(os.Stderr, "%s: ID codec key: %d\n", LogTag, cfg.codec.CurrentKeyID())
 		fmt.Fprintf(os.Stderr, "%s: DB URL: %s\n", LogTag, cfg.dbURL)
 	}
 
-	brigades, raw, err := fetchPaidUsers(c, cfg.obfsUUID, cfg.vipEndpoint)
-	if cfg.debug && raw != nil {
-		fmt.Fprintf(os.Stdout, "%s\n", raw)
+	db, err := pgsql.CreateDBPool(cfg.dbURL)
+	if err != nil {
+		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+	}
+
+	sources, err := newPaymentSources(&cfg, c, db)
+	if err != nil {
+		log.Fatalf("%s: Can't create payment sources: %s\n", LogTag, err)
+	}
+
+	if cfg.onlyfetch {
+		if _, err := fetch(ctx, &cfg, sources); err != nil {
+			log.Fatalf("%s: Can't fetch paid users: %s\n", LogTag, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Only fetch mode, exiting\n")
+
+		return
+	}
+
+	sshconf, err := sshVng.CreateSSHConfig(cfg.sshKeyFn, sshkeyRemoteUsername, sshVng.SSHDefaultTimeOut)
+	if err != nil {
+		log.Fatalf("%s: Can't create ssh configs: %s\n", LogTag, err)
+	}
+
+	if cfg.reconcile.enabled {
+		job := func(ctx context.Context) error {
+			return reconcile(ctx, &cfg, db, sshconf, sources)
+		}
+
+		if cfg.reconcile.repair {
+			job = func(ctx context.Context) error {
+				return withRunLock(ctx, db, func(ctx context.Context) error {
+					return reconcile(ctx, &cfg, db, sshconf, sources)
+				})
+			}
+		}
+
+		if err := job(ctx); err != nil {
+			if errors.Is(err, ErrAlreadyRunning) {
+				fmt.Fprintf(os.Stderr, "%s: Another instance is running, skip\n", LogTag)
+
+				return
+			}
+
+			log.Fatalf("%s: Reconcile: %s\n", LogTag, err)
+		}
+
+		return
+	}
+
+	job := func(ctx context.Context) error {
+		return withRunLock(ctx, db, func(ctx context.Context) error {
+			return run(ctx, &cfg, db, sshconf, sources)
+		})
 	}
 
+	if cfg.daemon {
+		if err := daemon(ctx, &cfg, job); err != nil {
+			log.Fatalf("%s: Daemon: %s\n", LogTag, err)
+		}
+
+		return
+	}
+
+	if err := job(ctx); err != nil {
+		if errors.Is(err, ErrAlreadyRunning) {
+			fmt.Fprintf(os.Stderr, "%s: Another instance is running, skip\n", LogTag)
+
+			return
+		}
+
+		log.Fatalf("%s: %s\n", LogTag, err)
+	}
+}
+
+// fetch - fetches entitlements from all the payment sources.
+func fetch(ctx context.Context, cfg *config, sources []PaymentSource) (map[uuid.UUID]VipBrigade, error) {
+	brigades, err := fetchEntitlements(ctx, sources)
 	if err != nil {
-		log.Fatalf("%s: Can't fetch paid users: %s\n", LogTag, err)
+		return nil, err
 	}
 
 	goods := 0
 	for _, brigade := range brigades {
 		if cfg.debug {
-			fmt.Fprintf(os.Stderr, "Found brigade: %s (%s), ExpiredAt: %s, UsersCount: %d\n", brigade.BrigadeID, brigade.RawBrigadeID, brigade.ExpiredAt, brigade.UsersCount)
+			fmt.Fprintf(os.Stderr, "Found brigade: %s (%s), ExpiredAt: %s, UsersCount: %d, Product: %q, Source: %s\n", brigade.BrigadeID, brigade.RawBrigadeID, brigade.ExpiredAt, brigade.UsersCount, brigade.Product, brigade.Source)
 		}
 
 		goods += brigade.UsersCount
@@ -64,24 +144,26 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Total %d goods\n", goods)
 	}
 
-	if cfg.onlyfetch {
-		fmt.Fprintf(os.Stderr, "Only fetch mode, exiting\n")
-		return
-	}
+	return brigades, nil
+}
 
-	sshconf, err := sshVng.CreateSSHConfig(cfg.sshKeyFn, sshkeyRemoteUsername, sshVng.SSHDefaultTimeOut)
+// run - one full pass: fetch entitlements and bring brigades in line with them.
+func run(ctx context.Context, cfg *config, db *pgxpool.Pool, sshconf *ssh.ClientConfig, sources []PaymentSource) error {
+	brigades, err := fetch(ctx, cfg, sources)
 	if err != nil {
-		log.Fatalf("%s: Can't create ssh configs: %s\n", LogTag, err)
+		return fmt.Errorf("can't fetch paid users: %w", err)
 	}
 
-	db, err := pgsql.CreateDBPool(cfg.dbURL)
+	plans, products, err := loadPlans(ctx, db)
 	if err != nil {
-		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+		return fmt.Errorf("can't load VIP plans: %w", err)
 	}
 
+	applyPlans(brigades, plans, products)
+
 	if len(brigades) > 0 {
 		if err := updateVIPRecords(ctx, db, brigades, cfg.silent); err != nil {
-			log.Fatalf("%s: Can't update VIP records: %s\n", LogTag, err)
+			return fmt.Errorf("can't update VIP records: %w", err)
 		}
 	}
 
@@ -91,7 +173,7 @@ func main() {
 	}
 
 	if err := viparize(ctx, db, sshconf, cfg.silent); err != nil {
-		log.Fatalf("%s: Can't set VIP brigades: %s\n", LogTag, err)
+		return fmt.Errorf("can't set VIP brigades: %w", err)
 	}
 
 	// try to restore deleted vip brigade
@@ -100,7 +182,7 @@ func main() {
 	}
 
 	if err := viparizeDeleted(ctx, db, sshconf, cfg.debug, cfg.silent); err != nil {
-		log.Fatalf("%s: Can't restore deleted VIP brigades: %s\n", LogTag, err)
+		return fmt.Errorf("can't restore deleted VIP brigades: %w", err)
 	}
 
 	// try to create credentials for VIP brigades
@@ -120,16 +202,37 @@ func main() {
 		fmt.Fprintf(os.Stderr, "%s: Can't set VIP brigades: %s\n", LogTag, err)
 	}
 
+	// try to notify about expiration before vip is unset
+	if !cfg.silent {
+		fmt.Fprintf(os.Stderr, "%s: Try to notify VIP brigades\n", LogTag)
+	}
+
+	if err := notify(ctx, db, cfg.remindDays, cfg.silent); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Can't notify VIP brigades: %s\n", LogTag, err)
+	}
+
 	// try to unset vip brigade
 	if !cfg.silent {
 		fmt.Fprintf(os.Stderr, "%s: Try to unset VIP brigades\n", LogTag)
 	}
 
 	if err := unviparize(ctx, db, sshconf, cfg.silent); err != nil {
-		log.Fatalf("%s: Can't unset VIP brigades: %s\n", LogTag, err)
+		return fmt.Errorf("can't unset VIP brigades: %w", err)
+	}
+
+	if cfg.verifyInterval > 0 {
+		if !cfg.silent {
+			fmt.Fprintf(os.Stderr, "%s: Try to verify realm VIP state\n", LogTag)
+		}
+
+		if err := verifyRealms(ctx, db, sshconf, cfg.verifyInterval, cfg.fixUnknown, cfg.silent); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Can't verify realm VIP state: %s\n", LogTag, err)
+		}
 	}
 
 	if !cfg.silent {
 		fmt.Fprintf(os.Stderr, "%s: Done\n", LogTag)
 	}
+
+	return nil
 }
@@ -175,9 +175,15 @@ UPDATE
 SET 
 	finalizer = true,
 	last_try = NOW() AT TIME ZONE 'UTC' - INTERVAL '1 HOUR',
+	push_attempts = 0,
+	push_next = NOW() AT TIME ZONE 'UTC',
+	push_error = '',
+	lease_id = NULL,
+	lease_until = NULL,
 	vpnconfig = $1
 WHERE 
 	brigade_id = $2
+	AND msg_type = 'config'
 `
 
 func updateVPNConf(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID, payload string) error {
@@ -192,12 +198,8 @@ func updateVPNConf(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID, p
 		return fmt.Errorf("exec: %w", err)
 	}
 
-	if _, err := tx.Exec(ctx, sqlSetFinalizer, brigadeID); err != nil {
-		return fmt.Errorf("exec finalizer: %w", err)
-	}
-
-	if _, err := tx.Exec(ctx, addSetVipAction, brigadeID, "begin", ""); err != nil {
-		return fmt.Errorf("exec action: %w", err)
+	if err := finalizeVIP(ctx, tx, sqlSetFinalizer, brigadeID, core.VIPEventBegin); err != nil {
+		return fmt.Errorf("finalize: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -221,7 +223,7 @@ JOIN
 LEFT JOIN
 	head.vip_telegram_ids vt ON b.brigade_id = vt.brigade_id
 LEFT JOIN
-	head.vip_messages vm ON b.brigade_id = vm.brigade_id AND vm.finalizer = false
+	head.vip_messages vm ON b.brigade_id = vm.brigade_id AND vm.msg_type = 'config' AND vm.finalizer = false
 LEFT JOIN
 	head.brigadier_realms br ON b.brigade_id = br.brigade_id AND br.featured = true
 WHERE 
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry"
+	"github.com/vpngen/ministry/internal/core"
+)
+
+const (
+	graceEndingRemind = 1 // hours
+)
+
+// The brigade is notified once per message type and VIP expiration.
+const sqlNotNotified = `
+	AND NOT EXISTS (
+		SELECT 1 FROM head.brigadier_vip_actions a
+		WHERE
+			a.brigade_id = bv.brigade_id
+			AND a.event_name = 'notify'
+			AND a.event_info = $1
+			AND a.new_expire = bv.vip_expire
+	)
+`
+
+const sqlExpiringVIP = `
+SELECT
+	bv.brigade_id,
+	bv.vip_expire,
+	bv.vip_users,
+	COALESCE(p.grace_hours, $2)
+FROM
+	head.brigadier_vip bv
+LEFT JOIN
+	head.vip_plans p ON bv.vip_variant = p.plan_id
+WHERE
+	bv.finalizer = true
+	AND bv.vip_expire > (NOW() AT TIME ZONE 'UTC')
+	AND bv.vip_expire <= (NOW() AT TIME ZONE 'UTC' + $3 * INTERVAL '1 DAY')
+` + sqlNotNotified
+
+const sqlExpiredVIP = `
+SELECT
+	bv.brigade_id,
+	bv.vip_expire,
+	bv.vip_users,
+	COALESCE(p.grace_hours, $2)
+FROM
+	head.brigadier_vip bv
+LEFT JOIN
+	head.vip_plans p ON bv.vip_variant = p.plan_id
+WHERE
+	bv.finalizer = true
+	AND bv.vip_expire <= (NOW() AT TIME ZONE 'UTC')
+	AND bv.vip_expire > (NOW() AT TIME ZONE 'UTC' - COALESCE(p.grace_hours, $2) * INTERVAL '1 HOUR')
+` + sqlNotNotified
+
+const sqlGraceEndingVIP = `
+SELECT
+	bv.brigade_id,
+	bv.vip_expire,
+	bv.vip_users,
+	COALESCE(p.grace_hours, $2)
+FROM
+	head.brigadier_vip bv
+LEFT JOIN
+	head.vip_plans p ON bv.vip_variant = p.plan_id
+WHERE
+	bv.finalizer = true
+	AND bv.vip_expire + COALESCE(p.grace_hours, $2) * INTERVAL '1 HOUR' > (NOW() AT TIME ZONE 'UTC')
+	AND bv.vip_expire + COALESCE(p.grace_hours, $2) * INTERVAL '1 HOUR' <= (NOW() AT TIME ZONE 'UTC' + $3 * INTERVAL '1 HOUR')
+` + sqlNotNotified
+
+const sqlQueueNotice = `
+INSERT INTO
+	head.vip_messages
+		(brigade_id, msg_type, payload, finalizer, last_try)
+	VALUES
+		($1, $2, $3, true, NOW() AT TIME ZONE 'UTC' - INTERVAL '1 HOUR')
+ON CONFLICT (brigade_id, msg_type) DO UPDATE
+	SET
+		payload = EXCLUDED.payload,
+		finalizer = true,
+		last_try = EXCLUDED.last_try,
+		push_attempts = 0,
+		push_next = NOW() AT TIME ZONE 'UTC',
+		push_error = '',
+		lease_id = NULL,
+		lease_until = NULL
+`
+
+type noticeCandidate struct {
+	BrigadeID  uuid.UUID
+	ExpireAt   time.Time
+	Users      int
+	GraceHours int
+}
+
+// notify - queues reminder messages for VIP brigades.
+func notify(ctx context.Context, db *pgxpool.Pool, remindDays int, silent bool) error {
+	kinds := []struct {
+		msgType string
+		query   string
+		args    []any
+	}{
+		{msgType: ministry.VIPMessageExpiring, query: sqlExpiringVIP, args: []any{remindDays}},
+		{msgType: ministry.VIPMessageExpired, query: sqlExpiredVIP},
+		{msgType: ministry.VIPMessageGraceEnding, query: sqlGraceEndingVIP, args: []any{graceEndingRemind}},
+	}
+
+	for _, kind := range kinds {
+		if kind.msgType == ministry.VIPMessageExpiring && remindDays <= 0 {
+			continue
+		}
+
+		args := append([]any{kind.msgType, redemtionPeriod}, kind.args...)
+
+		candidates, err := getNoticeCandidates(ctx, db, kind.query, args...)
+		if err != nil {
+			return fmt.Errorf("get %s candidates: %w", kind.msgType, err)
+		}
+
+		if !silent || len(candidates) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: Found %d brigades to notify: %s\n", LogTag, len(candidates), kind.msgType)
+		}
+
+		for _, c := range candidates {
+			graceUntil := c.ExpireAt.Add(time.Duration(c.GraceHours) * time.Hour)
+			notice := &ministry.VIPNotice{
+				ExpireAt:   &c.ExpireAt,
+				GraceUntil: &graceUntil,
+				Users:      c.Users,
+			}
+
+			if kind.msgType == ministry.VIPMessageExpiring {
+				notice.DaysLeft = int(time.Until(c.ExpireAt).Hours()/24) + 1
+			}
+
+			if err := func() error {
+				tx, err := db.Begin(ctx)
+				if err != nil {
+					return fmt.Errorf("begin: %w", err)
+				}
+
+				defer tx.Rollback(ctx)
+
+				if err := queueNotice(ctx, tx, c.BrigadeID, kind.msgType, notice); err != nil {
+					return fmt.Errorf("queue: %w", err)
+				}
+
+				return tx.Commit(ctx)
+			}(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: Can't notify brigade %s: %s: %s\n", LogTag, c.BrigadeID, kind.msgType, err)
+
+				continue
+			}
+		}
+	}
+
+	return nil
+}
+
+func getNoticeCandidates(ctx context.Context, db *pgxpool.Pool, query string, args ...any) ([]noticeCandidate, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	var c noticeCandidate
+
+	candidates := make([]noticeCandidate, 0)
+
+	if _, err := pgx.ForEachRow(rows, []any{&c.BrigadeID, &c.ExpireAt, &c.Users, &c.GraceHours}, func() error {
+		candidates = append(candidates, c)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("foreach: %w", err)
+	}
+
+	return candidates, nil
+}
+
+// queueNotice - puts the message into the outbox, the pending message
+// of the same type is replaced, and records the notify event.
+func queueNotice(ctx context.Context, tx pgx.Tx, brigadeID uuid.UUID, msgType string, notice *ministry.VIPNotice) error {
+	payload, err := json.Marshal(notice)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, sqlQueueNotice, brigadeID, msgType, string(payload)); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	ev := core.VIPEvent{
+		BrigadeID: brigadeID,
+		Name:      core.VIPEventNotify,
+		Info:      msgType,
+		NewExpire: notice.ExpireAt,
+	}
+
+	if notice.Users > 0 {
+		ev.NewUsers = &notice.Users
+	}
+
+	if err := core.AddVIPEvent(ctx, tx, ev); err != nil {
+		return fmt.Errorf("event: %w", err)
+	}
+
+	return nil
+}
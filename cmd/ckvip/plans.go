@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// vipPlan - the record of head.vip_plans.
+type vipPlan struct {
+	ID         string
+	Seats      int // seats per purchase
+	MaxSeats   int // 0 means no limit
+	GraceHours int
+	Features   string
+}
+
+const sqlPlans = `
+SELECT
+	plan_id,
+	seats,
+	max_seats,
+	grace_hours,
+	features
+FROM
+	head.vip_plans
+`
+
+const sqlPlanProducts = `
+SELECT
+	product,
+	plan_id
+FROM
+	head.vip_plan_products
+`
+
+// loadPlans - loads plans and the products to plans mapping.
+func loadPlans(ctx context.Context, db *pgxpool.Pool) (map[string]vipPlan, map[string]string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlPlans)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query plans: %w", err)
+	}
+
+	var plan vipPlan
+
+	plans := make(map[string]vipPlan)
+
+	if _, err := pgx.ForEachRow(rows, []any{&plan.ID, &plan.Seats, &plan.MaxSeats, &plan.GraceHours, &plan.Features}, func() error {
+		plans[plan.ID] = plan
+
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("foreach plans: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, sqlPlanProducts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query products: %w", err)
+	}
+
+	var product, planID string
+
+	products := make(map[string]string)
+
+	if _, err := pgx.ForEachRow(rows, []any{&product, &planID}, func() error {
+		products[product] = planID
+
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("foreach products: %w", err)
+	}
+
+	return plans, products, nil
+}
+
+// applyPlans - resolves the plan of every entitlement and applies
+// the plan seats. The plan given by the source has priority over
+// the plan of the product. Entitlements without a plan are left as is.
+// The payment sources report purchases, the seats per purchase are
+// applied to them, the DB grants already hold the seats count.
+func applyPlans(brigades map[uuid.UUID]VipBrigade, plans map[string]vipPlan, products map[string]string) {
+	for id, brigade := range brigades {
+		planID := brigade.Plan
+		if planID == "" {
+			planID = products[brigade.Product]
+		}
+
+		if planID == "" {
+			continue
+		}
+
+		plan, ok := plans[planID]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: Warning: Brigade %s: unknown plan %q, ignored\n", LogTag, id, planID)
+
+			brigade.Plan = ""
+			brigades[id] = brigade
+
+			continue
+		}
+
+		brigade.Plan = plan.ID
+		if plan.Seats > 0 && brigade.Source != sourceDB {
+			brigade.UsersCount *= plan.Seats
+		}
+
+		if plan.MaxSeats > 0 && brigade.UsersCount > plan.MaxSeats {
+			brigade.UsersCount = plan.MaxSeats
+		}
+
+		brigades[id] = brigade
+	}
+}
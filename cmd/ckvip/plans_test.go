@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestApplyPlans(t *testing.T) {
+	plans := map[string]vipPlan{
+		"family": {ID: "family", Seats: 5},
+		"team":   {ID: "team", Seats: 3, MaxSeats: 10},
+		"free":   {ID: "free"},
+	}
+
+	products := map[string]string{
+		"299": "family",
+		"999": "team",
+	}
+
+	tests := []struct {
+		name      string
+		brigade   VipBrigade
+		wantPlan  string
+		wantUsers int
+	}{
+		{
+			name:      "no plan",
+			brigade:   VipBrigade{UsersCount: 2, Product: "100", Source: sourceEndpoint},
+			wantPlan:  "",
+			wantUsers: 2,
+		},
+		{
+			name:      "plan of product",
+			brigade:   VipBrigade{UsersCount: 2, Product: "299", Source: sourceEndpoint},
+			wantPlan:  "family",
+			wantUsers: 10,
+		},
+		{
+			name:      "source plan over product",
+			brigade:   VipBrigade{UsersCount: 2, Product: "299", Plan: "team", Source: sourceFile},
+			wantPlan:  "team",
+			wantUsers: 6,
+		},
+		{
+			name:      "max seats",
+			brigade:   VipBrigade{UsersCount: 4, Product: "999", Source: sourceEndpoint},
+			wantPlan:  "team",
+			wantUsers: 10,
+		},
+		{
+			name:      "zero seats",
+			brigade:   VipBrigade{UsersCount: 3, Plan: "free", Source: sourceEndpoint},
+			wantPlan:  "free",
+			wantUsers: 3,
+		},
+		{
+			name:      "db grant holds seats",
+			brigade:   VipBrigade{UsersCount: 5, Plan: "family", Source: sourceDB},
+			wantPlan:  "family",
+			wantUsers: 5,
+		},
+		{
+			name:      "db grant max seats",
+			brigade:   VipBrigade{UsersCount: 12, Plan: "team", Source: sourceDB},
+			wantPlan:  "team",
+			wantUsers: 10,
+		},
+		{
+			name:      "unknown plan",
+			brigade:   VipBrigade{UsersCount: 2, Plan: "gold", Source: sourceDB},
+			wantPlan:  "",
+			wantUsers: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := uuid.New()
+			brigades := map[uuid.UUID]VipBrigade{id: tt.brigade}
+
+			applyPlans(brigades, plans, products)
+
+			got := brigades[id]
+			if got.Plan != tt.wantPlan {
+				t.Errorf("plan: got %q, want %q", got.Plan, tt.wantPlan)
+			}
+
+			if got.UsersCount != tt.wantUsers {
+				t.Errorf("users: got %d, want %d", got.UsersCount, tt.wantUsers)
+			}
+		})
+	}
+}
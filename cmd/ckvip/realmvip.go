@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"net/netip"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/core"
+	"golang.org/x/crypto/ssh"
+)
+
+// realmVIPList - the answer of the realm viplist command,
+// the brigades the realm keeps in VIP mode.
+type realmVIPList struct {
+	Brigades []string `json:"brigades"`
+}
+
+type vipRealm struct {
+	ID   uuid.UUID
+	Name string
+	Addr netip.AddrPort
+}
+
+// realmVIPState - the VIP brigades reported by the realms.
+// The brigade maps to the realm it is found on,
+// the unreachable realms map to the error.
+type realmVIPState struct {
+	Realms      []vipRealm
+	Brigades    map[uuid.UUID]uuid.UUID
+	Unreachable map[uuid.UUID]error
+}
+
+const sqlActiveRealms = `
+SELECT
+	realm_id,
+	realm_name,
+	control_ip
+FROM
+	head.realms
+WHERE
+	is_active = true
+ORDER BY
+	realm_name
+`
+
+func getActiveRealms(ctx context.Context, db *pgxpool.Pool) ([]vipRealm, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlActiveRealms)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		realm vipRealm
+		ip    netip.Addr
+	)
+
+	realms := make([]vipRealm, 0)
+
+	if _, err := pgx.ForEachRow(rows, []any{&realm.ID, &realm.Name, &ip}, func() error {
+		realm.Addr = netip.AddrPortFrom(ip, core.DefaultRealmsPort)
+		realms = append(realms, realm)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("foreach: %w", err)
+	}
+
+	return realms, nil
+}
+
+func callRealmVIPList(ctx context.Context, sshconf *ssh.ClientConfig, tag string, addr netip.AddrPort) ([]uuid.UUID, error) {
+	out, err := runRealmCommand(ctx, sshconf, tag, addr, "viplist -ch -j")
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := io.ReadAll(bufio.NewReader(httputil.NewChunkedReader(bytes.NewReader(out))))
+	if err != nil {
+		return nil, fmt.Errorf("chunk read: %w", err)
+	}
+
+	var list realmVIPList
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return nil, fmt.Errorf("json unmarshal: %w", err)
+	}
+
+	brigades := make([]uuid.UUID, 0, len(list.Brigades))
+
+	for _, bid := range list.Brigades {
+		buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(bid)
+		if err != nil {
+			return nil, fmt.Errorf("decode brigade id %q: %w", bid, err)
+		}
+
+		id, err := uuid.FromBytes(buf)
+		if err != nil {
+			return nil, fmt.Errorf("brigade id %q: %w", bid, err)
+		}
+
+		brigades = append(brigades, id)
+	}
+
+	return brigades, nil
+}
+
+// fetchRealmVIPState - asks every active realm for its VIP brigades.
+// An unreachable realm doesn't fail the whole fetch.
+func fetchRealmVIPState(ctx context.Context, db *pgxpool.Pool, sshconf *ssh.ClientConfig) (*realmVIPState, error) {
+	realms, err := getActiveRealms(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("get realms: %w", err)
+	}
+
+	state := &realmVIPState{
+		Realms:      realms,
+		Brigades:    make(map[uuid.UUID]uuid.UUID),
+		Unreachable: make(map[uuid.UUID]error),
+	}
+
+	for _, realm := range realms {
+		brigades, err := callRealmVIPList(ctx, sshconf, LogTag, realm.Addr)
+		if err != nil {
+			state.Unreachable[realm.ID] = err
+
+			continue
+		}
+
+		for _, id := range brigades {
+			state.Brigades[id] = realm.ID
+		}
+	}
+
+	return state, nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/ssh"
+)
+
+type reconcileOpts struct {
+	enabled      bool
+	repair       bool
+	json         bool
+	onlyMismatch bool
+}
+
+// Reconcile mismatch classes.
+const (
+	mismatchNoRecord         = "no_record"         // paid, but there is no VIP record
+	mismatchExpire           = "expire_drift"      // paid expiration differs from the record
+	mismatchSeats            = "seats_drift"       // paid users count differs from the record
+	mismatchNotPaid          = "not_paid"          // active record the payment sources don't know
+	mismatchNotApplied       = "not_applied"       // active record, realm vipon is pending
+	mismatchRealmMissing     = "realm_missing"     // finalizer is set, the realm doesn't report VIP
+	mismatchRealmExtra       = "realm_extra"       // the realm reports VIP, finalizer is not set
+	mismatchRealmUnreachable = "realm_unreachable" // the realm VIP state is unknown
+)
+
+// Reconcile repair actions.
+const (
+	repairRecords   = "records"   // VIP records updated from the payment sources
+	repairVipon     = "vipon"     // realm vipon called
+	repairVipoff    = "vipoff"    // realm vipoff called
+	repairFinalizer = "finalizer" // finalizer set after the realm state
+)
+
+type providerState struct {
+	Expire time.Time `json:"expire"`
+	Users  int       `json:"users"`
+	Plan   string    `json:"plan,omitempty"`
+	Source string    `json:"source,omitempty"`
+}
+
+type dbState struct {
+	Expire     time.Time  `json:"expire"`
+	Users      int        `json:"users"`
+	Plan       string     `json:"plan,omitempty"`
+	Features   string     `json:"-"`
+	GraceHours int        `json:"grace_hours"`
+	Finalizer  bool       `json:"finalizer"`
+	Deleted    bool       `json:"deleted,omitempty"`
+	RealmID    *uuid.UUID `json:"realm_id,omitempty"`
+	Messages   []string   `json:"messages,omitempty"`
+}
+
+type realmState struct {
+	RealmID   uuid.UUID `json:"realm_id"`
+	Reachable bool      `json:"reachable"`
+	VIP       bool      `json:"vip"`
+}
+
+type reconcileRow struct {
+	BrigadeID  uuid.UUID      `json:"brigade_id"`
+	Provider   *providerState `json:"provider,omitempty"`
+	DB         *dbState       `json:"db,omitempty"`
+	Realm      *realmState    `json:"realm,omitempty"`
+	Mismatches []string       `json:"mismatches,omitempty"`
+	Repaired   []string       `json:"repaired,omitempty"`
+}
+
+const sqlReconcileVIP = `
+SELECT
+	bv.brigade_id,
+	bv.vip_expire,
+	bv.vip_users,
+	COALESCE(p.plan_id, ''),
+	COALESCE(p.features, ''),
+	COALESCE(p.grace_hours, $1),
+	bv.finalizer,
+	d.brigade_id IS NOT NULL,
+	br.realm_id,
+	COALESCE((
+		SELECT string_agg(vm.msg_type, ',' ORDER BY vm.msg_type)
+		FROM head.vip_messages vm
+		WHERE vm.brigade_id = bv.brigade_id AND vm.finalizer = true
+	), '')
+FROM
+	head.brigadier_vip bv
+LEFT JOIN
+	head.vip_plans p ON bv.vip_variant = p.plan_id
+LEFT JOIN
+	head.deleted_brigadiers d ON bv.brigade_id = d.brigade_id
+LEFT JOIN
+	head.brigadier_realms br ON bv.brigade_id = br.brigade_id AND br.featured = true
+`
+
+func getDBVIPState(ctx context.Context, db *pgxpool.Pool) (map[uuid.UUID]*dbState, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlReconcileVIP, redemtionPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		brigadeID uuid.UUID
+		s         dbState
+		messages  string
+	)
+
+	states := make(map[uuid.UUID]*dbState)
+
+	if _, err := pgx.ForEachRow(rows, []any{
+		&brigadeID, &s.Expire, &s.Users, &s.Plan, &s.Features, &s.GraceHours,
+		&s.Finalizer, &s.Deleted, &s.RealmID, &messages,
+	}, func() error {
+		state := s
+		if messages != "" {
+			state.Messages = strings.Split(messages, ",")
+		}
+
+		states[brigadeID] = &state
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("foreach: %w", err)
+	}
+
+	return states, nil
+}
+
+// buildReconcileRows - joins the three states per brigade and flags the mismatches.
+func buildReconcileRows(paid map[uuid.UUID]VipBrigade, dbs map[uuid.UUID]*dbState, realms *realmVIPState, now time.Time) []*reconcileRow {
+	ids := make(map[uuid.UUID]struct{}, len(dbs))
+
+	for id := range paid {
+		ids[id] = struct{}{}
+	}
+
+	for id := range dbs {
+		ids[id] = struct{}{}
+	}
+
+	for id := range realms.Brigades {
+		ids[id] = struct{}{}
+	}
+
+	rows := make([]*reconcileRow, 0, len(ids))
+
+	for id := range ids {
+		row := &reconcileRow{BrigadeID: id}
+
+		if p, ok := paid[id]; ok {
+			row.Provider = &providerState{
+				Expire: p.ExpiredAt,
+				Users:  p.UsersCount,
+				Plan:   p.Plan,
+				Source: p.Source,
+			}
+		}
+
+		row.DB = dbs[id]
+
+		switch realmID, vip := realms.Brigades[id]; {
+		case vip:
+			row.Realm = &realmState{RealmID: realmID, Reachable: true, VIP: true}
+		case row.DB != nil && row.DB.RealmID != nil:
+			_, unreachable := realms.Unreachable[*row.DB.RealmID]
+			row.Realm = &realmState{RealmID: *row.DB.RealmID, Reachable: !unreachable}
+		}
+
+		row.Mismatches = classify(row, now)
+
+		rows = append(rows, row)
+	}
+
+	slices.SortFunc(rows, func(a, b *reconcileRow) int {
+		return strings.Compare(a.BrigadeID.String(), b.BrigadeID.String())
+	})
+
+	return rows
+}
+
+func classify(row *reconcileRow, now time.Time) []string {
+	var mismatches []string
+
+	p, d, r := row.Provider, row.DB, row.Realm
+
+	switch {
+	case p != nil && d == nil:
+		if p.Expire.After(now) {
+			mismatches = append(mismatches, mismatchNoRecord)
+		}
+	case p != nil && d != nil:
+		if p.Expire.Sub(d.Expire).Abs() > time.Second {
+			mismatches = append(mismatches, mismatchExpire)
+		}
+
+		if p.Users != d.Users {
+			mismatches = append(mismatches, mismatchSeats)
+		}
+	case p == nil && d != nil:
+		if d.Expire.After(now) {
+			mismatches = append(mismatches, mismatchNotPaid)
+		}
+	}
+
+	if d != nil && !d.Finalizer && !d.Deleted && d.Expire.After(now) {
+		mismatches = append(mismatches, mismatchNotApplied)
+	}
+
+	switch {
+	case r != nil && !r.Reachable:
+		mismatches = append(mismatches, mismatchRealmUnreachable)
+	case r != nil && r.VIP && (d == nil || !d.Finalizer):
+		mismatches = append(mismatches, mismatchRealmExtra)
+	case d != nil && d.Finalizer && (r == nil || !r.VIP):
+		mismatches = append(mismatches, mismatchRealmMissing)
+	}
+
+	return mismatches
+}
+
+// reconcile - builds the provider/DB/realm report and optionally repairs the drift.
+func reconcile(ctx context.Context, cfg *config, db *pgxpool.Pool, sshconf *ssh.ClientConfig, sources []PaymentSource) error {
+	paid, err := fetch(ctx, cfg, sources)
+	if err != nil {
+		return fmt.Errorf("can't fetch paid users: %w", err)
+	}
+
+	plans, products, err := loadPlans(ctx, db)
+	if err != nil {
+		return fmt.Errorf("can't load VIP plans: %w", err)
+	}
+
+	applyPlans(paid, plans, products)
+
+	dbs, err := getDBVIPState(ctx, db)
+	if err != nil {
+		return fmt.Errorf("can't get VIP records: %w", err)
+	}
+
+	realms, err := fetchRealmVIPState(ctx, db, sshconf)
+	if err != nil {
+		return fmt.Errorf("can't get realms VIP state: %w", err)
+	}
+
+	for realmID, err := range realms.Unreachable {
+		fmt.Fprintf(os.Stderr, "%s: Realm %s is unreachable: %s\n", LogTag, realmID, err)
+	}
+
+	rows := buildReconcileRows(paid, dbs, realms, time.Now().UTC())
+
+	if cfg.reconcile.repair {
+		if err := repairDrift(ctx, cfg, db, sshconf, paid, realms, rows); err != nil {
+			return fmt.Errorf("can't repair: %w", err)
+		}
+	}
+
+	if cfg.reconcile.onlyMismatch {
+		rows = slices.DeleteFunc(rows, func(row *reconcileRow) bool {
+			return len(row.Mismatches) == 0
+		})
+	}
+
+	if cfg.reconcile.json {
+		payload, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+
+		fmt.Fprintf(os.Stdout, "%s\n", payload)
+
+		return nil
+	}
+
+	printReconcile(os.Stdout, rows)
+
+	return nil
+}
+
+// repairDrift - brings the records in line with the payment sources
+// and the realms in line with the records.
+func repairDrift(ctx context.Context, cfg *config, db *pgxpool.Pool, sshconf *ssh.ClientConfig,
+	paid map[uuid.UUID]VipBrigade, realms *realmVIPState, rows []*reconcileRow,
+) error {
+	recordsDrift := func(row *reconcileRow) bool {
+		return slices.ContainsFunc(row.Mismatches, func(m string) bool {
+			return m == mismatchNoRecord || m == mismatchExpire || m == mismatchSeats || m == mismatchNotPaid
+		})
+	}
+
+	// as the regular run, never reset all the records after an empty fetch
+	if len(paid) > 0 && slices.ContainsFunc(rows, recordsDrift) {
+		if err := updateVIPRecords(ctx, db, paid, cfg.silent); err != nil {
+			return fmt.Errorf("update records: %w", err)
+		}
+
+		for _, row := range rows {
+			if recordsDrift(row) {
+				row.Repaired = append(row.Repaired, repairRecords)
+			}
+		}
+	}
+
+	addrs := make(map[uuid.UUID]vipRealm, len(realms.Realms))
+	for _, realm := range realms.Realms {
+		addrs[realm.ID] = realm
+	}
+
+	for _, row := range rows {
+		r := row.Realm
+		if r == nil || !r.Reachable {
+			continue
+		}
+
+		realm, ok := addrs[r.RealmID]
+		if !ok {
+			continue
+		}
+
+		var reportedVIP bool
+
+		switch {
+		case slices.Contains(row.Mismatches, mismatchRealmMissing):
+		case slices.Contains(row.Mismatches, mismatchRealmExtra):
+			reportedVIP = true
+		default:
+			continue
+		}
+
+		action, err := correctRealm(ctx, db, sshconf, realm, row.BrigadeID, row.DB, reportedVIP, cfg.fixUnknown)
+		if action != "" {
+			row.Repaired = append(row.Repaired, action)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Can't correct brigade %s on realm %s: %s\n", LogTag, row.BrigadeID, realm.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func printReconcile(w io.Writer, rows []*reconcileRow) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "BRIGADE\tPAID\tDB\tMESSAGES\tREALM\tMISMATCH\tREPAIRED\n")
+
+	for _, row := range rows {
+		paid := "-"
+		if p := row.Provider; p != nil {
+			paid = fmt.Sprintf("%s/%d", p.Expire.Format(time.RFC3339), p.Users)
+		}
+
+		record, messages := "-", "-"
+		if d := row.DB; d != nil {
+			record = fmt.Sprintf("%s/%d", d.Expire.Format(time.RFC3339), d.Users)
+			if d.Finalizer {
+				record += "/F"
+			}
+
+			if d.Deleted {
+				record += "/D"
+			}
+
+			if len(d.Messages) > 0 {
+				messages = strings.Join(d.Messages, ",")
+			}
+		}
+
+		realm := "-"
+		if r := row.Realm; r != nil {
+			switch {
+			case !r.Reachable:
+				realm = "?"
+			case r.VIP:
+				realm = "vip"
+			default:
+				realm = "off"
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", row.BrigadeID, paid, record, messages, realm,
+			dashIfEmpty(strings.Join(row.Mismatches, ",")), dashIfEmpty(strings.Join(row.Repaired, ",")))
+	}
+
+	tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	sourceEndpoint = "endpoint"
+	sourceFile     = "file"
+	sourceDB       = "db"
+)
+
+var ErrUnknownSource = errors.New("unknown payment source")
+
+// PaymentSource - yields normalized VIP entitlements.
+type PaymentSource interface {
+	Name() string
+	Fetch(ctx context.Context) (map[uuid.UUID]VipBrigade, error)
+}
+
+// newPaymentSources - creates payment sources from VIP_SOURCES spec list,
+// e.g. "endpoint,file:/etc/vgdept/vip_grants.csv,db".
+// The order of the list defines the sources precedence.
+func newPaymentSources(cfg *config, c *http.Client, db *pgxpool.Pool) ([]PaymentSource, error) {
+	sources := make([]PaymentSource, 0, len(cfg.vipSources))
+
+	for _, spec := range cfg.vipSources {
+		kind, arg, _ := strings.Cut(spec, ":")
+
+		switch kind {
+		case sourceEndpoint:
+			sources = append(sources, &endpointSource{
+				client:   c,
+				endpoint: cfg.vipEndpoint,
+				codec:    cfg.codec,
+				debug:    cfg.debug,
+			})
+		case sourceFile:
+			if arg == "" {
+				return nil, fmt.Errorf("%w: %s: empty path", ErrUnknownSource, spec)
+			}
+
+			sources = append(sources, &fileSource{path: arg})
+		case sourceDB:
+			sources = append(sources, &dbSource{db: db})
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSource, spec)
+		}
+	}
+
+	return sources, nil
+}
+
+// fetchEntitlements - fetches entitlements from all sources and merges them.
+// Any failed source fails the whole fetch, otherwise brigades known only
+// to the failed source would be treated as expired.
+func fetchEntitlements(ctx context.Context, sources []PaymentSource) (map[uuid.UUID]VipBrigade, error) {
+	fetched := make([]map[uuid.UUID]VipBrigade, 0, len(sources))
+
+	for _, src := range sources {
+		brigades, err := src.Fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", src.Name(), err)
+		}
+
+		fetched = append(fetched, brigades)
+	}
+
+	return mergeEntitlements(fetched), nil
+}
+
+// mergeEntitlements - merges entitlements ordered by source precedence.
+// The most preferred source, that knows the brigade, defines
+// users count and product. The expiration is the latest one among
+// all sources, so a less preferred source can extend VIP, but never cut it.
+func mergeEntitlements(fetched []map[uuid.UUID]VipBrigade) map[uuid.UUID]VipBrigade {
+	merged := make(map[uuid.UUID]VipBrigade)
+
+	for _, brigades := range fetched {
+		for id, brigade := range brigades {
+			known, ok := merged[id]
+			if !ok {
+				merged[id] = brigade
+
+				continue
+			}
+
+			if known.ExpiredAt.Before(brigade.ExpiredAt) {
+				known.ExpiredAt = brigade.ExpiredAt
+				merged[id] = known
+			}
+		}
+	}
+
+	return merged
+}
+
+// addEntitlement - adds the entitlement to the map of one source,
+// several purchases for the same brigade are summed up.
+func addEntitlement(brigades map[uuid.UUID]VipBrigade, e VipBrigade) {
+	brigade, ok := brigades[e.BrigadeID]
+	if !ok {
+		brigades[e.BrigadeID] = e
+
+		return
+	}
+
+	if brigade.ExpiredAt.Before(e.ExpiredAt) {
+		brigade.ExpiredAt = e.ExpiredAt
+		brigade.Product = e.Product
+	}
+
+	brigade.UsersCount += e.UsersCount
+
+	brigades[e.BrigadeID] = brigade
+}
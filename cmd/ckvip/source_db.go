@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const sqlActiveGrants = `
+SELECT
+	g.brigade_id,
+	g.vip_expire,
+	g.vip_users,
+	g.product,
+	COALESCE(g.vip_variant, '')
+FROM
+	head.brigadier_vip_grants g
+WHERE
+	g.vip_expire > (NOW() AT TIME ZONE 'UTC')
+`
+
+// dbSource - manual grants from head.brigadier_vip_grants table.
+type dbSource struct {
+	db *pgxpool.Pool
+}
+
+func (s *dbSource) Name() string {
+	return sourceDB
+}
+
+func (s *dbSource) Fetch(ctx context.Context) (map[uuid.UUID]VipBrigade, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlActiveGrants)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		brigadeID  uuid.UUID
+		expiredAt  time.Time
+		usersCount int
+		product    string
+		plan       string
+	)
+
+	brigades := make(map[uuid.UUID]VipBrigade)
+
+	if _, err := pgx.ForEachRow(rows, []any{&brigadeID, &expiredAt, &usersCount, &product, &plan}, func() error {
+		addEntitlement(brigades, VipBrigade{
+			RawBrigadeID: brigadeID,
+			BrigadeID:    brigadeID,
+			ExpiredAt:    expiredAt,
+			UsersCount:   usersCount,
+			Product:      product,
+			Plan:         plan,
+			Source:       sourceDB,
+		})
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("foreach: %w", err)
+	}
+
+	return brigades, nil
+}
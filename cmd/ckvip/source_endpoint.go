@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/vpngen/ministry/internal/idcodec"
+)
+
+var ErrInvalidResponse = errors.New("invalid response")
+
+// endpointSource - the payment service HTTPS endpoint.
+type endpointSource struct {
+	client   *http.Client
+	endpoint string
+	codec    *idcodec.Codec
+	debug    bool
+}
+
+func (s *endpointSource) Name() string {
+	return sourceEndpoint
+}
+
+func (s *endpointSource) Fetch(ctx context.Context) (map[uuid.UUID]VipBrigade, error) {
+	brigades, raw, err := fetchPaidUsers(ctx, s.client, s.codec, s.endpoint)
+	if s.debug && raw != nil {
+		fmt.Fprintf(os.Stdout, "%s\n", raw)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fetch paid users: %w", err)
+	}
+
+	return brigades, nil
+}
+
+func fetchPaidUsers(ctx context.Context, c *http.Client, codec *idcodec.Codec, ep string) (map[uuid.UUID]VipBrigade, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+ep, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	// OBFS_UUID="d8fc0859-c1a4-4d29-94e1-4fdea70ff8b8"
+	// fmt.Printf("%s\n", payload)
+
+	var userList PaidUsersPesponse
+	if err := json.Unmarshal(payload, &userList); err != nil {
+		return nil, payload, fmt.Errorf("unmarshal response body: %w", err)
+	}
+
+	if userList.Result != "success" {
+		return nil, payload, fmt.Errorf("%w: %s", ErrInvalidResponse, userList.Result)
+	}
+
+	brigades := make(map[uuid.UUID]VipBrigade, 0)
+	for _, u := range userList.Data {
+		// The user failed to decode would be reset as expired,
+		// so it fails the source instead of being skipped.
+		brigadeID, err := codec.Decode(u.UserID)
+		if err != nil {
+			return nil, payload, fmt.Errorf("decode user %s: %w", u.UserID, err)
+		}
+
+		addEntitlement(brigades, VipBrigade{
+			RawBrigadeID: u.UserID,
+			BrigadeID:    brigadeID,
+			ExpiredAt:    u.GoodExpiryDateTime,
+			UsersCount:   1,
+			Product:      u.ProductPrice,
+			Source:       sourceEndpoint,
+		})
+	}
+
+	return brigades, payload, nil
+}
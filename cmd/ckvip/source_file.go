@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidRecord = errors.New("invalid record")
+
+// fileSource - local JSON or CSV file with entitlements.
+//
+// JSON: [{"brigade_id": "...", "expiration": "2006-01-02T15:04:05Z", "users_count": 1, "product": "...", "plan": "..."}]
+// CSV: brigade_id,expiration,users_count[,product[,plan]], lines started with # are ignored.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) Name() string {
+	return sourceFile + ":" + s.path
+}
+
+func (s *fileSource) Fetch(_ context.Context) (map[uuid.UUID]VipBrigade, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	defer f.Close()
+
+	var list []VipBrigade
+
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".json":
+		list, err = readEntitlementsJSON(f)
+	default:
+		list, err = readEntitlementsCSV(f)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", s.path, err)
+	}
+
+	brigades := make(map[uuid.UUID]VipBrigade, len(list))
+	for _, e := range list {
+		if e.BrigadeID == uuid.Nil {
+			return nil, fmt.Errorf("%w: empty brigade id", ErrInvalidRecord)
+		}
+
+		if e.UsersCount <= 0 {
+			e.UsersCount = 1
+		}
+
+		e.RawBrigadeID = e.BrigadeID
+		e.Source = s.Name()
+
+		addEntitlement(brigades, e)
+	}
+
+	return brigades, nil
+}
+
+func readEntitlementsJSON(r io.Reader) ([]VipBrigade, error) {
+	var list []VipBrigade
+
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	return list, nil
+}
+
+func readEntitlementsCSV(r io.Reader) ([]VipBrigade, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+
+	list := make([]VipBrigade, 0, len(records))
+	for i, rec := range records {
+		if len(rec) < 3 || len(rec) > 5 {
+			return nil, fmt.Errorf("%w: line %d: %d fields", ErrInvalidRecord, i+1, len(rec))
+		}
+
+		id, err := uuid.Parse(rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: brigade id: %w", ErrInvalidRecord, i+1, err)
+		}
+
+		expiredAt, err := time.Parse(time.RFC3339, rec[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: expiration: %w", ErrInvalidRecord, i+1, err)
+		}
+
+		usersCount, err := strconv.Atoi(rec[2])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: users count: %w", ErrInvalidRecord, i+1, err)
+		}
+
+		e := VipBrigade{
+			BrigadeID:  id,
+			ExpiredAt:  expiredAt.UTC(),
+			UsersCount: usersCount,
+		}
+
+		if len(rec) >= 4 {
+			e.Product = rec[3]
+		}
+
+		if len(rec) == 5 {
+			e.Plan = rec[4]
+		}
+
+		list = append(list, e)
+	}
+
+	return list, nil
+}
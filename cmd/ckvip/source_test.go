@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMergeEntitlements(t *testing.T) {
+	id1 := uuid.MustParse("0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d")
+	id2 := uuid.MustParse("c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65")
+	id3 := uuid.MustParse("5e2f9b1c-8a4d-4c7e-b3f6-2d1a0e9c8b7a")
+
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	month := now.AddDate(0, 1, 0)
+	year := now.AddDate(1, 0, 0)
+
+	endpoint := map[uuid.UUID]VipBrigade{
+		id1: {BrigadeID: id1, ExpiredAt: month, UsersCount: 3, Product: "299", Source: sourceEndpoint},
+		id2: {BrigadeID: id2, ExpiredAt: year, UsersCount: 2, Product: "999", Source: sourceEndpoint},
+	}
+
+	file := map[uuid.UUID]VipBrigade{
+		id1: {BrigadeID: id1, ExpiredAt: year, UsersCount: 10, Product: "100", Source: sourceFile},
+		id2: {BrigadeID: id2, ExpiredAt: month, UsersCount: 10, Product: "100", Source: sourceFile},
+		id3: {BrigadeID: id3, ExpiredAt: month, UsersCount: 1, Source: sourceFile},
+	}
+
+	merged := mergeEntitlements([]map[uuid.UUID]VipBrigade{endpoint, file})
+
+	want := map[uuid.UUID]VipBrigade{
+		// the less preferred source extends the expiration only
+		id1: {BrigadeID: id1, ExpiredAt: year, UsersCount: 3, Product: "299", Source: sourceEndpoint},
+		// and never cuts it
+		id2: {BrigadeID: id2, ExpiredAt: year, UsersCount: 2, Product: "999", Source: sourceEndpoint},
+		// the brigade known to the less preferred source only
+		id3: {BrigadeID: id3, ExpiredAt: month, UsersCount: 1, Source: sourceFile},
+	}
+
+	if len(merged) != len(want) {
+		t.Fatalf("got %d brigades, want %d", len(merged), len(want))
+	}
+
+	for id, w := range want {
+		if got := merged[id]; got != w {
+			t.Errorf("%s: got %+v, want %+v", id, got, w)
+		}
+	}
+
+	if got := mergeEntitlements(nil); len(got) != 0 {
+		t.Errorf("no sources: got %d brigades", len(got))
+	}
+}
+
+func TestAddEntitlement(t *testing.T) {
+	id := uuid.MustParse("0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d")
+
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	brigades := make(map[uuid.UUID]VipBrigade)
+
+	addEntitlement(brigades, VipBrigade{BrigadeID: id, ExpiredAt: now.AddDate(0, 2, 0), UsersCount: 3, Product: "299"})
+	addEntitlement(brigades, VipBrigade{BrigadeID: id, ExpiredAt: now.AddDate(0, 1, 0), UsersCount: 2, Product: "100"})
+
+	want := VipBrigade{BrigadeID: id, ExpiredAt: now.AddDate(0, 2, 0), UsersCount: 5, Product: "299"}
+	if got := brigades[id]; got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	addEntitlement(brigades, VipBrigade{BrigadeID: id, ExpiredAt: now.AddDate(0, 3, 0), UsersCount: 1, Product: "999"})
+
+	want = VipBrigade{BrigadeID: id, ExpiredAt: now.AddDate(0, 3, 0), UsersCount: 6, Product: "999"}
+	if got := brigades[id]; got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
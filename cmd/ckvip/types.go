@@ -25,4 +25,7 @@ type VipBrigade struct {
 	BrigadeID    uuid.UUID `json:"brigade_id"`
 	ExpiredAt    time.Time `json:"expiration"`
 	UsersCount   int       `json:"users_count"`
+	Product      string    `json:"product,omitempty"`
+	Plan         string    `json:"plan,omitempty"`
+	Source       string    `json:"source,omitempty"`
 }
@@ -2,36 +2,75 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry"
+	"github.com/vpngen/ministry/internal/core"
 )
 
+// addVIP - the plan or seats change of the active VIP drops the finalizer,
+// so vipon is sent to the realm again with the new plan and seats.
+// The deleted brigade keeps it, otherwise it would be restored.
 const addVIP = `
 INSERT INTO 
 	head.brigadier_vip 
-		(brigade_id, vip_expire, vip_users)
+		(brigade_id, vip_expire, vip_users, vip_variant)
 	VALUES 
-		($1, $2, $3)
+		($1, $2, $3, NULLIF($4, ''))
 ON CONFLICT (brigade_id) DO UPDATE 
 	SET 
 		vip_expire = EXCLUDED.vip_expire,
-		vip_users = EXCLUDED.vip_users
+		vip_users = EXCLUDED.vip_users,
+		vip_variant = EXCLUDED.vip_variant,
+		finalizer = CASE
+			WHEN brigadier_vip.finalizer
+				AND EXCLUDED.vip_expire > (NOW() AT TIME ZONE 'UTC')
+				AND (brigadier_vip.vip_users IS DISTINCT FROM EXCLUDED.vip_users
+					OR brigadier_vip.vip_variant IS DISTINCT FROM EXCLUDED.vip_variant)
+				AND NOT EXISTS (
+					SELECT 1 FROM head.deleted_brigadiers d WHERE d.brigade_id = brigadier_vip.brigade_id
+				)
+			THEN false
+			ELSE brigadier_vip.finalizer
+		END
+`
+
+const getVIP = `
+SELECT
+	vip_expire,
+	vip_users
+FROM
+	head.brigadier_vip
+WHERE
+	brigade_id = $1
+FOR UPDATE
 `
 
 const purgeExpired = `
-DELETE FROM 
-	head.brigadier_vip 
-WHERE 
-	vip_expire < (NOW() AT TIME ZONE 'UTC' - $1 * INTERVAL '1 HOUR')
-	AND finalizer = false
+WITH purged AS (
+	DELETE FROM 
+		head.brigadier_vip bv
+	WHERE 
+		bv.vip_expire < (NOW() AT TIME ZONE 'UTC' - COALESCE(
+			(SELECT p.grace_hours FROM head.vip_plans p WHERE p.plan_id = bv.vip_variant), $1
+		) * INTERVAL '1 HOUR')
+		AND bv.finalizer = false
+	RETURNING
+		bv.brigade_id, bv.vip_expire, bv.vip_users
+)
+INSERT INTO
+	head.brigadier_vip_actions
+		(brigade_id, event_name, event_info, event_time, old_expire, old_users)
+SELECT
+	brigade_id, $2, '', NOW() AT TIME ZONE 'UTC', vip_expire, vip_users
+FROM
+	purged
 `
 
 const allVipBrigades = `
@@ -46,13 +85,26 @@ WHERE
 `
 
 const resetExpired = `
+WITH old AS (
+	SELECT
+		brigade_id, vip_expire
+	FROM
+		head.brigadier_vip
+	WHERE 
+		brigade_id = $1
+		AND vip_expire > (NOW() AT TIME ZONE 'UTC')
+	FOR UPDATE
+)
 UPDATE 
-	head.brigadier_vip
+	head.brigadier_vip bv
 SET 
 	vip_expire = NOW() AT TIME ZONE 'UTC'
+FROM
+	old
 WHERE 
-	brigade_id = $1
-	AND vip_expire > (NOW() AT TIME ZONE 'UTC')
+	bv.brigade_id = old.brigade_id
+RETURNING
+	old.vip_expire, bv.vip_expire, bv.vip_users
 `
 
 // set new VIP records or update old ones
@@ -69,14 +121,43 @@ func updateVIPRecords(ctx context.Context, db *pgxpool.Pool, brigades map[uuid.U
 			fmt.Fprintf(os.Stderr, "Brigade: %s (%s), ExpiredAt: %s, UsersCount: %d\n", brigade.BrigadeID, brigade.RawBrigadeID, brigade.ExpiredAt, brigade.UsersCount)
 		}
 
+		var (
+			oldExpire *time.Time
+			oldUsers  *int
+		)
+
+		if err := tx.QueryRow(ctx, getVIP, brigade.BrigadeID).Scan(&oldExpire, &oldUsers); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("get vip: %w", err)
+		}
+
 		// set or update existing VIP record
-		comm, err := tx.Exec(ctx, addVIP, brigade.BrigadeID, brigade.ExpiredAt, brigade.UsersCount)
+		comm, err := tx.Exec(ctx, addVIP, brigade.BrigadeID, brigade.ExpiredAt, brigade.UsersCount, brigade.Plan)
 		if err != nil {
 			return fmt.Errorf("set vip: %w", err)
 		}
 
 		if comm.RowsAffected() == 0 {
 			fmt.Fprintf(os.Stderr, "%s: Warning: No rows affected for VIP brigade: %s\n", LogTag, brigade.BrigadeID)
+
+			continue
+		}
+
+		for _, ev := range core.VIPChangeEvents(brigade.BrigadeID, brigade.Source, oldExpire, oldUsers, brigade.ExpiredAt, brigade.UsersCount) {
+			if err := core.AddVIPEvent(ctx, tx, ev); err != nil {
+				return fmt.Errorf("vip event: %w", err)
+			}
+
+			if ev.Name != core.VIPEventSeats || oldUsers == nil {
+				continue
+			}
+
+			if err := queueNotice(ctx, tx, brigade.BrigadeID, ministry.VIPMessageSeatsChanged, &ministry.VIPNotice{
+				ExpireAt: &brigade.ExpiredAt,
+				Users:    brigade.UsersCount,
+				OldUsers: *oldUsers,
+			}); err != nil {
+				return fmt.Errorf("seats notice: %w", err)
+			}
 		}
 	}
 
@@ -101,15 +182,35 @@ func updateVIPRecords(ctx context.Context, db *pgxpool.Pool, brigades map[uuid.U
 	}
 
 	for _, brigadeID := range expired {
-		if _, err := tx.Exec(ctx, resetExpired, brigadeID); err != nil {
+		var (
+			oldExpire, newExpire time.Time
+			users                int
+		)
+
+		if err := tx.QueryRow(ctx, resetExpired, brigadeID).Scan(&oldExpire, &newExpire, &users); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+
 			return fmt.Errorf("reset expired: %w", err)
 		}
 
+		if err := core.AddVIPEvent(ctx, tx, core.VIPEvent{
+			BrigadeID: brigadeID,
+			Name:      core.VIPEventReset,
+			OldExpire: &oldExpire,
+			NewExpire: &newExpire,
+			OldUsers:  &users,
+			NewUsers:  &users,
+		}); err != nil {
+			return fmt.Errorf("vip event: %w", err)
+		}
+
 		fmt.Fprintf(os.Stderr, "%s: Brigade %s not in fetched list, set expire to %d hours\n", LogTag, brigadeID, redemtionPeriod)
 	}
 
 	// purge expired and deleted VIP records
-	if _, err := tx.Exec(ctx, purgeExpired, redemtionPeriod); err != nil {
+	if _, err := tx.Exec(ctx, purgeExpired, redemtionPeriod, core.VIPEventPurge); err != nil {
 		return fmt.Errorf("purge expired: %w", err)
 	}
 
@@ -119,62 +220,3 @@ func updateVIPRecords(ctx context.Context, db *pgxpool.Pool, brigades map[uuid.U
 
 	return nil
 }
-
-var ErrInvalidResponse = errors.New("invalid response")
-
-func fetchPaidUsers(c *http.Client, obfsUUID uuid.UUID, ep string) (map[uuid.UUID]VipBrigade, []byte, error) {
-	req, err := http.NewRequest(http.MethodPost, "https://"+ep, nil)
-	if err != nil {
-		return nil, nil, fmt.Errorf("create request: %w", err)
-	}
-
-	resp, err := c.Do(req)
-	if err != nil {
-		return nil, nil, fmt.Errorf("do request: %w", err)
-	}
-
-	defer resp.Body.Close()
-
-	payload, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, nil, fmt.Errorf("read response body: %w", err)
-	}
-
-	// OBFS_UUID="d8fc0859-c1a4-4d29-94e1-4fdea70ff8b8"
-	// fmt.Printf("%s\n", payload)
-
-	var userList PaidUsersPesponse
-	if err := json.Unmarshal(payload, &userList); err != nil {
-		return nil, nil, fmt.Errorf("unmarshal response body: %w", err)
-	}
-
-	if userList.Result != "success" {
-		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidResponse, userList.Result)
-	}
-
-	brigades := make(map[uuid.UUID]VipBrigade, 0)
-	for _, u := range userList.Data {
-		brigadeID := obfs2uuid(u.UserID, obfsUUID)
-
-		if brigade, ok := brigades[brigadeID]; ok {
-			if brigade.ExpiredAt.Before(u.GoodExpiryDateTime) {
-				brigade.ExpiredAt = u.GoodExpiryDateTime
-			}
-
-			brigade.UsersCount++
-
-			brigades[brigadeID] = brigade
-
-			continue
-		}
-
-		brigades[brigadeID] = VipBrigade{
-			RawBrigadeID: u.UserID,
-			BrigadeID:    brigadeID,
-			ExpiredAt:    u.GoodExpiryDateTime,
-			UsersCount:   1,
-		}
-	}
-
-	return brigades, payload, nil
-}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/core"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnknownVIP - the realm reports VIP of the brigade without a VIP record,
+// e.g. set by hand, such brigades are left as is without -fix-unknown.
+var ErrUnknownVIP = errors.New("vip without a record")
+
+const sqlRealmsToVerify = `
+SELECT
+	r.realm_id,
+	r.realm_name,
+	r.control_ip
+FROM
+	head.realms r
+LEFT JOIN
+	head.vip_realm_checks c ON r.realm_id = c.realm_id
+WHERE
+	r.is_active = true
+	AND (c.check_time IS NULL OR c.check_time < NOW() AT TIME ZONE 'UTC' - $1 * INTERVAL '1 SECOND')
+ORDER BY
+	r.realm_name
+`
+
+const sqlStoreRealmCheck = `
+INSERT INTO
+	head.vip_realm_checks
+		(realm_id, check_time, vip_count, corrected, unknown, error)
+	VALUES
+		($1, NOW() AT TIME ZONE 'UTC', $2, $3, $4, $5)
+ON CONFLICT (realm_id) DO UPDATE
+	SET
+		check_time = EXCLUDED.check_time,
+		vip_count = EXCLUDED.vip_count,
+		corrected = EXCLUDED.corrected,
+		unknown = EXCLUDED.unknown,
+		error = EXCLUDED.error
+`
+
+func getRealmsToVerify(ctx context.Context, db *pgxpool.Pool, interval time.Duration) ([]vipRealm, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlRealmsToVerify, int64(interval.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		realm vipRealm
+		ip    netip.Addr
+	)
+
+	realms := make([]vipRealm, 0)
+
+	if _, err := pgx.ForEachRow(rows, []any{&realm.ID, &realm.Name, &ip}, func() error {
+		realm.Addr = netip.AddrPortFrom(ip, core.DefaultRealmsPort)
+		realms = append(realms, realm)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("foreach: %w", err)
+	}
+
+	return realms, nil
+}
+
+func storeRealmCheck(ctx context.Context, db *pgxpool.Pool, realmID uuid.UUID, vipCount, corrected, unknown int, checkErr error) error {
+	errText := ""
+	if checkErr != nil {
+		errText = checkErr.Error()
+	}
+
+	if _, err := db.Exec(ctx, sqlStoreRealmCheck, realmID, vipCount, corrected, unknown, errText); err != nil {
+		return fmt.Errorf("store realm check: %w", err)
+	}
+
+	return nil
+}
+
+// verifyRealms - asks the realms not verified for the interval for their VIP
+// brigades and corrects the realms which differ from the finalized records.
+func verifyRealms(ctx context.Context, db *pgxpool.Pool, sshconf *ssh.ClientConfig, interval time.Duration, fixUnknown, silent bool) error {
+	realms, err := getRealmsToVerify(ctx, db, interval)
+	if err != nil {
+		return fmt.Errorf("get realms: %w", err)
+	}
+
+	if !silent || len(realms) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: Found %d realms to verify\n", LogTag, len(realms))
+	}
+
+	if len(realms) == 0 {
+		return nil
+	}
+
+	dbs, err := getDBVIPState(ctx, db)
+	if err != nil {
+		return fmt.Errorf("get vip records: %w", err)
+	}
+
+	for _, realm := range realms {
+		reported, err := callRealmVIPList(ctx, sshconf, LogTag, realm.Addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Can't get VIP list of realm %s: %s\n", LogTag, realm.Name, err)
+
+			if err := storeRealmCheck(ctx, db, realm.ID, 0, 0, 0, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		vip := make(map[uuid.UUID]bool, len(reported))
+		for _, id := range reported {
+			vip[id] = true
+		}
+
+		corrected, unknown := 0, 0
+
+		// finalized records the realm doesn't report
+		for id, d := range dbs {
+			if !d.Finalizer || d.Deleted || d.RealmID == nil || *d.RealmID != realm.ID || vip[id] {
+				continue
+			}
+
+			if _, err := correctRealm(ctx, db, sshconf, realm, id, d, false, fixUnknown); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: Can't correct brigade %s on realm %s: %s\n", LogTag, id, realm.Name, err)
+
+				continue
+			}
+
+			corrected++
+		}
+
+		// reported brigades without finalized records
+		for _, id := range reported {
+			d := dbs[id]
+			if d != nil && d.Finalizer {
+				continue
+			}
+
+			if _, err := correctRealm(ctx, db, sshconf, realm, id, d, true, fixUnknown); err != nil {
+				if errors.Is(err, ErrUnknownVIP) {
+					fmt.Fprintf(os.Stderr, "%s: Brigade %s on realm %s: %s, left as is\n", LogTag, id, realm.Name, err)
+
+					unknown++
+
+					continue
+				}
+
+				fmt.Fprintf(os.Stderr, "%s: Can't correct brigade %s on realm %s: %s\n", LogTag, id, realm.Name, err)
+
+				continue
+			}
+
+			corrected++
+		}
+
+		if corrected > 0 || unknown > 0 || !silent {
+			fmt.Fprintf(os.Stderr, "%s: Realm %s: %d VIP brigades, %d corrected, %d unknown\n", LogTag, realm.Name, len(reported), corrected, unknown)
+		}
+
+		if err := storeRealmCheck(ctx, db, realm.ID, len(reported), corrected, unknown, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// correctRealm - brings the realm VIP state in line with the record.
+// The finalized brigade the realm doesn't report gets vipon again.
+// The reported brigade gets the finalizer if its VIP is still in force
+// (including the grace period), otherwise vipoff. The reported brigade
+// without a VIP record gets vipoff only if fixUnknown is set.
+func correctRealm(ctx context.Context, db *pgxpool.Pool, sshconf *ssh.ClientConfig,
+	realm vipRealm, brigadeID uuid.UUID, d *dbState, reportedVIP, fixUnknown bool,
+) (string, error) {
+	var action string
+
+	switch {
+	case !reportedVIP:
+		if d == nil {
+			return "", nil
+		}
+
+		target := vipTarget{BrigadeID: brigadeID, Users: d.Users, Plan: d.Plan, Features: d.Features}
+
+		args, err := target.vipArgs()
+		if err != nil {
+			return "", fmt.Errorf("vipon args: %w", err)
+		}
+
+		if err := callRealmViparizeBrigadier(ctx, sshconf, LogTag, realm.Addr, true, brigadeID, args); err != nil {
+			return "", fmt.Errorf("vipon: %w", err)
+		}
+
+		action = repairVipon
+	case d != nil && !d.Deleted && d.Expire.Add(time.Duration(d.GraceHours)*time.Hour).After(time.Now().UTC()):
+		if err := setFinalizer(ctx, db, brigadeID); err != nil {
+			return "", fmt.Errorf("set finalizer: %w", err)
+		}
+
+		action = repairFinalizer
+	case d == nil && !fixUnknown:
+		return "", ErrUnknownVIP
+	default:
+		if err := callRealmViparizeBrigadier(ctx, sshconf, LogTag, realm.Addr, false, brigadeID, ""); err != nil {
+			return "", fmt.Errorf("vipoff: %w", err)
+		}
+
+		action = repairVipoff
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: Brigade %s on realm %s corrected: %s\n", LogTag, brigadeID, realm.Name, action)
+
+	if err := recordCorrection(ctx, db, brigadeID, action, d); err != nil {
+		return action, fmt.Errorf("record: %w", err)
+	}
+
+	return action, nil
+}
+
+func recordCorrection(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID, action string, d *dbState) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	ev := core.VIPEvent{
+		BrigadeID: brigadeID,
+		Name:      core.VIPEventCorrect,
+		Info:      action,
+	}
+
+	// the brigade unknown to the records has no VIP state
+	if d != nil {
+		ev.OldExpire, ev.NewExpire = &d.Expire, &d.Expire
+		ev.OldUsers, ev.NewUsers = &d.Users, &d.Users
+	}
+
+	if err := core.AddVIPEvent(ctx, tx, ev); err != nil {
+		return fmt.Errorf("event: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
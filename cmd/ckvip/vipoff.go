@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/core"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -20,9 +21,11 @@ JOIN
 	head.brigadier_vip bv ON b.brigade_id = bv.brigade_id
 LEFT JOIN
 	head.deleted_brigadiers d ON b.brigade_id = d.brigade_id
+LEFT JOIN
+	head.vip_plans p ON bv.vip_variant = p.plan_id
 WHERE 
 	d.brigade_id IS NULL
-	AND bv.vip_expire < (NOW() AT TIME ZONE 'UTC' - $1 * INTERVAL '1 HOUR')
+	AND bv.vip_expire < (NOW() AT TIME ZONE 'UTC' - COALESCE(p.grace_hours, $1) * INTERVAL '1 HOUR')
 	AND bv.finalizer = true
 `
 
@@ -73,7 +76,7 @@ func unviparize(ctx context.Context, db *pgxpool.Pool, sshconf *ssh.ClientConfig
 			return fmt.Errorf("fetch realm: %w", err)
 		}
 
-		if err := callRealmViparizeBrigadier(ctx, sshconf, LogTag, addr, false, brigadeID); err != nil {
+		if err := callRealmViparizeBrigadier(ctx, sshconf, LogTag, addr, false, brigadeID, ""); err != nil {
 			fmt.Fprintf(os.Stderr, "%s: Can't call realm unviparize brigadier: %s\n", LogTag, err)
 
 			continue
@@ -98,6 +101,8 @@ SET
 	finalizer = false
 WHERE 
 	brigade_id = $1
+RETURNING
+	vip_expire, vip_users
 `
 
 func dropFinalizer(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) error {
@@ -108,12 +113,8 @@ func dropFinalizer(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) e
 
 	defer tx.Rollback(ctx)
 
-	if _, err := tx.Exec(ctx, sqlDropFinalizer, brigadeID); err != nil {
-		return fmt.Errorf("exec finalizer: %w", err)
-	}
-
-	if _, err := tx.Exec(ctx, addSetVipAction, brigadeID, "end", ""); err != nil {
-		return fmt.Errorf("exec action: %w", err)
+	if err := finalizeVIP(ctx, tx, sqlDropFinalizer, brigadeID, core.VIPEventEnd); err != nil {
+		return fmt.Errorf("finalize: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -16,15 +17,20 @@ import (
 
 const sqlBrigadesToVIParize = `
 SELECT 
-	b.brigade_id
+	b.brigade_id,
+	bv.vip_users,
+	COALESCE(p.plan_id, ''),
+	COALESCE(p.features, '')
 FROM 
 	head.brigadiers b
 JOIN 
 	head.brigadier_vip bv ON b.brigade_id = bv.brigade_id
+LEFT JOIN
+	head.vip_plans p ON bv.vip_variant = p.plan_id
 LEFT JOIN
 	head.deleted_brigadiers d ON b.brigade_id = d.brigade_id
 LEFT JOIN
-	head.vip_messages vm ON b.brigade_id = vm.brigade_id AND vm.finalizer = false
+	head.vip_messages vm ON b.brigade_id = vm.brigade_id AND vm.msg_type = 'config' AND vm.finalizer = false
 WHERE 
 	d.brigade_id IS NULL
 	AND vm.brigade_id IS NULL
@@ -32,7 +38,7 @@ WHERE
 	AND bv.finalizer = false
 `
 
-func getBrigadesToVIParize(ctx context.Context, db *pgxpool.Pool) ([]uuid.UUID, error) {
+func getBrigadesToVIParize(ctx context.Context, db *pgxpool.Pool) ([]vipTarget, error) {
 	tx, err := db.Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("begin: %w", err)
@@ -47,11 +53,11 @@ func getBrigadesToVIParize(ctx context.Context, db *pgxpool.Pool) ([]uuid.UUID,
 
 	defer rows.Close()
 
-	var brigadeID uuid.UUID
-	brigades := make([]uuid.UUID, 0)
+	var target vipTarget
+	brigades := make([]vipTarget, 0)
 
-	if _, err := pgx.ForEachRow(rows, []any{&brigadeID}, func() error {
-		brigades = append(brigades, brigadeID)
+	if _, err := pgx.ForEachRow(rows, []any{&target.BrigadeID, &target.Users, &target.Plan, &target.Features}, func() error {
+		brigades = append(brigades, target)
 
 		return nil
 	}); err != nil {
@@ -73,7 +79,7 @@ JOIN
 LEFT JOIN
 	head.deleted_brigadiers d ON b.brigade_id = d.brigade_id
 LEFT JOIN
-	head.vip_messages vm ON b.brigade_id = vm.brigade_id AND vm.finalizer = false
+	head.vip_messages vm ON b.brigade_id = vm.brigade_id AND vm.msg_type = 'config' AND vm.finalizer = false
 WHERE 
 	d.brigade_id IS NOT NULL
 	AND vm.brigade_id IS NULL
@@ -135,7 +141,9 @@ func viparize(ctx context.Context, db *pgxpool.Pool, sshconf *ssh.ClientConfig,
 		fmt.Fprintf(os.Stderr, "%s: Found %d brigades to viparize\n", LogTag, len(brigades))
 	}
 
-	for _, brigadeID := range brigades {
+	for _, target := range brigades {
+		brigadeID := target.BrigadeID
+
 		fmt.Fprintf(os.Stderr, "%s: Set VIP for brigade: %s\n", LogTag, brigadeID)
 
 		_, addr, err := fetchBrigadeRealm(ctx, db, brigadeID)
@@ -143,7 +151,14 @@ func viparize(ctx context.Context, db *pgxpool.Pool, sshconf *ssh.ClientConfig,
 			return fmt.Errorf("fetch realm: %w", err)
 		}
 
-		if err := callRealmViparizeBrigadier(ctx, sshconf, LogTag, addr, true, brigadeID); err != nil {
+		args, err := target.vipArgs()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Can't build vipon args: %s\n", LogTag, err)
+
+			continue
+		}
+
+		if err := callRealmViparizeBrigadier(ctx, sshconf, LogTag, addr, true, brigadeID, args); err != nil {
 			fmt.Fprintf(os.Stderr, "%s: Can't call realm viparize brigadier: %s\n", LogTag, err)
 
 			continue
@@ -207,14 +222,8 @@ SET
 	finalizer = true
 WHERE 
 	brigade_id = $1
-`
-
-const addSetVipAction = `
-INSERT INTO 
-	head.brigadier_vip_actions
-		(brigade_id, event_name, event_info, event_time)
-	VALUES 
-		($1, $2, $3, NOW() AT TIME ZONE 'UTC')
+RETURNING
+	vip_expire, vip_users
 `
 
 func setFinalizer(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) error {
@@ -225,12 +234,8 @@ func setFinalizer(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) er
 
 	defer tx.Rollback(ctx)
 
-	if _, err := tx.Exec(ctx, sqlSetFinalizer, brigadeID); err != nil {
-		return fmt.Errorf("exec finalizer: %w", err)
-	}
-
-	if _, err := tx.Exec(ctx, addSetVipAction, brigadeID, "begin", ""); err != nil {
-		return fmt.Errorf("exec action: %w", err)
+	if err := finalizeVIP(ctx, tx, sqlSetFinalizer, brigadeID, core.VIPEventBegin); err != nil {
+		return fmt.Errorf("finalize: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -239,3 +244,28 @@ func setFinalizer(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) er
 
 	return nil
 }
+
+// finalizeVIP - sets or drops the finalizer and records the event.
+func finalizeVIP(ctx context.Context, tx pgx.Tx, query string, brigadeID uuid.UUID, event string) error {
+	var (
+		expire *time.Time
+		users  int
+	)
+
+	if err := tx.QueryRow(ctx, query, brigadeID).Scan(&expire, &users); err != nil {
+		return fmt.Errorf("exec finalizer: %w", err)
+	}
+
+	if err := core.AddVIPEvent(ctx, tx, core.VIPEvent{
+		BrigadeID: brigadeID,
+		Name:      event,
+		OldExpire: expire,
+		NewExpire: expire,
+		OldUsers:  &users,
+		NewUsers:  &users,
+	}); err != nil {
+		return fmt.Errorf("exec action: %w", err)
+	}
+
+	return nil
+}
@@ -50,7 +50,7 @@ var (
 func main() {
 	var w io.WriteCloser
 
-	person, fullname, chunked, jout, token, label, labelID, fv, err := parseArgs()
+	person, fullname, chunked, jout, token, label, labelID, fv, utm, err := parseArgs()
 	if err != nil {
 		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
 	}
@@ -89,7 +89,7 @@ func main() {
 		fatal(w, jout, "%s: Access denied\n", LogTag)
 	}
 
-	brigadeID, mnemo, fullname, person, err := core.CreateBrigade(ctx, db, seedExtra, partnerID, brigadeCreationType, person, fullname, label, labelID, fv)
+	brigadeID, mnemo, fullname, person, err := core.CreateBrigade(ctx, db, seedExtra, partnerID, brigadeCreationType, person, fullname, label, labelID, fv, utm)
 	if err != nil {
 		fatal(w, jout, "%s: Can't create brigade: %s\n", LogTag, err)
 	}
@@ -193,7 +193,7 @@ func readConfigs() (string, string, string, error) {
 	return sshKeyFilename, dbURL, brigadesSchema, nil
 }
 
-func parseArgs() (*namesgenerator.Person, string, bool, bool, []byte, string, string, int64, error) {
+func parseArgs() (*namesgenerator.Person, string, bool, bool, []byte, string, string, int64, ministry.UTM, error) {
 	chunked := flag.Bool("ch", false, "chunked output")
 	jout := flag.Bool("j", false, "json output")
 	label := flag.String("l", "", "label")
@@ -201,11 +201,12 @@ func parseArgs() (*namesgenerator.Person, string, bool, bool, []byte, string, st
 	labelTime := flag.Int("lt", 0, "first visit")
 	customName := flag.String("name", "", "custom brigadier fullname")
 	forcePerson := flag.String("p", "", "force person")
+	utmArg := flag.String("utm", "", "base64 JSON of utm_source, utm_medium, utm_campaign, utm_content, utm_term, referrer")
 
 	flag.Parse()
 
 	if *label != "" && len(*label) > maxStartLabelLen {
-		return nil, "", false, false, nil, "", "", 0, fmt.Errorf("label: %w", ErrLabelTooLong)
+		return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("label: %w", ErrLabelTooLong)
 	}
 
 	id := *labelID
@@ -220,24 +221,24 @@ func parseArgs() (*namesgenerator.Person, string, bool, bool, []byte, string, st
 
 	a := flag.Args()
 	if len(a) < 1 {
-		return nil, "", false, false, nil, "", "", 0, fmt.Errorf("access token: %w", ErrEmptyAccessToken)
+		return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("access token: %w", ErrEmptyAccessToken)
 	}
 
 	token := make([]byte, base64.URLEncoding.WithPadding(base64.NoPadding).DecodedLen(len(a[0])))
 	_, err := base64.URLEncoding.WithPadding(base64.NoPadding).Decode(token, []byte(a[0]))
 	if err != nil {
-		return nil, "", false, false, nil, "", "", 0, fmt.Errorf("access token: %w", err)
+		return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("access token: %w", err)
 	}
 
 	var person *namesgenerator.Person
 	if *forcePerson != "" {
 		buf, err := base64.StdEncoding.WithPadding(base64.StdPadding).DecodeString(*forcePerson)
 		if err != nil {
-			return nil, "", false, false, nil, "", "", 0, fmt.Errorf("force person: %w", err)
+			return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("force person: %w", err)
 		}
 
 		if err := json.Unmarshal(buf, &person); err != nil {
-			return nil, "", false, false, nil, "", "", 0, fmt.Errorf("force person: %w", err)
+			return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("force person: %w", err)
 		}
 	}
 
@@ -245,11 +246,27 @@ func parseArgs() (*namesgenerator.Person, string, bool, bool, []byte, string, st
 	if *customName != "" {
 		buf, err := base64.StdEncoding.WithPadding(base64.StdPadding).DecodeString(*customName)
 		if err != nil {
-			return nil, "", false, false, nil, "", "", 0, fmt.Errorf("custom name: %w", err)
+			return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("custom name: %w", err)
 		}
 
 		fullname = string(buf)
 	}
 
-	return person, fullname, *chunked, *jout, token, *label, id, int64(firstVisit), nil
+	var utm ministry.UTM
+	if *utmArg != "" {
+		buf, err := base64.StdEncoding.WithPadding(base64.StdPadding).DecodeString(*utmArg)
+		if err != nil {
+			return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("utm: %w", err)
+		}
+
+		if err := json.Unmarshal(buf, &utm); err != nil {
+			return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("utm: %w", err)
+		}
+
+		if err := utm.Validate(); err != nil {
+			return nil, "", false, false, nil, "", "", 0, ministry.UTM{}, fmt.Errorf("utm: %w", err)
+		}
+	}
+
+	return person, fullname, *chunked, *jout, token, *label, id, int64(firstVisit), utm, nil
 }
@@ -29,6 +29,9 @@ printdef () {
         echo "    regsoff <partner_id>                  # Disallow new registrations for a partner"
         echo "    attachdc <partner_id> <realm_id>      # Attach a partner to a realm"
         echo "    detachdc <partner_id> <realm_id>      # Detach a partner from a realm"
+        echo "    setwebhook <partner_id> <https_url> [max_attempts]"
+        echo "                                          # Push VIP messages to the webhook, prints the new secret"
+        echo "    delwebhook <partner_id>               # Disable the webhook, the partner polls readmsgs again"
         exit 1
 }
 
@@ -104,6 +107,13 @@ BEGIN;
                 :"schema_name".realms r ON pr.realm_id=r.realm_id 
         WHERE 
                 partner_id=:'partner_id';
+
+        SELECT 
+                CONCAT('    webhook: ', url, '  active: ', is_active, '  max_attempts: ', max_attempts)
+        FROM 
+                :"schema_name".partners_webhooks 
+        WHERE 
+                partner_id=:'partner_id';
 COMMIT;
 EOF
 }
@@ -257,6 +267,76 @@ COMMIT;
 EOF
 }
 
+setwebhook () {
+        partner_id="$1"
+        url="$2"
+        max_attempts="${3:-10}"
+        if [ -z "${partner_id}" ] || [ -z "${url}" ]; then
+                echo "Error: partner_id and url must be set ($*)" >&2
+
+                printdef
+        fi
+
+        case "${url}" in
+                https://*)
+                        ;;
+                *)
+                        echo "Error: url must be https (${url})" >&2
+
+                        exit 1
+                        ;;
+        esac
+
+        secret=$(head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n')
+
+        psql -qt -d "${DBNAME}" \
+        --set ON_ERROR_STOP=yes \
+        --set schema_name="${SCHEMA}" \
+        --set partner_id="${partner_id}" \
+        --set url="${url}" \
+        --set secret="${secret}" \
+        --set max_attempts="${max_attempts}" <<EOF
+BEGIN;
+        INSERT INTO 
+                :"schema_name".partners_webhooks 
+                (partner_id,url,secret,is_active,max_attempts) 
+        VALUES 
+                (:'partner_id', :'url', :'secret', true, :'max_attempts')
+        ON CONFLICT (partner_id) DO UPDATE 
+        SET 
+                url=EXCLUDED.url, secret=EXCLUDED.secret, is_active=true, max_attempts=EXCLUDED.max_attempts;
+COMMIT;
+EOF
+
+        echo "Partner ${partner_id} webhook: ${url}"
+        echo "Secret: ${secret}"
+}
+
+delwebhook () {
+        partner_id="$1"
+        if [ -z "${partner_id}" ]; then
+                echo "Error: partner_id must be set" >&2
+
+                printdef
+        fi
+
+        psql -qt -d "${DBNAME}" \
+        --set ON_ERROR_STOP=yes \
+        --set schema_name="${SCHEMA}" \
+        --set partner_id="${partner_id}" <<EOF
+BEGIN;
+        UPDATE 
+                :"schema_name".partners_webhooks 
+        SET 
+                is_active=false
+        WHERE 
+                partner_id=:'partner_id';
+COMMIT;
+EOF
+
+        echo "Partner ${partner_id} webhook disabled."
+}
+
 opt="$1"
 if [ -z "${opt}" ]; then
         echo "Error: command must be specified" >&2
@@ -300,6 +380,12 @@ case "$opt" in
         detachdc)
                 detachdc "$@"
                 ;;
+        setwebhook)
+                setwebhook "$@"
+                ;;
+        delwebhook)
+                delwebhook "$@"
+                ;;
         *)
                 printdef
                 ;;
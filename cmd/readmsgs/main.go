@@ -12,11 +12,15 @@ import (
 	"log"
 	"net/http/httputil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vpngen/ministry"
+	"github.com/vpngen/ministry/internal/core"
+	"github.com/vpngen/ministry/internal/idcodec"
 	"github.com/vpngen/ministry/internal/pgsql"
 )
 
@@ -25,21 +29,52 @@ const (
 	defaultBrigadesSchema = "head"
 )
 
+const (
+	defaultLeaseTTL = 2 * time.Minute
+	maxBatch        = 100
+	maxLeaseTTL     = time.Hour
+)
+
 var (
 	ErrEmptyAccessToken = errors.New("token not specified")
 	ErrInvalidUUID      = errors.New("invalid uuid")
 	ErrPartnerMismatch  = errors.New("partner mismatch")
+	ErrInvalidMsgType   = errors.New("invalid message type")
+	ErrInvalidBatch     = errors.New("invalid batch size")
+	ErrInvalidLeaseTTL  = errors.New("invalid lease ttl")
 )
 
+// ackItem - the message to acknowledge: the request ID and the message type.
+type ackItem struct {
+	RequestID uuid.UUID
+	MsgType   string
+}
+
+// ackResult - the answer to the batch acknowledge.
+type ackResult struct {
+	Acked   int         `json:"acked"`
+	Skipped []uuid.UUID `json:"skipped,omitempty"`
+}
+
+type opts struct {
+	chunked  bool
+	types    []string
+	token    []byte
+	batch    int
+	leaseTTL time.Duration
+	lease    uuid.UUID
+	acks     []ackItem
+}
+
 func main() {
 	var w io.WriteCloser
 
-	chunked, token, inUUID, err := parseArgs()
+	o, err := parseArgs()
 	if err != nil {
 		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
 	}
 
-	switch chunked {
+	switch o.chunked {
 	case true:
 		w = httputil.NewChunkedWriter(os.Stdout)
 		defer w.Close()
@@ -47,7 +82,7 @@ func main() {
 		w = os.Stdout
 	}
 
-	obfsUUID, dbURL, err := readConfigs()
+	codec, dbURL, err := readConfigs()
 	if err != nil {
 		fatal(w, "Can't read configs: %s\n", err)
 	}
@@ -59,7 +94,7 @@ func main() {
 
 	ctx := context.Background()
 
-	partnerID, ok, err := checkToken(ctx, db, defaultBrigadesSchema, token)
+	partnerID, ok, err := checkToken(ctx, db, defaultBrigadesSchema, o.token)
 	if err != nil || !ok {
 		if err != nil {
 			fatal(w, "%s: Can't check token: %s\n", LogTag, err)
@@ -68,17 +103,40 @@ func main() {
 		fatal(w, "%s: Access denied\n", LogTag)
 	}
 
-	if inUUID != uuid.Nil {
-		if err := doneMessage(ctx, db, partnerID, inUUID, obfsUUID); err != nil {
+	var answ any
+
+	switch {
+	case len(o.acks) > 0:
+		res, err := doneMessages(ctx, db, partnerID, o.acks, o.lease, codec)
+		if err != nil {
 			fatal(w, "%s: Can't mark message as done: %s\n", LogTag, err)
 		}
 
-		return
-	}
+		// the single acknowledge answers nothing as before
+		if len(o.acks) == 1 && o.lease == uuid.Nil {
+			return
+		}
 
-	answ, err := getMessage(ctx, db, partnerID, obfsUUID)
-	if err != nil {
-		fatal(w, "%s: Can't get message: %s\n", LogTag, err)
+		answ = res
+	case o.batch > 0:
+		batch, err := getMessages(ctx, db, partnerID, codec, o.types, o.batch, o.leaseTTL)
+		if err != nil {
+			fatal(w, "%s: Can't get messages: %s\n", LogTag, err)
+		}
+
+		answ = batch
+	default:
+		batch, err := getMessages(ctx, db, partnerID, codec, o.types, 1, o.leaseTTL)
+		if err != nil {
+			fatal(w, "%s: Can't get message: %s\n", LogTag, err)
+		}
+
+		var msg *ministry.VIPAnswer
+		if len(batch.Messages) > 0 {
+			msg = &batch.Messages[0]
+		}
+
+		answ = msg
 	}
 
 	payload, err := json.MarshalIndent(answ, "", "  ")
@@ -91,37 +149,58 @@ func main() {
 	}
 }
 
-const sqlGetMessage = `
-SELECT 
+// Only the requested types are delivered, the pollers which don't
+// ask for the notices get configs only and acknowledge them as before.
+// Configs are delivered only with telegram ID,
+// notices are delivered anyway, the request ID identifies the user.
+// Partners with an active webhook get messages pushed by vippush.
+// Leased messages are skipped until the lease expires.
+const sqlGetMessages = `
+SELECT
 	vm.brigade_id,
-	vt.telegram_id,
-	vm.vpnconfig
-FROM 
+	vm.msg_type,
+	COALESCE(vt.telegram_id, 0),
+	vm.vpnconfig,
+	vm.payload
+FROM
 	head.vip_messages vm
-JOIN
+LEFT JOIN
 	head.vip_telegram_ids vt ON vm.brigade_id = vt.brigade_id
 JOIN
 	head.brigadier_partners bp ON bp.brigade_id = vm.brigade_id
 WHERE
 	bp.partner_id = $1
 	AND vm.finalizer = true
-	AND vm.vpnconfig != ''
-	AND vm.last_try < NOW() AT TIME ZONE 'UTC' - INTERVAL '2 MINUTES'
+	AND vm.msg_type = ANY($3::text[])
+	AND (
+		(vm.msg_type = 'config' AND vm.vpnconfig != '' AND vt.brigade_id IS NOT NULL)
+		OR vm.msg_type != 'config'
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM head.partners_webhooks pw
+		WHERE pw.partner_id = bp.partner_id AND pw.is_active = true
+	)
+	AND (vm.lease_until IS NULL OR vm.lease_until < NOW() AT TIME ZONE 'UTC')
 ORDER BY
-	vm.last_try DESC
-LIMIT 1
+	vm.update_time, vm.brigade_id, vm.msg_type
+LIMIT $2
+FOR UPDATE OF vm SKIP LOCKED
 `
 
-const sqlUpdateMessage = `
-UPDATE 
+const sqlLeaseMessage = `
+UPDATE
 	head.vip_messages
-SET 
-	last_try = NOW() AT TIME ZONE 'UTC'
-WHERE 
+SET
+	last_try = NOW() AT TIME ZONE 'UTC',
+	lease_id = $3,
+	lease_until = $4
+WHERE
 	brigade_id = $1
+	AND msg_type = $2
 `
 
-func getMessage(ctx context.Context, db *pgxpool.Pool, partnerID, obfsUUID uuid.UUID) (*ministry.VIPAnswer, error) {
+// getMessages - leases up to limit messages of the partner of the types.
+func getMessages(ctx context.Context, db *pgxpool.Pool, partnerID uuid.UUID, codec *idcodec.Codec, types []string, limit int, ttl time.Duration) (*ministry.VIPBatch, error) {
 	tx, err := db.Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("begin tx: %w", err)
@@ -129,60 +208,85 @@ func getMessage(ctx context.Context, db *pgxpool.Pool, partnerID, obfsUUID uuid.
 
 	defer tx.Rollback(ctx)
 
+	rows, err := tx.Query(ctx, sqlGetMessages, partnerID, limit, types)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	type message struct {
+		brigadeID uuid.UUID
+		msgType   string
+	}
+
 	var (
-		msg       ministry.Answer
+		msgType   string
+		vpnconfig string
 		payload   string
 		tgID      int64
 		brigadeID uuid.UUID
 	)
 
-	if err := tx.QueryRow(ctx, sqlGetMessage, partnerID).Scan(&brigadeID, &tgID, &payload); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
+	batch := &ministry.VIPBatch{
+		Lease:      uuid.New(),
+		LeaseUntil: time.Now().UTC().Add(ttl).Truncate(time.Second),
+		Messages:   make([]ministry.VIPAnswer, 0, limit),
+	}
+
+	leased := make([]message, 0, limit)
+
+	if _, err := pgx.ForEachRow(rows, []any{&brigadeID, &msgType, &tgID, &vpnconfig, &payload}, func() error {
+		outUUID, err := codec.Encode(brigadeID)
+		if err != nil {
+			return fmt.Errorf("encode request id: %w", err)
 		}
 
-		return nil, fmt.Errorf("query row: %w", err)
-	}
+		answ, err := core.VIPMessageAnswer(msgType, vpnconfig, payload, tgID, outUUID)
+		if err != nil {
+			return fmt.Errorf("answer: %w", err)
+		}
 
-	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
-		return nil, fmt.Errorf("unmarshal payload: %w", err)
-	}
+		batch.Messages = append(batch.Messages, *answ)
+		leased = append(leased, message{brigadeID: brigadeID, msgType: msgType})
 
-	if _, err := tx.Exec(ctx, sqlUpdateMessage, brigadeID); err != nil {
-		return nil, fmt.Errorf("update message: %w", err)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("foreach: %w", err)
 	}
 
-	var outUUID uuid.UUID
-	for i := range 16 {
-		outUUID[i] = brigadeID[i] ^ obfsUUID[i]
+	for _, m := range leased {
+		if _, err := tx.Exec(ctx, sqlLeaseMessage, m.brigadeID, m.msgType, batch.Lease, batch.LeaseUntil); err != nil {
+			return nil, fmt.Errorf("lease message: %w", err)
+		}
 	}
 
-	answ := &ministry.VIPAnswer{
-		Answer:     msg,
-		TelegramID: tgID,
-		RequestID:  outUUID,
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
 	}
 
-	return answ, nil
+	return batch, nil
 }
 
+// The lease, if any, must match: the message leased again
+// after the lease expiration is left to the new lease holder.
 const sqlDoneMessage = `
-DELETE FROM 
+DELETE FROM
 	head.vip_messages
 WHERE
 	brigade_id = $1
+	AND msg_type = $2
+	AND ($3::uuid IS NULL OR lease_id = $3)
 `
 
 const sqlDeleteTelegramID = `
-DELETE FROM 
+DELETE FROM
 	head.vip_telegram_ids
 WHERE
 	brigade_id = $1
 `
 
 const sqlGetBrigadePartnerID = `
-SELECT 
-	bp.partner_id	
+SELECT
+	bp.partner_id
 FROM
 	head.brigadier_partners bp
 WHERE
@@ -190,94 +294,207 @@ WHERE
 LIMIT 1
 `
 
-func doneMessage(ctx context.Context, db *pgxpool.Pool, inPartnerID, inUUID, obfsUUID uuid.UUID) error {
+// doneMessages - acknowledges the messages in one transaction,
+// any foreign request ID fails the whole acknowledge.
+func doneMessages(ctx context.Context, db *pgxpool.Pool, inPartnerID uuid.UUID, acks []ackItem, lease uuid.UUID, codec *idcodec.Codec) (*ackResult, error) {
 	tx, err := db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return nil, fmt.Errorf("begin tx: %w", err)
 	}
 
 	defer tx.Rollback(ctx)
 
-	var brigadeID uuid.UUID
-	for i := range 16 {
-		brigadeID[i] = inUUID[i] ^ obfsUUID[i]
+	var leaseID *uuid.UUID
+	if lease != uuid.Nil {
+		leaseID = &lease
 	}
 
-	var partnerID uuid.UUID
-	if err := tx.QueryRow(ctx, sqlGetBrigadePartnerID, brigadeID).Scan(&partnerID); err != nil {
-		return fmt.Errorf("get brigade partner id: %w", err)
-	}
+	res := &ackResult{}
 
-	if partnerID != inPartnerID {
-		return fmt.Errorf("%w: %s", ErrPartnerMismatch, inPartnerID.String())
-	}
+	for _, ack := range acks {
+		brigadeID, err := codec.Decode(ack.RequestID)
+		if err != nil {
+			return nil, fmt.Errorf("decode request id: %w", err)
+		}
 
-	if _, err := tx.Exec(ctx, sqlDoneMessage, brigadeID); err != nil {
-		return fmt.Errorf("exec: %w", err)
-	}
+		var partnerID uuid.UUID
+		if err := tx.QueryRow(ctx, sqlGetBrigadePartnerID, brigadeID).Scan(&partnerID); err != nil {
+			// The brigade is purged after the fetch, its messages
+			// are gone with it, so there is nothing left to ack.
+			if errors.Is(err, pgx.ErrNoRows) {
+				res.Acked++
+
+				continue
+			}
+
+			return nil, fmt.Errorf("get brigade partner id: %w", err)
+		}
+
+		if partnerID != inPartnerID {
+			return nil, fmt.Errorf("%w: %s", ErrPartnerMismatch, inPartnerID.String())
+		}
+
+		tag, err := tx.Exec(ctx, sqlDoneMessage, brigadeID, ack.MsgType, leaseID)
+		if err != nil {
+			return nil, fmt.Errorf("exec: %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			res.Skipped = append(res.Skipped, ack.RequestID)
 
-	if _, err := tx.Exec(ctx, sqlDeleteTelegramID, brigadeID); err != nil {
-		return fmt.Errorf("exec delete tg id: %w", err)
+			continue
+		}
+
+		res.Acked++
+
+		if ack.MsgType == ministry.VIPMessageConfig {
+			if _, err := tx.Exec(ctx, sqlDeleteTelegramID, brigadeID); err != nil {
+				return nil, fmt.Errorf("exec delete tg id: %w", err)
+			}
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("commit: %w", err)
+		return nil, fmt.Errorf("commit: %w", err)
 	}
 
-	return nil
+	return res, nil
 }
 
-func readConfigs() (uuid.UUID, string, error) {
+func readConfigs() (*idcodec.Codec, string, error) {
 	dbURL := os.Getenv("DB_URL")
 	if dbURL == "" {
 		dbURL = defaultDatabaseURL
 	}
 
-	obfsKey := os.Getenv("OBFS_UUID")
-
-	obfsUUID, err := uuid.Parse(obfsKey)
+	codec, err := idcodec.NewFromEnv()
 	if err != nil {
-		return uuid.Nil, dbURL, fmt.Errorf("parse obfs uuid: %w", err)
+		return nil, dbURL, fmt.Errorf("id codec: %w", err)
 	}
 
-	obfsUUID[6] &= 0x0F
-	obfsUUID[8] &= 0x3F
-
-	return obfsUUID, dbURL, nil
+	return codec, dbURL, nil
 }
 
-func parseArgs() (bool, []byte, uuid.UUID, error) {
+func parseArgs() (opts, error) {
+	o := opts{}
+
 	chunked := flag.Bool("ch", false, "chunked output")
-	actDone := flag.String("id", "", "action done")
+	actDone := flag.String("id", "", "action done: comma separated request IDs, optionally id:type")
+	msgType := flag.String("type", ministry.VIPMessageConfig, "type of the done messages without explicit type")
+	types := flag.String("types", ministry.VIPMessageConfig, "comma separated types of the messages to get")
+	batch := flag.Int("n", 0, fmt.Sprintf("batch size, up to %d, the messages are returned with the lease", maxBatch))
+	leaseTTL := flag.Duration("ttl", defaultLeaseTTL, "lease duration")
+	lease := flag.String("lease", "", "lease token of the done messages")
 
 	flag.Parse()
 
 	a := flag.Args()
 	if len(a) < 1 {
-		return false, nil, uuid.Nil, fmt.Errorf("access token: %w", ErrEmptyAccessToken)
+		return o, fmt.Errorf("access token: %w", ErrEmptyAccessToken)
 	}
 
 	token := make([]byte, base64.URLEncoding.WithPadding(base64.NoPadding).DecodedLen(len(a[0])))
 	if _, err := base64.URLEncoding.WithPadding(base64.NoPadding).Decode(token, []byte(a[0])); err != nil {
-		return false, nil, uuid.Nil, fmt.Errorf("access token: %w", err)
+		return o, fmt.Errorf("access token: %w", err)
+	}
+
+	o.chunked = *chunked
+	o.token = token
+
+	if *batch < 0 || *batch > maxBatch {
+		return o, fmt.Errorf("%w: %d", ErrInvalidBatch, *batch)
+	}
+
+	if *leaseTTL <= 0 || *leaseTTL > maxLeaseTTL {
+		return o, fmt.Errorf("%w: %s", ErrInvalidLeaseTTL, *leaseTTL)
+	}
+
+	o.batch = *batch
+	o.leaseTTL = *leaseTTL
+
+	for _, typ := range strings.Split(*types, ",") {
+		if typ = strings.TrimSpace(typ); typ == "" {
+			continue
+		}
+
+		if err := checkMsgType(typ); err != nil {
+			return o, fmt.Errorf("types: %w", err)
+		}
+
+		o.types = append(o.types, typ)
+	}
+
+	if len(o.types) == 0 {
+		return o, fmt.Errorf("types: %w: empty", ErrInvalidMsgType)
 	}
 
 	if *actDone == "" {
-		return *chunked, token, uuid.Nil, nil
+		return o, nil
 	}
 
-	var inUUID uuid.UUID
-	inUUID, err := uuid.Parse(*actDone)
-	if err != nil {
-		buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(*actDone)
+	if *lease != "" {
+		id, err := uuid.Parse(*lease)
 		if err != nil {
-			return false, nil, uuid.Nil, fmt.Errorf("action done: %w:%s", ErrInvalidUUID, err.Error())
+			return o, fmt.Errorf("lease: %w: %s", ErrInvalidUUID, err)
 		}
 
-		if len(buf) != 16 {
-			return false, nil, uuid.Nil, fmt.Errorf("action done: %w", ErrInvalidUUID)
+		o.lease = id
+	}
+
+	for _, item := range strings.Split(*actDone, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
 		}
+
+		sid, typ, ok := strings.Cut(item, ":")
+		if !ok {
+			typ = *msgType
+		}
+
+		if err := checkMsgType(typ); err != nil {
+			return o, err
+		}
+
+		id, err := parseRequestID(sid)
+		if err != nil {
+			return o, fmt.Errorf("action done: %w", err)
+		}
+
+		o.acks = append(o.acks, ackItem{RequestID: id, MsgType: typ})
+	}
+
+	return o, nil
+}
+
+func checkMsgType(msgType string) error {
+	switch msgType {
+	case ministry.VIPMessageConfig,
+		ministry.VIPMessageExpiring,
+		ministry.VIPMessageExpired,
+		ministry.VIPMessageGraceEnding,
+		ministry.VIPMessageSeatsChanged:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidMsgType, msgType)
+	}
+}
+
+// parseRequestID - the request ID is a UUID or its base32 form.
+func parseRequestID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err == nil {
+		return id, nil
+	}
+
+	buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w:%s", ErrInvalidUUID, err.Error())
+	}
+
+	id, err = uuid.FromBytes(buf)
+	if err != nil {
+		return uuid.Nil, ErrInvalidUUID
 	}
 
-	return *chunked, token, inUUID, nil
+	return id, nil
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	snapsCrypto "github.com/vpngen/keydesk-snap/core/crypto"
 	"github.com/vpngen/vpngine/naclkey"
@@ -34,6 +35,8 @@ type cfg struct {
 
 	force  bool
 	mirror bool
+
+	workers int
 }
 
 type opts struct {
@@ -51,6 +54,8 @@ type opts struct {
 
 	force  bool
 	mirror bool
+
+	workers int
 }
 
 var (
@@ -62,6 +67,8 @@ var (
 	ErrNoAuthorityPrivKey = errors.New("no authority private key")
 	ErrNoMasterPrivKey    = errors.New("no master private key")
 	ErrNoRealmsKeysFile   = errors.New("no realms keys file")
+
+	ErrInvalidWorkers = errors.New("invalid workers count")
 )
 
 func conf() (*opts, error) {
@@ -116,6 +123,8 @@ func conf() (*opts, error) {
 		force:   c.force,
 		mirror:  c.mirror,
 		mapfile: c.mapfile,
+
+		workers: c.workers,
 	}, nil
 }
 
@@ -148,6 +157,10 @@ func ckconfdefs(c *cfg) error {
 		return ErrNoRealmsKeysFile
 	}
 
+	if c.workers < 1 {
+		return fmt.Errorf("%w: %d", ErrInvalidWorkers, c.workers)
+	}
+
 	return nil
 }
 
@@ -162,6 +175,7 @@ func parseArgs(c *cfg) error {
 	force := flag.Bool("force", false, "force ignore snapshot errors")
 	mirror := flag.Bool("mirror", false, "mirror mode. Only same IP addresses on both sides are allowed")
 	mapfile := flag.String("map", "", "mapping file. Ovewright mirror. Default: none")
+	workers := flag.Int("w", runtime.NumCPU(), "parallel decode and encode workers. Default: CPUs count")
 
 	flag.Parse()
 
@@ -179,6 +193,7 @@ func parseArgs(c *cfg) error {
 	c.force = *force
 	c.mirror = *mirror
 	c.mapfile = *mapfile
+	c.workers = *workers
 
 	return nil
 }
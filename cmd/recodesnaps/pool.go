@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval - how often the stage progress is reported.
+const progressInterval = 2 * time.Second
+
+// parallel - calls fn for every index on the workers. The RSA and NaCl
+// work is CPU bound, the results and the errors are in the index order,
+// so the plan doesn't depend on the workers count.
+func parallel[T any](stage string, n int, workers int, fn func(i int) (T, error)) ([]T, []error) {
+	results := make([]T, n)
+	errs := make([]error, n)
+
+	workers = max(1, min(workers, n))
+
+	var (
+		next atomic.Int64
+		done atomic.Int64
+		wg   sync.WaitGroup
+	)
+
+	stop := make(chan struct{})
+	reported := make(chan struct{})
+
+	go func() {
+		defer close(reported)
+
+		progress(stage, n, &done, stop)
+	}()
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+
+				results[i], errs[i] = fn(i)
+
+				done.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	close(stop)
+	<-reported
+
+	return results, errs
+}
+
+// progress - reports the done count of the stage on stderr until stopped.
+func progress(stage string, total int, done *atomic.Int64, stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	started := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Fprintf(os.Stderr, "%s: %s: %d/%d\n", LogTag, stage, done.Load(), total)
+		case <-stop:
+			fmt.Fprintf(os.Stderr, "%s: %s: %d/%d done in %s\n", LogTag, stage, done.Load(), total,
+				time.Since(started).Round(time.Millisecond))
+
+			return
+		}
+	}
+}
+
+// firstError - the error of the lowest index.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -62,6 +62,13 @@ type prepNode struct {
 	used   map[string]struct{}
 }
 
+// encodeJob - the brigade placed to the node slot.
+type encodeJob struct {
+	brigade *storage.Brigade
+	slot    string
+	node    *prepNode
+}
+
 var (
 	ErrMirroredIPNotFound   = fmt.Errorf("mirrored ip not found")
 	ErrMirroredIPDuplicated = fmt.Errorf("mirrored ip duplicated")
@@ -70,96 +77,48 @@ var (
 func createRestorePlanMapped(data *dcmgmt.AggrSnaps, reservConfig *dcmgmt.ReservationConfig, mapping map[string]string,
 	opts *opts, psk []byte,
 ) (*dcmgmt.RestorePlan, error) {
-	nodes := make(map[string]*prepNode)
-
-	for _, snap := range data.Snaps {
-		brigade, err := decodeEncryptedBrigade(snap, psk, opts)
-		if err != nil {
-			return nil, fmt.Errorf("decode brigade: %w", err)
-		}
-
-		addr, ok := mapping[snap.BrigadeID]
-		if !ok {
-			return nil, fmt.Errorf("%w: %s", ErrMirroredIPNotFound, snap.BrigadeID)
-		}
-
-		var node *prepNode
-
-		ok = false
-
-		for _, ctrl := range reservConfig.Plan {
-			for _, slot := range ctrl.Slots {
-				if slot == addr {
-					node, ok = nodes[ctrl.ControlIP]
-					if !ok {
-						routerPub, err := naclkey.UnmarshalPublicKey([]byte(ctrl.RouterNACLPubKey))
-						if err != nil {
-							return nil, fmt.Errorf("unmarshal router public key: %w", err)
-						}
-
-						nodeConfig := &dcmgmt.RestoreNodeConfig{
-							ControlIP: ctrl.ControlIP,
-							Snaps:     make([]dcmgmt.PreparedSnap, 0, len(ctrl.Slots)),
-						}
-
-						node = &prepNode{
-							config: nodeConfig,
-							pubkey: routerPub,
-							used:   make(map[string]struct{}),
-						}
-
-						nodes[ctrl.ControlIP] = node
-					}
-				}
+	return createRestorePlanAddressed(data, reservConfig, opts, psk,
+		func(snap *dcmgmt.EncryptedBrigade, _ *storage.Brigade) (string, error) {
+			addr, ok := mapping[snap.BrigadeID]
+			if !ok {
+				return "", fmt.Errorf("%w: %s", ErrMirroredIPNotFound, snap.BrigadeID)
 			}
-		}
-
-		if node == nil {
-			return nil, fmt.Errorf("%w: %s", ErrMirroredIPNotFound, addr)
-		}
-
-		if _, ok := node.used[addr]; ok {
-			return nil, fmt.Errorf("%w: %s: %s", ErrMirroredIPDuplicated, addr, brigade.BrigadeID)
-		}
-
-		snap, err := encodeEncryptedBrigade(brigade, addr, reservConfig.ReservationID, &node.pubkey, opts)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "encode brigade: %s\n", err)
-
-			continue
-			// return nil, fmt.Errorf("encode brigade: %w", err)
-		}
-
-		node.config.Snaps = append(node.config.Snaps, *snap)
-
-		node.used[addr] = struct{}{}
-	}
-
-	plan := &dcmgmt.RestorePlan{
-		ReservationID: reservConfig.ReservationID,
-		RealmFP:       opts.targetRealmFP,
-	}
-
-	for _, node := range nodes {
-		plan.Plan = append(plan.Plan, *node.config)
-	}
 
-	return plan, nil
+			return addr, nil
+		},
+	)
 }
 
 func createRestorePlanMirrored(data *dcmgmt.AggrSnaps, reservConfig *dcmgmt.ReservationConfig,
 	opts *opts, psk []byte,
 ) (*dcmgmt.RestorePlan, error) {
+	return createRestorePlanAddressed(data, reservConfig, opts, psk,
+		func(_ *dcmgmt.EncryptedBrigade, brigade *storage.Brigade) (string, error) {
+			return brigade.EndpointIPv4.String(), nil
+		},
+	)
+}
+
+// createRestorePlanAddressed - places every brigade to the slot of its address.
+func createRestorePlanAddressed(data *dcmgmt.AggrSnaps, reservConfig *dcmgmt.ReservationConfig,
+	opts *opts, psk []byte, address func(*dcmgmt.EncryptedBrigade, *storage.Brigade) (string, error),
+) (*dcmgmt.RestorePlan, error) {
+	brigades, err := decodeEncryptedBrigades(data.Snaps, psk, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	nodes := make(map[string]*prepNode)
+	jobs := make([]encodeJob, 0, len(brigades))
+
+	for i, snap := range data.Snaps {
+		brigade := brigades[i]
 
-	for _, snap := range data.Snaps {
-		brigade, err := decodeEncryptedBrigade(snap, psk, opts)
+		addr, err := address(snap, brigade)
 		if err != nil {
-			return nil, fmt.Errorf("decode brigade: %w", err)
+			return nil, err
 		}
 
-		addr := brigade.EndpointIPv4.String()
-
 		var (
 			node *prepNode
 			ok   bool
@@ -196,21 +155,26 @@ func createRestorePlanMirrored(data *dcmgmt.AggrSnaps, reservConfig *dcmgmt.Rese
 			return nil, fmt.Errorf("%w: %s", ErrMirroredIPNotFound, addr)
 		}
 
-		if _, ok := node.used[addr]; ok {
-			return nil, fmt.Errorf("%w: %s: %s", ErrMirroredIPDuplicated, addr, brigade.BrigadeID)
-		}
+		jobs = append(jobs, encodeJob{brigade: brigade, slot: addr, node: node})
+	}
 
-		snap, err := encodeEncryptedBrigade(brigade, addr, reservConfig.ReservationID, &node.pubkey, opts)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "encode brigade: %s\n", err)
+	snaps, errs := encodeEncryptedBrigades(jobs, reservConfig.ReservationID, opts)
+
+	// the slot of the brigade failed to encode stays free
+	for i, job := range jobs {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "encode brigade: %s\n", errs[i])
 
 			continue
-			// return nil, fmt.Errorf("encode brigade: %w", err)
 		}
 
-		node.config.Snaps = append(node.config.Snaps, *snap)
+		if _, ok := job.node.used[job.slot]; ok {
+			return nil, fmt.Errorf("%w: %s: %s", ErrMirroredIPDuplicated, job.slot, job.brigade.BrigadeID)
+		}
+
+		job.node.config.Snaps = append(job.node.config.Snaps, *snaps[i])
 
-		node.used[addr] = struct{}{}
+		job.node.used[job.slot] = struct{}{}
 	}
 
 	plan := &dcmgmt.RestorePlan{
@@ -218,57 +182,121 @@ func createRestorePlanMirrored(data *dcmgmt.AggrSnaps, reservConfig *dcmgmt.Rese
 		RealmFP:       opts.targetRealmFP,
 	}
 
-	for _, node := range nodes {
-		plan.Plan = append(plan.Plan, *node.config)
+	// in the reservation order
+	for _, ctrl := range reservConfig.Plan {
+		if node, ok := nodes[ctrl.ControlIP]; ok {
+			plan.Plan = append(plan.Plan, *node.config)
+
+			delete(nodes, ctrl.ControlIP)
+		}
 	}
 
 	return plan, nil
 }
 
+// createRestorePlanNotMirrored - places the brigades to the slots in the
+// reservation order. The brigade failed to encode is retried on the next
+// slot, the failed slot stays free.
 func createRestorePlanNotMirrored(data *dcmgmt.AggrSnaps, reservConfig *dcmgmt.ReservationConfig,
 	opts *opts, psk []byte,
 ) (*dcmgmt.RestorePlan, error) {
-	plan := &dcmgmt.RestorePlan{
-		ReservationID: reservConfig.ReservationID,
-		RealmFP:       opts.targetRealmFP,
+	brigades, err := decodeEncryptedBrigades(data.Snaps, psk, opts)
+	if err != nil {
+		return nil, err
 	}
 
+	nodes := make([]*prepNode, 0, len(reservConfig.Plan))
+	slots := make([]encodeJob, 0, len(brigades))
+
 	for _, ctrl := range reservConfig.Plan {
 		routerPub, err := naclkey.UnmarshalPublicKey([]byte(ctrl.RouterNACLPubKey))
 		if err != nil {
 			return nil, fmt.Errorf("unmarshal router public key: %w", err)
 		}
 
-		nodeConfig := &dcmgmt.RestoreNodeConfig{
-			ControlIP: ctrl.ControlIP,
-			Snaps:     make([]dcmgmt.PreparedSnap, 0, len(ctrl.Slots)),
+		node := &prepNode{
+			config: &dcmgmt.RestoreNodeConfig{
+				ControlIP: ctrl.ControlIP,
+				Snaps:     make([]dcmgmt.PreparedSnap, 0, len(ctrl.Slots)),
+			},
+			pubkey: routerPub,
 		}
 
+		nodes = append(nodes, node)
+
 		for _, slot := range ctrl.Slots {
-			brigade, err := decodeEncryptedBrigade(data.Snaps[0], psk, opts)
-			if err != nil {
-				return nil, fmt.Errorf("decode brigade: %w", err)
-			}
+			slots = append(slots, encodeJob{slot: slot, node: node})
+		}
+	}
+
+	// The brigades are encoded on the workers slot by slot in rounds. The
+	// round is accepted up to the first failure, the brigades from the
+	// failed one are moved by one slot and encoded again in the next round.
+	for len(brigades) > 0 && len(slots) > 0 {
+		jobs := slots[:min(len(brigades), len(slots))]
+		for i := range jobs {
+			jobs[i].brigade = brigades[i]
+		}
+
+		snaps, errs := encodeEncryptedBrigades(jobs, reservConfig.ReservationID, opts)
+
+		done := len(jobs)
 
-			snap, err := encodeEncryptedBrigade(brigade, slot, reservConfig.ReservationID, &routerPub, opts)
+		for i, err := range errs {
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "encode brigade: %s\n", err)
 
-				continue
-				// return nil, fmt.Errorf("encode brigade: %w", err)
-			}
+				done = i
 
-			data.Snaps = data.Snaps[1:]
+				break
+			}
+		}
 
-			nodeConfig.Snaps = append(nodeConfig.Snaps, *snap)
+		for i := range done {
+			jobs[i].node.config.Snaps = append(jobs[i].node.config.Snaps, *snaps[i])
 		}
 
-		plan.Plan = append(plan.Plan, *nodeConfig)
+		brigades = brigades[done:]
+		slots = slots[min(done+1, len(jobs)):]
+	}
+
+	if len(brigades) > 0 {
+		fmt.Fprintf(os.Stderr, "no free slot for %d brigades\n", len(brigades))
+	}
+
+	plan := &dcmgmt.RestorePlan{
+		ReservationID: reservConfig.ReservationID,
+		RealmFP:       opts.targetRealmFP,
+	}
+
+	for _, node := range nodes {
+		plan.Plan = append(plan.Plan, *node.config)
 	}
 
 	return plan, nil
 }
 
+// decodeEncryptedBrigades - decodes the snaps on the workers,
+// fails with the error of the first snap failed.
+func decodeEncryptedBrigades(snaps []*dcmgmt.EncryptedBrigade, psk []byte, opts *opts) ([]*storage.Brigade, error) {
+	brigades, errs := parallel("decode", len(snaps), opts.workers, func(i int) (*storage.Brigade, error) {
+		return decodeEncryptedBrigade(snaps[i], psk, opts)
+	})
+
+	if err := firstError(errs); err != nil {
+		return nil, fmt.Errorf("decode brigade: %w", err)
+	}
+
+	return brigades, nil
+}
+
+// encodeEncryptedBrigades - encodes the placed brigades on the workers.
+func encodeEncryptedBrigades(jobs []encodeJob, reservationID string, opts *opts) ([]*dcmgmt.PreparedSnap, []error) {
+	return parallel("encode", len(jobs), opts.workers, func(i int) (*dcmgmt.PreparedSnap, error) {
+		return encodeEncryptedBrigade(jobs[i].brigade, jobs[i].slot, reservationID, &jobs[i].node.pubkey, opts)
+	})
+}
+
 func decodeEncryptedBrigade(snap *dcmgmt.EncryptedBrigade,
 	psk []byte, opts *opts,
 ) (*storage.Brigade, error) {
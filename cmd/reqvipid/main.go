@@ -16,6 +16,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/vpngen/ministry"
 	"github.com/vpngen/ministry/internal/core"
+	"github.com/vpngen/ministry/internal/idcodec"
 	"github.com/vpngen/ministry/internal/pgsql"
 	"github.com/vpngen/wordsgens/namesgenerator"
 )
@@ -53,7 +54,7 @@ func main() {
 		w = os.Stdout
 	}
 
-	obfsUUID, dbURL, err := readConfigs()
+	codec, dbURL, err := readConfigs()
 	if err != nil {
 		fatal(w, "Can't read configs: %s\n", err)
 	}
@@ -79,9 +80,9 @@ func main() {
 		fatal(w, "%s: Can't create brigade: %s\n", LogTag, err)
 	}
 
-	var resUUID uuid.UUID
-	for i := range 16 {
-		resUUID[i] = brigadeID[i] ^ obfsUUID[i]
+	resUUID, err := codec.Encode(brigadeID)
+	if err != nil {
+		fatal(w, "%s: Can't encode request id: %s\n", LogTag, err)
 	}
 
 	answ := ministry.VIPReserve{
@@ -98,23 +99,18 @@ func main() {
 	}
 }
 
-func readConfigs() (uuid.UUID, string, error) {
+func readConfigs() (*idcodec.Codec, string, error) {
 	dbURL := os.Getenv("DB_URL")
 	if dbURL == "" {
 		dbURL = defaultDatabaseURL
 	}
 
-	obfsKey := os.Getenv("OBFS_UUID")
-
-	obfsUUID, err := uuid.Parse(obfsKey)
+	codec, err := idcodec.NewFromEnv()
 	if err != nil {
-		return uuid.Nil, dbURL, fmt.Errorf("parse obfs uuid: %w", err)
+		return nil, dbURL, fmt.Errorf("id codec: %w", err)
 	}
 
-	obfsUUID[6] &= 0x0F
-	obfsUUID[8] &= 0x3F
-
-	return obfsUUID, dbURL, nil
+	return codec, dbURL, nil
 }
 
 func parseArgs() (*namesgenerator.Person, string, bool, []byte, string, string, int64, int64, error) {
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/pgsql"
+	"github.com/vpngen/ministry/internal/tghash"
+)
+
+const (
+	LogTag             = "retention"
+	defaultDatabaseURL = "postgresql:///vgdept"
+)
+
+const (
+	defaultLabelsDays        = 180
+	defaultTelegramGraceDays = 30
+	defaultBatchSize         = 1000
+)
+
+// Telegram ID policies.
+const (
+	telegramHash  = "hash"  // replace the ID with its keyed hash
+	telegramErase = "erase" // delete the row
+	telegramKeep  = "keep"
+)
+
+var (
+	ErrInvalidArgs   = errors.New("invalid args")
+	ErrNoTelegramKey = errors.New("no telegram hash key")
+)
+
+// Policy - the retention policy, the zero LabelsDays keeps the orphan labels.
+type Policy struct {
+	LabelsDays        int
+	Telegram          string
+	TelegramGraceDays int
+	BatchSize         int
+	Apply             bool
+}
+
+func main() {
+	policy, err := parseArgs()
+	if err != nil {
+		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
+	}
+
+	var key []byte
+
+	// the dry run only counts the rows
+	if policy.Apply && policy.Telegram == telegramHash {
+		key, err = tghash.ReadKey()
+		if err != nil {
+			log.Fatalf("%s: Can't read telegram hash key: %s\n", LogTag, err)
+		}
+
+		if key == nil {
+			log.Fatalf("%s: %s, use -telegram %s or %s\n", LogTag, ErrNoTelegramKey, telegramErase, telegramKeep)
+		}
+	}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = defaultDatabaseURL
+	}
+
+	db, err := pgsql.CreateDBPool(dbURL)
+	if err != nil {
+		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+	}
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	runID := uuid.New()
+
+	mode := "dry run"
+	if policy.Apply {
+		mode = "apply, run " + runID.String()
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: Retention (%s)\n", LogTag, mode)
+
+	if policy.LabelsDays > 0 {
+		n, err := retainLabels(ctx, db, policy, runID, now.AddDate(0, 0, -policy.LabelsDays))
+		if err != nil {
+			log.Fatalf("%s: Can't retain start labels: %s\n", LogTag, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: start_labels: %d orphan labels older than %d days %s\n", LogTag,
+			n, policy.LabelsDays, verb(policy.Apply, "deleted", "to delete"))
+	}
+
+	if policy.Telegram != telegramKeep {
+		n, err := retainTelegramIDs(ctx, db, policy, key, runID, now.AddDate(0, 0, -policy.TelegramGraceDays))
+		if err != nil {
+			log.Fatalf("%s: Can't retain telegram ids: %s\n", LogTag, err)
+		}
+
+		done := "hashed"
+		if policy.Telegram == telegramErase {
+			done = "erased"
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: vip_telegram_ids: %d telegram ids of purged or %d days ago expired VIP brigades %s\n", LogTag,
+			n, policy.TelegramGraceDays, verb(policy.Apply, done, "to "+policy.Telegram))
+	}
+}
+
+func verb(apply bool, done, todo string) string {
+	if apply {
+		return done
+	}
+
+	return todo
+}
+
+// sqlOrphanLabels - the labels which never got a brigade.
+const sqlOrphanLabels = `
+	brigade_id IS NULL
+	AND first_visit < $1
+`
+
+const sqlCountOrphanLabels = `
+SELECT
+	COUNT(*)
+FROM
+	head.start_labels
+WHERE
+` + sqlOrphanLabels
+
+const sqlDeleteOrphanLabels = `
+WITH deleted AS (
+	DELETE FROM
+		head.start_labels
+	WHERE
+		ctid IN (
+			SELECT
+				ctid
+			FROM
+				head.start_labels
+			WHERE
+` + sqlOrphanLabels + `
+			LIMIT $2
+		)
+	RETURNING
+		label_id, partner_id, first_visit
+)
+INSERT INTO
+	head.retention_log (run_id, table_name, row_key, action, reason)
+SELECT
+	$3::uuid, 'start_labels', ARRAY[label_id::text, partner_id::text, first_visit::text], 'delete', 'orphan'
+FROM
+	deleted
+`
+
+// retainLabels - deletes the orphan labels first visited before the time.
+func retainLabels(ctx context.Context, db *pgxpool.Pool, policy Policy, runID uuid.UUID, before time.Time) (int64, error) {
+	if !policy.Apply {
+		var n int64
+
+		if err := db.QueryRow(ctx, sqlCountOrphanLabels, before).Scan(&n); err != nil {
+			return 0, fmt.Errorf("count: %w", err)
+		}
+
+		return n, nil
+	}
+
+	return inBatches(ctx, db, func(tx pgx.Tx) (int64, error) {
+		tag, err := tx.Exec(ctx, sqlDeleteOrphanLabels, before, policy.BatchSize, runID)
+		if err != nil {
+			return 0, fmt.Errorf("delete: %w", err)
+		}
+
+		return tag.RowsAffected(), nil
+	}, policy.BatchSize)
+}
+
+// sqlTelegramRetained - the telegram IDs of the purged brigades and
+// the brigades with VIP expired (or turned off) before the time. The
+// brigadier_vip row is purged after the grace hours, then the VIP is
+// over at the last end (vipoff) or purge event. The reserved brigades
+// waiting for VIP have neither the row nor the events.
+const sqlTelegramRetained = `
+FROM
+	head.vip_telegram_ids vt
+	JOIN head.brigadiers_ids bi ON bi.brigade_id = vt.brigade_id
+	LEFT JOIN head.brigadier_vip bv ON bv.brigade_id = vt.brigade_id
+WHERE
+	($2 OR vt.telegram_id IS NOT NULL)
+	AND (
+		bi.purged_at IS NOT NULL
+		OR (bv.brigade_id IS NOT NULL AND COALESCE(bv.vip_expire, bv.update_time) < $1)
+		OR (bv.brigade_id IS NULL AND (
+			SELECT
+				max(va.event_time)
+			FROM
+				head.brigadier_vip_actions va
+			WHERE
+				va.brigade_id = vt.brigade_id
+				AND va.event_name IN ('end', 'purge')
+		) < $1)
+	)
+`
+
+const sqlCountTelegramRetained = `
+SELECT
+	COUNT(*)
+` + sqlTelegramRetained
+
+const sqlSelectTelegramRetained = `
+SELECT
+	vt.brigade_id,
+	vt.telegram_id,
+	CASE WHEN bi.purged_at IS NOT NULL THEN 'purged' ELSE 'vip_over' END
+` + sqlTelegramRetained + `
+LIMIT $3
+FOR UPDATE OF vt
+`
+
+const sqlHashTelegramIDs = `
+UPDATE
+	head.vip_telegram_ids vt
+SET
+	telegram_id = NULL,
+	telegram_hash = u.telegram_hash
+FROM
+	unnest($1::uuid[], $2::text[]) AS u(brigade_id, telegram_hash)
+WHERE
+	vt.brigade_id = u.brigade_id
+`
+
+const sqlEraseTelegramIDs = `
+DELETE FROM
+	head.vip_telegram_ids
+WHERE
+	brigade_id = ANY($1::uuid[])
+`
+
+const sqlLogTelegramIDs = `
+INSERT INTO
+	head.retention_log (run_id, table_name, row_key, action, reason)
+SELECT
+	$1::uuid, 'vip_telegram_ids', ARRAY[brigade_id::text], $2::text, reason
+FROM
+	unnest($3::uuid[], $4::text[]) AS u(brigade_id, reason)
+`
+
+// retainTelegramIDs - hashes or erases the telegram IDs of the brigades
+// purged or with VIP over before the time.
+func retainTelegramIDs(ctx context.Context, db *pgxpool.Pool, policy Policy, key []byte, runID uuid.UUID, before time.Time) (int64, error) {
+	erase := policy.Telegram == telegramErase
+
+	if !policy.Apply {
+		var n int64
+
+		if err := db.QueryRow(ctx, sqlCountTelegramRetained, before, erase).Scan(&n); err != nil {
+			return 0, fmt.Errorf("count: %w", err)
+		}
+
+		return n, nil
+	}
+
+	return inBatches(ctx, db, func(tx pgx.Tx) (int64, error) {
+		rows, err := tx.Query(ctx, sqlSelectTelegramRetained, before, erase, policy.BatchSize)
+		if err != nil {
+			return 0, fmt.Errorf("select: %w", err)
+		}
+
+		var (
+			ids     []uuid.UUID
+			hashes  []string
+			reasons []string
+
+			id         uuid.UUID
+			telegramID *int64
+			reason     string
+		)
+
+		if _, err := pgx.ForEachRow(rows, []any{&id, &telegramID, &reason}, func() error {
+			ids = append(ids, id)
+			reasons = append(reasons, reason)
+
+			if !erase && telegramID != nil {
+				hashes = append(hashes, tghash.Hash(key, *telegramID))
+			}
+
+			return nil
+		}); err != nil {
+			return 0, fmt.Errorf("select: %w", err)
+		}
+
+		if len(ids) == 0 {
+			return 0, nil
+		}
+
+		switch erase {
+		case true:
+			if _, err := tx.Exec(ctx, sqlEraseTelegramIDs, ids); err != nil {
+				return 0, fmt.Errorf("erase: %w", err)
+			}
+		default:
+			if _, err := tx.Exec(ctx, sqlHashTelegramIDs, ids, hashes); err != nil {
+				return 0, fmt.Errorf("hash: %w", err)
+			}
+		}
+
+		if _, err := tx.Exec(ctx, sqlLogTelegramIDs, runID, policy.Telegram, ids, reasons); err != nil {
+			return 0, fmt.Errorf("log: %w", err)
+		}
+
+		return int64(len(ids)), nil
+	}, policy.BatchSize)
+}
+
+// inBatches - runs the batch in its own transaction until it's not full.
+func inBatches(ctx context.Context, db *pgxpool.Pool, batch func(tx pgx.Tx) (int64, error), size int) (int64, error) {
+	var total int64
+
+	for {
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return total, fmt.Errorf("begin: %w", err)
+		}
+
+		n, err := batch(tx)
+		if err != nil {
+			tx.Rollback(ctx)
+
+			return total, err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return total, fmt.Errorf("commit: %w", err)
+		}
+
+		total += n
+
+		if n < int64(size) {
+			return total, nil
+		}
+	}
+}
+
+func parseArgs() (Policy, error) {
+	labelsDays := flag.Int("labels-days", defaultLabelsDays, "delete the start labels without a brigade first visited the days ago, 0 keeps them")
+	telegram := flag.String("telegram", telegramHash, "telegram ids of the purged or VIP over brigades: hash, erase or keep")
+	graceDays := flag.Int("telegram-grace-days", defaultTelegramGraceDays, "days after the VIP is over the telegram id is kept")
+	batchSize := flag.Int("batch", defaultBatchSize, "rows per transaction")
+	apply := flag.Bool("apply", false, "apply the policy, the default is the dry run")
+
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		return Policy{}, fmt.Errorf("%w: %v", ErrInvalidArgs, flag.Args())
+	}
+
+	switch *telegram {
+	case telegramHash, telegramErase, telegramKeep:
+	default:
+		return Policy{}, fmt.Errorf("%w: telegram: %s", ErrInvalidArgs, *telegram)
+	}
+
+	if *labelsDays < 0 || *graceDays < 0 || *batchSize <= 0 {
+		return Policy{}, fmt.Errorf("%w: negative days or batch size", ErrInvalidArgs)
+	}
+
+	return Policy{
+		LabelsDays:        *labelsDays,
+		Telegram:          *telegram,
+		TelegramGraceDays: *graceDays,
+		BatchSize:         *batchSize,
+		Apply:             *apply,
+	}, nil
+}
@@ -28,9 +28,9 @@ elif [ "restorebrigadier" = "${cmd}" ]; then
 elif [ "synclabels" = "${cmd}" ]; then
     "${basedir}"/synclabels "$@"
 elif [ "readmsgs" = "${cmd}" ]; then
-    OBFS_UUID="${OBFS_UUID}" "${basedir}"/readmsgs "$@"
+    OBFS_UUID="${OBFS_UUID}" IDCODEC_KEYS="${IDCODEC_KEYS}" IDCODEC_KEY_ID="${IDCODEC_KEY_ID}" "${basedir}"/readmsgs "$@"
 elif [ "reqvipid" = "${cmd}" ]; then
-    OBFS_UUID="${OBFS_UUID}" "${basedir}"/reqvipid "$@"
+    OBFS_UUID="${OBFS_UUID}" IDCODEC_KEYS="${IDCODEC_KEYS}" IDCODEC_KEY_ID="${IDCODEC_KEY_ID}" "${basedir}"/reqvipid "$@"
 else
     echo "Unknown command: ${cmd}"
     printdef
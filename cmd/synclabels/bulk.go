@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// stagingTable - the session temp table the batches are copied to.
+const stagingTable = "start_labels_staging"
+
+const sqlCreateStaging = `
+CREATE TEMP TABLE IF NOT EXISTS %s (
+	line            bigint NOT NULL,
+	label           text NOT NULL,
+	label_id        uuid NOT NULL,
+	first_visit     timestamp without time zone NOT NULL,
+	utm_source      text NOT NULL,
+	utm_medium      text NOT NULL,
+	utm_campaign    text NOT NULL,
+	utm_content     text NOT NULL,
+	utm_term        text NOT NULL,
+	referrer        text NOT NULL
+) ON COMMIT DELETE ROWS
+`
+
+var stagingColumns = []string{
+	"line", "label", "label_id", "first_visit",
+	"utm_source", "utm_medium", "utm_campaign", "utm_content", "utm_term", "referrer",
+}
+
+// sqlMergeStaging - the first line of the label wins within the batch.
+// The inserted rows have no xmax, the rows with the UTM fields
+// filled have. The rest of the batch is skipped as duplicates.
+const sqlMergeStaging = `
+WITH merged AS (
+	INSERT INTO
+		%s
+		(label, label_id, first_visit, partner_id, update_time,
+			utm_source, utm_medium, utm_campaign, utm_content, utm_term, referrer)
+	SELECT DISTINCT ON (label_id, first_visit)
+		label, label_id, first_visit, $1::uuid, NOW() AT TIME ZONE 'UTC',
+		NULLIF(utm_source, ''), NULLIF(utm_medium, ''), NULLIF(utm_campaign, ''),
+		NULLIF(utm_content, ''), NULLIF(utm_term, ''), NULLIF(referrer, '')
+	FROM
+		%s
+	ORDER BY
+		label_id, first_visit, line
+	%s
+	RETURNING
+		(xmax = 0) AS inserted
+)
+SELECT
+	COUNT(*) FILTER (WHERE inserted),
+	COUNT(*) FILTER (WHERE NOT inserted)
+FROM
+	merged
+`
+
+// bulkStats - the lines of the bulk sync.
+type bulkStats struct {
+	lines    int64
+	inserted int64
+	filled   int64
+	skipped  int64
+}
+
+// readAndSyncBulk - copies the lines by batches into the staging table
+// and merges every batch in its own transaction, so the committed
+// batches stay if the later one fails.
+func readAndSyncBulk(cfg *AppConfig) error {
+	ctx := context.Background()
+
+	conn, err := cfg.DB.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire conn: %w", err)
+	}
+
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf(sqlCreateStaging, (pgx.Identifier{stagingTable}).Sanitize())); err != nil {
+		return fmt.Errorf("create staging: %w", err)
+	}
+
+	rj := newRejecter(cfg)
+
+	var (
+		total  bulkStats
+		batch  [][]any
+		number int
+	)
+
+	// the rejects limit is checked before every batch,
+	// the merged batches stay if it is exceeded later
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := rj.check(); err != nil {
+			return err
+		}
+
+		number++
+
+		stats, err := mergeBatch(ctx, conn, cfg, batch)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", number, err)
+		}
+
+		total.lines += stats.lines
+		total.inserted += stats.inserted
+		total.filled += stats.filled
+		total.skipped += stats.skipped
+
+		fmt.Fprintf(os.Stderr, "Batch %d: %d lines: %d inserted, %d utm filled, %d skipped (total: %d lines)\n",
+			number, stats.lines, stats.inserted, stats.filled, stats.skipped, total.lines)
+
+		batch = batch[:0]
+
+		return nil
+	}
+
+	if err := rj.scan(os.Stdin, cfg.PartnerID, func(n int64, ll *labelLine) error {
+		batch = append(batch, []any{
+			n, ll.label, ll.labelID, ll.firstVisit,
+			ll.utm.Source, ll.utm.Medium, ll.utm.Campaign, ll.utm.Content, ll.utm.Term, ll.utm.Referrer,
+		})
+
+		if len(batch) >= cfg.BatchSize {
+			return flush()
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	rj.summary()
+
+	if err := rj.check(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Total: %d lines: %d inserted, %d utm filled, %d skipped as duplicates\n",
+		total.lines, total.inserted, total.filled, total.skipped)
+
+	return nil
+}
+
+// mergeBatch - copies the batch into the staging table and merges it.
+func mergeBatch(ctx context.Context, conn *pgxpool.Conn, cfg *AppConfig, batch [][]any) (bulkStats, error) {
+	stats := bulkStats{lines: int64(len(batch))}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{stagingTable}, stagingColumns, pgx.CopyFromRows(batch)); err != nil {
+		return stats, fmt.Errorf("copy: %w", err)
+	}
+
+	if err := tx.QueryRow(ctx,
+		fmt.Sprintf(
+			sqlMergeStaging,
+			(pgx.Identifier{"head", "start_labels"}).Sanitize(),
+			(pgx.Identifier{stagingTable}).Sanitize(),
+			sqlOnLabelConflict,
+		),
+		cfg.PartnerID,
+	).Scan(&stats.inserted, &stats.filled); err != nil {
+		return stats, fmt.Errorf("merge: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return stats, fmt.Errorf("commit tx: %w", err)
+	}
+
+	stats.skipped = stats.lines - stats.inserted - stats.filled
+
+	return stats, nil
+}
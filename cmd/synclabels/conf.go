@@ -12,15 +12,24 @@ import (
 
 const (
 	defaultDatabaseURL = "postgresql:///vgdept"
+	defaultBatchSize   = 10000
 )
 
-var ErrEmptyAccessToken = fmt.Errorf("empty access token")
+var (
+	ErrEmptyAccessToken = fmt.Errorf("empty access token")
+	ErrInvalidBatchSize = fmt.Errorf("invalid batch size")
+)
 
 type initConfig struct {
-	dbURL   string
-	chunked bool
-	name    string
-	token   []byte
+	dbURL     string
+	chunked   bool
+	name      string
+	token     []byte
+	bulk      bool
+	batchSize int
+
+	lenient        bool
+	maxRejectRatio float64
 }
 
 type AppConfig struct {
@@ -28,6 +37,11 @@ type AppConfig struct {
 	Chunked   bool
 	Name      string
 	PartnerID uuid.UUID
+	Bulk      bool
+	BatchSize int
+
+	Lenient        bool
+	MaxRejectRatio float64
 }
 
 func config() (*AppConfig, error) {
@@ -51,6 +65,11 @@ func config() (*AppConfig, error) {
 		Chunked:   c.chunked,
 		Name:      c.name,
 		PartnerID: partnerID,
+		Bulk:      c.bulk,
+		BatchSize: c.batchSize,
+
+		Lenient:        c.lenient,
+		MaxRejectRatio: c.maxRejectRatio,
 	}, nil
 }
 
@@ -73,11 +92,29 @@ func (c *initConfig) parseArgs() error {
 	chunked := flag.Bool("ch", false, "chunked output")
 	tokenArg := flag.String("token", "", "token")
 	name := flag.String("name", "", "name")
+	bulk := flag.Bool("bulk", false, "bulk mode: COPY the batches into a staging table and merge them")
+	batchSize := flag.Int("batch", defaultBatchSize, "bulk mode batch size, lines")
+	lenient := flag.Bool("lenient", false, "lenient mode: skip the invalid lines and report them to stderr, JSON lines")
+	maxRejectRatio := flag.Float64("max-reject-ratio", 1, "lenient mode: abort if the ratio of the rejected lines exceeds it")
 
 	flag.Parse()
 
 	c.chunked = *chunked
 	c.name = *name
+	c.bulk = *bulk
+
+	if *batchSize <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidBatchSize, *batchSize)
+	}
+
+	c.batchSize = *batchSize
+
+	if *maxRejectRatio < 0 || *maxRejectRatio > 1 {
+		return fmt.Errorf("%w: %g", ErrInvalidRejectRatio, *maxRejectRatio)
+	}
+
+	c.lenient = *lenient
+	c.maxRejectRatio = *maxRejectRatio
 
 	if *tokenArg == "" {
 		return fmt.Errorf("token: %w", ErrEmptyAccessToken)
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -10,9 +10,11 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/vpngen/ministry"
 )
 
 type labelLine struct {
@@ -20,6 +22,15 @@ type labelLine struct {
 	label      string
 	labelID    uuid.UUID
 	partnerID  uuid.UUID
+	utm        ministry.UTM
+}
+
+// jsonLabelLine - the JSON form of the label line, the UTM fields are optional.
+type jsonLabelLine struct {
+	FirstVisit int64  `json:"first_visit"`
+	LabelID    string `json:"label_id"`
+	Label      string `json:"label"`
+	ministry.UTM
 }
 
 func main() {
@@ -28,6 +39,14 @@ func main() {
 		log.Fatalf("Read configs: %s\n", err)
 	}
 
+	if cfg.Bulk {
+		if err := readAndSyncBulk(cfg); err != nil {
+			log.Fatalf("Read and sync bulk: %s\n", err)
+		}
+
+		return
+	}
+
 	if err := readAndSync(cfg); err != nil {
 		log.Fatalf("Read and sync: %s\n", err)
 	}
@@ -36,6 +55,8 @@ func main() {
 func readAndSync(cfg *AppConfig) error {
 	ctx := context.Background()
 
+	rj := newRejecter(cfg)
+
 	tx, err := cfg.DB.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
@@ -43,26 +64,20 @@ func readAndSync(cfg *AppConfig) error {
 
 	defer tx.Rollback(ctx)
 
-	fileScanner := bufio.NewScanner(os.Stdin)
-
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		line = strings.TrimSpace(line)
-
-		if line == "" {
-			continue
+	if err := rj.scan(os.Stdin, cfg.PartnerID, func(_ int64, ll *labelLine) error {
+		if err := syncLabel(ctx, tx, ll); err != nil {
+			return fmt.Errorf("sync label: %w", err)
 		}
 
-		ll, err := parseLine(line)
-		if err != nil {
-			return fmt.Errorf("parse line: %w", err)
-		}
+		return nil
+	}); err != nil {
+		return err
+	}
 
-		ll.partnerID = cfg.PartnerID
+	rj.summary()
 
-		if err := syncLabel(ctx, tx, ll); err != nil {
-			return fmt.Errorf("sync label: %w", err)
-		}
+	if err := rj.check(); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -77,9 +92,22 @@ var (
 	ErrZeroUUID      = errors.New("zero uuid")
 	ErrEmtyLabel     = errors.New("empty label")
 	ErrInvalidFields = errors.New("invalid fields")
+	ErrInvalidTime   = errors.New("invalid time")
+	ErrInvalidUUID   = errors.New("invalid uuid")
+	ErrInvalidJSON   = errors.New("invalid json")
+	ErrLabelTooLong  = errors.New("label too long")
 )
 
+// maxLabelLen - the length of start_labels.label.
+const maxLabelLen = 64
+
+// parseLine - parses the "first_visit|label_id|label" line
+// or the JSON line with the UTM fields.
 func parseLine(line string) (*labelLine, error) {
+	if strings.HasPrefix(line, "{") {
+		return parseJSONLine(line)
+	}
+
 	fields := strings.Split(line, "|")
 	if len(fields) != 3 {
 		return nil, fmt.Errorf("split fields: %w", ErrInvalidFields)
@@ -87,34 +115,74 @@ func parseLine(line string) (*labelLine, error) {
 
 	fv, err := strconv.ParseInt(fields[0], 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("parse int: %w", err)
+		return nil, fmt.Errorf("parse int: %w: %w", ErrInvalidTime, err)
+	}
+
+	return newLabelLine(fv, fields[1], fields[2], ministry.UTM{})
+}
+
+func parseJSONLine(line string) (*labelLine, error) {
+	var jl jsonLabelLine
+
+	if err := json.Unmarshal([]byte(line), &jl); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w: %w", ErrInvalidJSON, err)
+	}
+
+	if err := jl.UTM.Validate(); err != nil {
+		return nil, fmt.Errorf("utm: %w", err)
 	}
 
+	return newLabelLine(jl.FirstVisit, jl.LabelID, jl.Label, jl.UTM)
+}
+
+func newLabelLine(fv int64, labelID string, label string, utm ministry.UTM) (*labelLine, error) {
 	fvTime := time.Unix(fv, 0)
-	if fvTime.IsZero() {
+	if fv == 0 || fvTime.IsZero() {
 		return nil, ErrZeroTime
 	}
 
-	lid, err := uuid.Parse(fields[1])
+	lid, err := uuid.Parse(labelID)
 	if err != nil {
-		return nil, fmt.Errorf("parse uuid: %w", err)
+		return nil, fmt.Errorf("parse uuid: %w: %w", ErrInvalidUUID, err)
 	}
 
 	if lid == uuid.Nil {
 		return nil, ErrZeroUUID
 	}
 
-	if fields[2] == "" {
+	if label == "" {
 		return nil, ErrEmtyLabel
 	}
 
+	if utf8.RuneCountInString(label) > maxLabelLen {
+		return nil, ErrLabelTooLong
+	}
+
 	return &labelLine{
 		firstVisit: fvTime,
-		label:      fields[2],
+		label:      label,
 		labelID:    lid,
+		utm:        utm,
 	}, nil
 }
 
+// sqlOnLabelConflict - the UTM fields are filled once, if the known label has none.
+const sqlOnLabelConflict = `
+ON CONFLICT (label_id, partner_id, first_visit) DO UPDATE
+	SET
+		utm_source = EXCLUDED.utm_source,
+		utm_medium = EXCLUDED.utm_medium,
+		utm_campaign = EXCLUDED.utm_campaign,
+		utm_content = EXCLUDED.utm_content,
+		utm_term = EXCLUDED.utm_term,
+		referrer = EXCLUDED.referrer
+	WHERE
+		num_nulls(start_labels.utm_source, start_labels.utm_medium, start_labels.utm_campaign,
+			start_labels.utm_content, start_labels.utm_term, start_labels.referrer) = 6
+		AND num_nonnulls(EXCLUDED.utm_source, EXCLUDED.utm_medium, EXCLUDED.utm_campaign,
+			EXCLUDED.utm_content, EXCLUDED.utm_term, EXCLUDED.referrer) > 0
+`
+
 // syncLabel - syncs IDs to database.
 func syncLabel(ctx context.Context, tx pgx.Tx, ll *labelLine) error {
 	// assume that brigade creation make a full update.
@@ -122,18 +190,21 @@ func syncLabel(ctx context.Context, tx pgx.Tx, ll *labelLine) error {
 	sqlInsertLabel := `
 INSERT INTO 
 		%s 
-	(label, label_id, first_visit, partner_id, update_time) 
+	(label, label_id, first_visit, partner_id, update_time,
+		utm_source, utm_medium, utm_campaign, utm_content, utm_term, referrer) 
 VALUES 
-	($1, $2, $3, $4, NOW() AT TIME ZONE 'UTC')
-ON CONFLICT (label_id, partner_id, first_visit) DO NOTHING
-`
+	($1, $2, $3, $4, NOW() AT TIME ZONE 'UTC',
+		NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''))
+%s`
 
 	if _, err := tx.Exec(ctx,
 		fmt.Sprintf(
 			sqlInsertLabel,
 			(pgx.Identifier{"head", "start_labels"}).Sanitize(),
+			sqlOnLabelConflict,
 		),
 		ll.label, ll.labelID, ll.firstVisit, ll.partnerID,
+		ll.utm.Source, ll.utm.Medium, ll.utm.Campaign, ll.utm.Content, ll.utm.Term, ll.utm.Referrer,
 	); err != nil {
 		return fmt.Errorf("insert brigade_id: %w", err)
 	}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vpngen/ministry"
+)
+
+// maxRejectTextLen - the rejected line is cut in the report.
+const maxRejectTextLen = 1024
+
+var (
+	ErrTooManyRejects     = errors.New("too many rejected lines")
+	ErrInvalidRejectRatio = errors.New("invalid reject ratio")
+)
+
+// Rejected - is the record of the rejects report, one JSON per line.
+type Rejected struct {
+	Line   int64  `json:"line"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+	Text   string `json:"text"`
+}
+
+// rejecter - reports the lines which can't be parsed. The strict
+// rejecter fails on the first one, the lenient one writes them to
+// stderr and fails only if their ratio exceeds the limit. The partners
+// run synclabels over SSH, so the report never goes to a file.
+type rejecter struct {
+	lenient  bool
+	maxRatio float64
+
+	enc *json.Encoder
+
+	lines    int64
+	rejected int64
+}
+
+func newRejecter(cfg *AppConfig) *rejecter {
+	return &rejecter{
+		lenient:  cfg.Lenient,
+		maxRatio: cfg.MaxRejectRatio,
+		enc:      json.NewEncoder(os.Stderr),
+	}
+}
+
+// scan - reads the label lines of the partner, calls fn for the valid ones.
+func (r *rejecter) scan(in io.Reader, partnerID uuid.UUID, fn func(n int64, ll *labelLine) error) error {
+	fileScanner := bufio.NewScanner(in)
+
+	var n int64
+
+	for fileScanner.Scan() {
+		n++
+
+		line := strings.TrimSpace(fileScanner.Text())
+		if line == "" {
+			continue
+		}
+
+		r.lines++
+
+		ll, err := parseLine(line)
+		if err != nil {
+			if err := r.reject(n, line, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		ll.partnerID = partnerID
+
+		if err := fn(n, ll); err != nil {
+			return err
+		}
+	}
+
+	if err := fileScanner.Err(); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	return nil
+}
+
+func (r *rejecter) reject(n int64, line string, err error) error {
+	if !r.lenient {
+		return fmt.Errorf("parse line %d: %w", n, err)
+	}
+
+	r.rejected++
+
+	if len(line) > maxRejectTextLen {
+		line = line[:maxRejectTextLen]
+	}
+
+	if err := r.enc.Encode(Rejected{Line: n, Reason: rejectReason(err), Error: err.Error(), Text: line}); err != nil {
+		return fmt.Errorf("write reject: %w", err)
+	}
+
+	return nil
+}
+
+// check - fails if the ratio of the rejected lines exceeds the limit.
+func (r *rejecter) check() error {
+	if r.rejected == 0 {
+		return nil
+	}
+
+	if ratio := float64(r.rejected) / float64(r.lines); ratio > r.maxRatio {
+		return fmt.Errorf("%w: %d of %d (%.4f > %.4f)", ErrTooManyRejects, r.rejected, r.lines, ratio, r.maxRatio)
+	}
+
+	return nil
+}
+
+// summary - prints the rejected lines count.
+func (r *rejecter) summary() {
+	if r.lenient {
+		fmt.Fprintf(os.Stderr, "Rejected: %d of %d lines\n", r.rejected, r.lines)
+	}
+}
+
+// rejectReason - the short reason of the parse error.
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidFields):
+		return "invalid_fields"
+	case errors.Is(err, ErrZeroTime):
+		return "zero_time"
+	case errors.Is(err, ErrZeroUUID):
+		return "zero_uuid"
+	case errors.Is(err, ErrEmtyLabel):
+		return "empty_label"
+	case errors.Is(err, ErrLabelTooLong):
+		return "label_too_long"
+	case errors.Is(err, ministry.ErrUTMFieldTooLong):
+		return "utm_too_long"
+	case errors.Is(err, ErrInvalidJSON):
+		return "invalid_json"
+	case errors.Is(err, ErrInvalidTime):
+		return "invalid_time"
+	case errors.Is(err, ErrInvalidUUID):
+		return "invalid_uuid"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http/httputil"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/ssh"
+)
+
+// ChecksumVersion - is a version of ChecksumRequest and ChecksumResult structs.
+const ChecksumVersion = 1
+
+const checksumDayLayout = time.DateOnly
+
+var ErrNoChecksums = errors.New("stats server doesn't support checksums")
+
+// Checksum - is the checksum of the day bucket of the table: the rows count
+// and the MD5 hex of the rows "<key 1>|<key 2>|...|<update_time>" joined
+// with "\n" in the stream order. The keys are in the PostgreSQL text form,
+// update_time is formatted as YYYY-MM-DD HH24:MI:SS.US, the day is the
+// update_time day.
+type Checksum struct {
+	Section string `json:"section"`
+	Day     string `json:"day"`
+	Count   int64  `json:"count"`
+	Hash    string `json:"hash"`
+}
+
+// ChecksumSection - the rows of the section before the time are summed.
+type ChecksumSection struct {
+	Section string    `json:"section"`
+	Until   time.Time `json:"until"`
+}
+
+// ChecksumRequest - is the request of sync_ids -ch checksum.
+type ChecksumRequest struct {
+	Version  int               `json:"version"`
+	Sections []ChecksumSection `json:"sections"`
+}
+
+// ChecksumResult - is the answer of sync_ids -ch checksum.
+type ChecksumResult struct {
+	Version   int        `json:"version"`
+	Checksums []Checksum `json:"checksums"`
+}
+
+const sqlChecksums = `
+SELECT
+	to_char(update_time, 'YYYY-MM-DD'),
+	COUNT(*),
+	md5(string_agg(concat_ws('|', %s), E'\n' ORDER BY %s))
+FROM
+	%s
+WHERE
+	update_time < $1
+GROUP BY
+	1
+ORDER BY
+	1
+`
+
+func (t *table[T]) Checksums(ctx context.Context, db *pgxpool.Pool, schema string, until time.Time) ([]Checksum, error) {
+	cols := make([]string, 0, len(t.key)+1)
+
+	for _, k := range t.key {
+		cols = append(cols, k.name+"::text")
+	}
+
+	cols = append(cols, `to_char(update_time, 'YYYY-MM-DD HH24:MI:SS.US')`)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		fmt.Sprintf(sqlChecksums,
+			strings.Join(cols, ", "),
+			strings.Join(t.order(), ", "),
+			(pgx.Identifier{schema, t.name}).Sanitize(),
+		),
+		until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	sum := Checksum{Section: t.section}
+	sums := []Checksum{}
+
+	if _, err := pgx.ForEachRow(rows, []any{&sum.Day, &sum.Count, &sum.Hash}, func() error {
+		sums = append(sums, sum)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("for each row: %w", err)
+	}
+
+	return sums, nil
+}
+
+// bucketDiff - the day bucket differing at the remote.
+type bucketDiff struct {
+	sec    section
+	until  time.Time
+	day    string
+	local  Checksum
+	remote Checksum
+}
+
+// verify - compares the table checksums of the stats sinks,
+// resyncs the differing buckets if asked. Returns the number
+// of the buckets left differing.
+func verify(ctx context.Context, sinks []sink, secs []section, until time.Time, resync bool, pageSize int) (int, error) {
+	left := 0
+
+	var errs []error
+
+	for _, sk := range sinks {
+		ss, ok := sk.(*statsSink)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: Sink %s: no checksums, skip\n", LogTag, sk.Name())
+
+			continue
+		}
+
+		n, err := ss.verify(ctx, secs, until, resync, pageSize)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sk.Name(), err))
+		}
+
+		left += n
+	}
+
+	return left, errors.Join(errs...)
+}
+
+// verify - compares the sections up to the remote cursors.
+func (s *statsSink) verify(ctx context.Context, secs []section, until time.Time, resync bool, pageSize int) (int, error) {
+	if err := s.Open(ctx); err != nil {
+		return 0, fmt.Errorf("open: %w", err)
+	}
+
+	// nothing is streamed, the single pack isn't sent
+	defer s.Close(ctx, true)
+
+	if s.last.Version < checksumSinceVersion {
+		return 0, fmt.Errorf("%w: version %d", ErrNoChecksums, s.last.Version)
+	}
+
+	if s.client == nil {
+		client, err := ssh.Dial("tcp", s.addr, s.sshconf)
+		if err != nil {
+			return 0, fmt.Errorf("dial: %w", err)
+		}
+
+		s.client = client
+	}
+
+	req := ChecksumRequest{Version: ChecksumVersion}
+	local := make(map[string]Checksum)
+	bySection := make(map[string]ChecksumSection)
+
+	for _, sec := range secs {
+		if sec.Log() || sec.SinceVersion() > s.last.Version {
+			continue
+		}
+
+		// the remote has all the rows before its cursor
+		cs := ChecksumSection{Section: sec.Name(), Until: s.Start(sec).UpdateTime}
+		if cs.Until.After(until) {
+			cs.Until = until
+		}
+
+		if cs.Until.IsZero() {
+			continue
+		}
+
+		sums, err := sec.Checksums(ctx, s.db, s.schema, cs.Until)
+		if err != nil {
+			return 0, fmt.Errorf("%s checksums: %w", sec.Name(), err)
+		}
+
+		for _, sum := range sums {
+			local[sum.Section+"/"+sum.Day] = sum
+		}
+
+		req.Sections = append(req.Sections, cs)
+		bySection[sec.Name()] = cs
+	}
+
+	remote, err := s.fetchChecksums(req)
+	if err != nil {
+		return 0, fmt.Errorf("fetch checksums: %w", err)
+	}
+
+	diffs := compareChecksums(secs, bySection, local, remote)
+
+	for _, d := range diffs {
+		fmt.Fprintf(os.Stderr, "%s: Sink %s: %s %s differs: local %d rows %s, remote %d rows %s\n", LogTag,
+			s.Name(), d.sec.Name(), d.day, d.local.Count, d.local.Hash, d.remote.Count, d.remote.Hash)
+	}
+
+	if !resync {
+		return len(diffs), nil
+	}
+
+	left := 0
+
+	for _, d := range diffs {
+		if d.local.Count == 0 {
+			fmt.Fprintf(os.Stderr, "%s: Sink %s: %s %s: the rows are at the remote only, can't resync\n", LogTag,
+				s.Name(), d.sec.Name(), d.day)
+
+			left++
+
+			continue
+		}
+
+		if err := s.resync(ctx, d, pageSize); err != nil {
+			return left, fmt.Errorf("resync %s %s: %w", d.sec.Name(), d.day, err)
+		}
+
+		// Re-sent rows only upsert, the extra remote rows stay.
+		if d.remote.Count > d.local.Count {
+			fmt.Fprintf(os.Stderr, "%s: Sink %s: %s %s: the remote has %d extra rows, can't resync\n", LogTag,
+				s.Name(), d.sec.Name(), d.day, d.remote.Count-d.local.Count)
+
+			left++
+		}
+	}
+
+	return left, nil
+}
+
+// compareChecksums - the buckets differing in count or hash.
+func compareChecksums(secs []section, bySection map[string]ChecksumSection, local map[string]Checksum, remote []Checksum) []bucketDiff {
+	remoteByKey := make(map[string]Checksum, len(remote))
+
+	for _, sum := range remote {
+		remoteByKey[sum.Section+"/"+sum.Day] = sum
+	}
+
+	var diffs []bucketDiff
+
+	for _, sec := range secs {
+		cs, ok := bySection[sec.Name()]
+		if !ok {
+			continue
+		}
+
+		days := make(map[string]bool)
+
+		for key, sum := range local {
+			if sum.Section == sec.Name() {
+				days[key] = true
+			}
+		}
+
+		for key, sum := range remoteByKey {
+			if sum.Section == sec.Name() {
+				days[key] = true
+			}
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(days)) {
+			l, r := local[key], remoteByKey[key]
+			if l.Count == r.Count && l.Hash == r.Hash {
+				continue
+			}
+
+			_, day, _ := strings.Cut(key, "/")
+
+			diffs = append(diffs, bucketDiff{sec: sec, until: cs.Until, day: day, local: l, remote: r})
+		}
+	}
+
+	return diffs
+}
+
+// resync - sends the rows of the day bucket again as the resync pages.
+func (s *statsSink) resync(ctx context.Context, d bucketDiff, pageSize int) error {
+	from, err := time.Parse(checksumDayLayout, d.day)
+	if err != nil {
+		return fmt.Errorf("parse day: %w", err)
+	}
+
+	to := from.AddDate(0, 0, 1)
+	if to.After(d.until) {
+		to = d.until
+	}
+
+	// the resync pages are acked one by one
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	newPack := func() *UpdatesPack {
+		return newUpdatesPack(s.version, s.last, s.now)
+	}
+
+	emit := func(p *page) error {
+		p.Info.Resync = true
+
+		if s.dryRun {
+			return printPack(p.Pack)
+		}
+
+		body, err := s.seal(p.Pack)
+		if err != nil {
+			return err
+		}
+
+		return applyPage(s.client, p.Pack, body)
+	}
+
+	n, err := d.sec.Stream(ctx, s.db, s.schema, Cursor{UpdateTime: from}, to, pageSize, newPack, emit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: Sink %s: %s %s: %d rows resynced\n", LogTag, s.Name(), d.sec.Name(), d.day, n)
+
+	return nil
+}
+
+// fetchChecksums - runs sync_ids -ch checksum.
+func (s *statsSink) fetchChecksums(req ChecksumRequest) ([]Checksum, error) {
+	out, err := runRemote(s.client, "sync_ids -ch checksum", req)
+	if err != nil {
+		return nil, fmt.Errorf("run: %w", err)
+	}
+
+	payload, err := io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(out)))
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	var result ChecksumResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return result.Checksums, nil
+}
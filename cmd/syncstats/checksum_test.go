@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareChecksums(t *testing.T) {
+	until := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	ids := &table[IDsUpdate]{section: "ids"}
+	vip := &table[VIPUpdate]{section: "vip"}
+	actions := &table[ActionsUpdate]{section: "actions"}
+
+	bySection := map[string]ChecksumSection{
+		"ids": {Section: "ids", Until: until},
+		"vip": {Section: "vip", Until: until},
+	}
+
+	sum := func(section, day string, count int64, hash string) Checksum {
+		return Checksum{Section: section, Day: day, Count: count, Hash: hash}
+	}
+
+	local := make(map[string]Checksum)
+
+	for _, s := range []Checksum{
+		sum("ids", "2026-10-16", 3, "a"),
+		sum("ids", "2026-10-17", 2, "b"),
+		sum("ids", "2026-10-18", 1, "c"),
+		sum("vip", "2026-10-17", 4, "d"),
+		sum("actions", "2026-10-17", 5, "e"),
+	} {
+		local[s.Section+"/"+s.Day] = s
+	}
+
+	remote := []Checksum{
+		sum("ids", "2026-10-16", 3, "a"),      // same
+		sum("ids", "2026-10-17", 2, "x"),      // hash differs
+		sum("ids", "2026-10-15", 1, "z"),      // remote only
+		sum("vip", "2026-10-17", 3, "d"),      // count differs
+		sum("actions", "2026-10-17", 6, "f"),  // section not asked
+		sum("partners", "2026-10-17", 1, "g"), // section unknown
+	}
+
+	diffs := compareChecksums([]section{ids, vip, actions}, bySection, local, remote)
+
+	want := []struct {
+		section string
+		day     string
+		local   Checksum
+		remote  Checksum
+	}{
+		{"ids", "2026-10-15", Checksum{}, remote[2]},
+		{"ids", "2026-10-17", local["ids/2026-10-17"], remote[1]},
+		{"ids", "2026-10-18", local["ids/2026-10-18"], Checksum{}}, // local only
+		{"vip", "2026-10-17", local["vip/2026-10-17"], remote[3]},
+	}
+
+	if len(diffs) != len(want) {
+		t.Fatalf("got %d diffs, want %d: %+v", len(diffs), len(want), diffs)
+	}
+
+	for i, w := range want {
+		d := diffs[i]
+
+		if d.sec.Name() != w.section || d.day != w.day || !d.until.Equal(until) {
+			t.Errorf("diff %d: got %s %s until %s, want %s %s", i, d.sec.Name(), d.day, d.until, w.section, w.day)
+		}
+
+		if d.local != w.local || d.remote != w.remote {
+			t.Errorf("diff %d: got local %+v, remote %+v, want %+v, %+v", i, d.local, d.remote, w.local, w.remote)
+		}
+	}
+
+	if diffs := compareChecksums([]section{ids}, bySection, local, remote[:1]); len(diffs) != 2 {
+		t.Errorf("remote without days: got %d diffs, want 2", len(diffs))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const defaultCommitLag = time.Minute
+
+// The high-water mark: the commit-safety lag before now, but never after
+// the start of the oldest running write transaction, as update_time is
+// the transaction start time and such a transaction can commit rows
+// older than the ones already visible.
+const sqlHighWaterMark = `
+SELECT
+	LEAST(
+		NOW() AT TIME ZONE 'UTC' - $1 * INTERVAL '1 MICROSECOND',
+		COALESCE(
+			(
+				SELECT
+					MIN(xact_start) AT TIME ZONE 'UTC'
+				FROM
+					pg_stat_activity
+				WHERE
+					backend_xid IS NOT NULL
+					AND pid != pg_backend_pid()
+			),
+			'infinity'::timestamp
+		)
+	)
+`
+
+const sqlLoadCursors = `
+SELECT
+	section,
+	cursor_time,
+	cursor_key
+FROM
+	%s
+WHERE
+	destination = $1
+`
+
+const sqlStoreCursor = `
+INSERT INTO
+	%s
+		(destination, section, cursor_time, cursor_key)
+	VALUES
+		($1, $2, $3, $4)
+ON CONFLICT (destination, section) DO UPDATE
+	SET
+		cursor_time = EXCLUDED.cursor_time,
+		cursor_key = EXCLUDED.cursor_key
+`
+
+func highWaterMark(ctx context.Context, db *pgxpool.Pool, lag time.Duration) (time.Time, error) {
+	var hw time.Time
+
+	if err := db.QueryRow(ctx, sqlHighWaterMark, lag.Microseconds()).Scan(&hw); err != nil {
+		return hw, fmt.Errorf("query: %w", err)
+	}
+
+	return hw, nil
+}
+
+// loadCursors - the last acked cursors of the destination by section.
+func loadCursors(ctx context.Context, db *pgxpool.Pool, schema, destination string) (map[string]Cursor, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		fmt.Sprintf(sqlLoadCursors, (pgx.Identifier{schema, "sync_cursors"}).Sanitize()),
+		destination,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		name string
+		cur  Cursor
+	)
+
+	cursors := make(map[string]Cursor)
+
+	if _, err := pgx.ForEachRow(rows, []any{&name, &cur.UpdateTime, &cur.Key}, func() error {
+		cursors[name] = Cursor{UpdateTime: cur.UpdateTime, Key: cur.Key}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("for each row: %w", err)
+	}
+
+	return cursors, nil
+}
+
+func storeCursor(ctx context.Context, db *pgxpool.Pool, schema, destination, name string, cur Cursor) error {
+	key := cur.Key
+	if key == nil {
+		key = []string{}
+	}
+
+	if _, err := db.Exec(ctx,
+		fmt.Sprintf(sqlStoreCursor, (pgx.Identifier{schema, "sync_cursors"}).Sanitize()),
+		destination, name, cur.UpdateTime, key,
+	); err != nil {
+		return fmt.Errorf("store cursor: %w", err)
+	}
+
+	return nil
+}
+
+// startCursor - where to continue the section from. The remote cursor
+// is the most exact one. The local one is used if the remote knows only
+// the last update time and the local cursor doesn't run ahead of it
+// (the remote may have lost the data). Otherwise the section restarts
+// from the remote last update time inclusive.
+func startCursor(s section, remote UpdateTimeResult, local map[string]Cursor) Cursor {
+	if cur, ok := remote.Cursors[s.Name()]; ok {
+		return cur
+	}
+
+	since := s.Since(remote)
+
+	if cur, ok := local[s.Name()]; ok && !cur.UpdateTime.After(since) {
+		return cur
+	}
+
+	return Cursor{UpdateTime: since}
+}
+
+func sameCursor(a, b Cursor) bool {
+	return a.UpdateTime.Equal(b.UpdateTime) && slices.Equal(a.Key, b.Key)
+}
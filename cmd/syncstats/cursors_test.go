@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareCursors(t *testing.T) {
+	t0 := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Microsecond)
+
+	ids := []keyColumn{{"event_id", "bigint"}}
+	actions := []keyColumn{{"brigade_id", "uuid"}, {"event_time", "timestamp"}}
+	labels := []keyColumn{{"label", "text"}}
+
+	tests := []struct {
+		name string
+		key  []keyColumn
+		a, b Cursor
+		want int
+	}{
+		{"update time first", ids, Cursor{t0, []string{"9"}}, Cursor{t1, []string{"1"}}, -1},
+		{"same", ids, Cursor{t0, []string{"2"}}, Cursor{t0, []string{"2"}}, 0},
+		{"bigint is numeric", ids, Cursor{t0, []string{"9"}}, Cursor{t0, []string{"10"}}, -1},
+		{"no key before the rows", ids, Cursor{t0, nil}, Cursor{t0, []string{"1"}}, -1},
+		{"rows after no key", ids, Cursor{t0, []string{"1"}}, Cursor{t0, nil}, 1},
+		{"both no key", ids, Cursor{t0, nil}, Cursor{t0, nil}, 0},
+		{"partial key is no key", actions, Cursor{t0, []string{"ffffffff-0000-4000-8000-000000000000"}}, Cursor{t0, []string{"00000000-0000-4000-8000-000000000000", "2026-10-19 11:00:00"}}, -1},
+		{
+			"uuid column first", actions,
+			Cursor{t0, []string{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d", "2026-10-19 12:00:00"}},
+			Cursor{t0, []string{"c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65", "2026-10-19 11:00:00"}},
+			-1,
+		},
+		{
+			"timestamp is chronological", actions,
+			Cursor{t0, []string{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d", "2026-10-19 12:00:00.5"}},
+			Cursor{t0, []string{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d", "2026-10-19 12:00:00.123456"}},
+			1,
+		},
+		{"text is byte ordered", labels, Cursor{t0, []string{"Zeta"}}, Cursor{t0, []string{"alpha"}}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareCursors(tt.key, tt.a, tt.b); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+
+			if got := compareCursors(tt.key, tt.b, tt.a); got != -tt.want {
+				t.Fatalf("reversed: got %d, want %d", got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestStartCursor(t *testing.T) {
+	since := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	sec := &table[IDsUpdate]{
+		section: "ids",
+		key:     []keyColumn{{"brigade_id", "uuid"}},
+		from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeIDs },
+	}
+
+	remoteCur := Cursor{UpdateTime: since, Key: []string{"c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65"}}
+	behind := Cursor{UpdateTime: since.Add(-time.Hour), Key: []string{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d"}}
+	ahead := Cursor{UpdateTime: since.Add(time.Hour), Key: []string{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d"}}
+
+	tests := []struct {
+		name   string
+		remote UpdateTimeResult
+		local  map[string]Cursor
+		want   Cursor
+	}{
+		{
+			name:   "remote cursor",
+			remote: UpdateTimeResult{UpdateTimeIDs: since, Cursors: map[string]Cursor{"ids": remoteCur}},
+			local:  map[string]Cursor{"ids": ahead},
+			want:   remoteCur,
+		},
+		{
+			name:   "local cursor behind",
+			remote: UpdateTimeResult{UpdateTimeIDs: since},
+			local:  map[string]Cursor{"ids": behind},
+			want:   behind,
+		},
+		{
+			name:   "local cursor ahead",
+			remote: UpdateTimeResult{UpdateTimeIDs: since},
+			local:  map[string]Cursor{"ids": ahead},
+			want:   Cursor{UpdateTime: since},
+		},
+		{
+			name:   "no cursors",
+			remote: UpdateTimeResult{UpdateTimeIDs: since},
+			want:   Cursor{UpdateTime: since},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startCursor(sec, tt.remote, tt.local); !sameCursor(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
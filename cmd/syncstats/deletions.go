@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// deletionsSection - the tombstones of head.sync_deletions,
+// put into the pack by table.
+func deletionsSection() section {
+	return &table[DeletionsUpdate]{
+		section: "deletions",
+		name:    "sync_deletions",
+		since:   deletionsSinceVersion,
+		log:     true,
+		columns: "event_id, table_name, row_key, update_time",
+		key:     []keyColumn{{"event_id", "bigint"}},
+		from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeDeletions },
+		scan: func(rows pgx.Rows) (DeletionsUpdate, error) {
+			var u DeletionsUpdate
+
+			err := rows.Scan(&u.EventID, &u.Table, &u.Key, &u.UpdateTime)
+
+			return u, err
+		},
+		cursor: func(u DeletionsUpdate) Cursor {
+			return Cursor{UpdateTime: u.UpdateTime, Key: []string{strconv.FormatInt(u.EventID, 10)}}
+		},
+		put: func(p *UpdatesPack, u []DeletionsUpdate) {
+			if p.Deletions == nil {
+				p.Deletions = make(map[string][]Deletion)
+			}
+
+			for _, d := range u {
+				p.Deletions[d.Table] = append(p.Deletions[d.Table], Deletion{
+					EventID:    d.EventID,
+					Key:        d.Key,
+					UpdateTime: d.UpdateTime,
+				})
+			}
+		},
+	}
+}
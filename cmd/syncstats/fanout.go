@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// fanoutQueue - the pages waiting for the destination.
+	fanoutQueue = 4
+	// fanoutStallTimeout - how long the extraction waits for the destination
+	// with the full queue before the destination is left to catch up alone.
+	fanoutStallTimeout = 30 * time.Second
+)
+
+// delivery - the page of the section extracted for all the destinations.
+type delivery struct {
+	sec   int
+	chunk chunk
+}
+
+// destination - the sink fed by the extraction pass.
+type destination struct {
+	sk     sink
+	limit  int
+	starts []Cursor // by section
+
+	ch        chan delivery
+	detached  bool
+	detachSec int // the section the extraction left the destination at
+	failed    atomic.Bool
+
+	// the progress in the current section
+	sec    int
+	cur    Cursor
+	number int
+
+	result sinkResult
+}
+
+// sinkResult - the outcome of the sink sync.
+type sinkResult struct {
+	Name     string
+	Err      error
+	Detached bool
+	Pages    int
+	Rows     int
+	Duration time.Duration
+}
+
+// fanout - syncs the sinks concurrently from one extraction pass.
+// Every section is read once from the earliest cursor of the sinks,
+// every sink takes the rows after its own cursor. The sink which can't
+// take the pages in time or the extraction failed for is left out of
+// the pass and continues from its cursor with its own reads, a failed
+// sink doesn't stop the others.
+func fanout(ctx context.Context, db *pgxpool.Pool, schema string, sinks []sink, secs []section, until time.Time, pageSize int) []sinkResult {
+	dests := make([]*destination, len(sinks))
+
+	var wg sync.WaitGroup
+
+	for i, sk := range sinks {
+		d := &destination{
+			sk:     sk,
+			ch:     make(chan delivery, fanoutQueue),
+			sec:    -1,
+			result: sinkResult{Name: sk.Name()},
+		}
+
+		dests[i] = d
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			d.open(ctx, secs, pageSize)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, d := range dests {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			d.run(ctx, db, schema, secs, until)
+		}()
+	}
+
+	extract(ctx, db, schema, dests, secs, until, pageSize)
+
+	for _, d := range dests {
+		if !d.detached {
+			close(d.ch)
+		}
+	}
+
+	wg.Wait()
+
+	results := make([]sinkResult, 0, len(dests))
+
+	for _, d := range dests {
+		results = append(results, d.result)
+	}
+
+	return results
+}
+
+// extract - the extraction pass, reads every section once for the attached destinations.
+func extract(ctx context.Context, db *pgxpool.Pool, schema string, dests []*destination, secs []section, until time.Time, pageSize int) {
+	for i, s := range secs {
+		var (
+			parts []*destination
+			cur   Cursor
+		)
+
+		for _, d := range dests {
+			if d.detached || d.failed.Load() || s.SinceVersion() > d.sk.Version() {
+				continue
+			}
+
+			if len(parts) == 0 || s.Before(d.starts[i], cur) {
+				cur = d.starts[i]
+			}
+
+			parts = append(parts, d)
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "Request %s updates for %d sinks from: %s %v until: %s\n", s.Name(), len(parts),
+			cur.UpdateTime.Format(time.RFC3339Nano), cur.Key, until.Format(time.RFC3339Nano))
+
+		total := 0
+
+		for number := 1; ; number++ {
+			c, err := s.Fetch(ctx, db, schema, cur, until, pageSize)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: Fetch %s page %d: %s\n", LogTag, s.Name(), number, err)
+
+				// the destinations retry on their own
+				for _, d := range dests {
+					if !d.failed.Load() {
+						d.detach(i)
+					}
+				}
+
+				return
+			}
+
+			if c.Len() == 0 {
+				break
+			}
+
+			for _, d := range parts {
+				d.deliver(delivery{sec: i, chunk: c})
+			}
+
+			total += c.Len()
+			cur = c.Last()
+
+			if pageSize == 0 || c.Len() < pageSize {
+				break
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "%s updates: %d\n", s.Name(), total)
+	}
+}
+
+// open - opens the sink and takes its start cursors.
+func (d *destination) open(ctx context.Context, secs []section, pageSize int) {
+	if err := d.sk.Open(ctx); err != nil {
+		d.fail(fmt.Errorf("open: %w", err))
+
+		return
+	}
+
+	d.limit = d.sk.PageSize(pageSize)
+	d.starts = make([]Cursor, len(secs))
+
+	for i, s := range secs {
+		d.starts[i] = d.sk.Start(s)
+	}
+}
+
+// deliver - queues the page, the destination is left out
+// of the pass if its queue stays full.
+func (d *destination) deliver(dl delivery) {
+	if d.detached || d.failed.Load() {
+		return
+	}
+
+	select {
+	case d.ch <- dl:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(fanoutStallTimeout)
+	defer timer.Stop()
+
+	select {
+	case d.ch <- dl:
+	case <-timer.C:
+		fmt.Fprintf(os.Stderr, "%s: Sink %s: lags behind, continue on its own\n", LogTag, d.sk.Name())
+
+		d.detach(dl.sec)
+	}
+}
+
+// detach - leaves the destination out of the pass from the section.
+func (d *destination) detach(sec int) {
+	if d.detached {
+		return
+	}
+
+	d.detached = true
+	d.detachSec = sec
+
+	close(d.ch)
+}
+
+func (d *destination) fail(err error) {
+	if d.result.Err == nil {
+		d.result.Err = err
+	}
+
+	d.failed.Store(true)
+}
+
+// run - applies the pages of the pass, then catches up alone
+// if detached, and closes the sink.
+func (d *destination) run(ctx context.Context, db *pgxpool.Pool, schema string, secs []section, until time.Time) {
+	started := time.Now()
+
+	defer func() {
+		d.result.Duration = time.Since(started)
+	}()
+
+	for dl := range d.ch {
+		if d.failed.Load() {
+			continue
+		}
+
+		if err := d.apply(ctx, secs, dl); err != nil {
+			d.fail(err)
+		}
+	}
+
+	if d.result.Err != nil && d.starts == nil {
+		// never opened
+		return
+	}
+
+	// d.detached and d.detachSec are set before the channel is closed
+	if !d.failed.Load() && d.detached {
+		d.result.Detached = true
+
+		if err := d.catchUp(ctx, db, schema, secs, until); err != nil {
+			d.fail(err)
+		}
+	}
+
+	if err := d.sk.Close(ctx, d.failed.Load()); err != nil {
+		d.fail(fmt.Errorf("close: %w", err))
+	}
+}
+
+// apply - emits the rows of the page after the destination cursor.
+func (d *destination) apply(ctx context.Context, secs []section, dl delivery) error {
+	if dl.sec != d.sec {
+		d.sec = dl.sec
+		d.cur = d.starts[dl.sec]
+		d.number = 0
+	}
+
+	from := dl.chunk.After(d.cur)
+	if from == dl.chunk.Len() {
+		return nil
+	}
+
+	d.number++
+
+	p := dl.chunk.Page(from, d.sk.NewPack(), d.number, d.limit > 0)
+
+	if err := d.sk.Emit(ctx, p); err != nil {
+		return fmt.Errorf("%s page %d: %w", secs[dl.sec].Name(), d.number, err)
+	}
+
+	d.cur = p.Info.Cursor
+	d.result.Pages++
+	d.result.Rows += p.Info.Rows
+
+	return nil
+}
+
+// catchUp - streams the rest of the sections with the own reads.
+func (d *destination) catchUp(ctx context.Context, db *pgxpool.Pool, schema string, secs []section, until time.Time) error {
+	emit := func(p *page) error {
+		if err := d.sk.Emit(ctx, p); err != nil {
+			return err
+		}
+
+		d.result.Pages++
+		d.result.Rows += p.Info.Rows
+
+		return nil
+	}
+
+	for i := d.detachSec; i < len(secs); i++ {
+		s := secs[i]
+
+		if s.SinceVersion() > d.sk.Version() {
+			continue
+		}
+
+		from := d.starts[i]
+		if i == d.sec {
+			from = d.cur
+		}
+
+		if _, err := s.Stream(ctx, db, schema, from, until, d.limit, d.sk.NewPack, emit); err != nil {
+			return fmt.Errorf("sync %s: %w", s.Name(), err)
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,11 +15,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
-	sshVng "github.com/vpngen/ministry/internal/ssh"
+	"github.com/vpngen/ministry/internal/tghash"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -35,7 +33,10 @@ const (
 )
 
 // UpdateTimeResultVersion - is a version of UpdateTimeResult struct.
-const UpdateTimeResultVersion = 1
+// The remote reports the version it knows, see pagedSinceVersion
+// vipSinceVersion, signedSinceVersion, deletionsSinceVersion
+// and checksumSinceVersion.
+const UpdateTimeResultVersion = 6
 
 // UpdateTimeResult - is a struct for last update time result.
 type UpdateTimeResult struct {
@@ -47,6 +48,22 @@ type UpdateTimeResult struct {
 	UpdateTimeActionsRealms   time.Time `json:"actions_realms_update_time"`
 	UpdateTimeActions         time.Time `json:"actions_update_time"`
 	UpdateTimeStartLabels     time.Time `json:"start_labels_update_time"`
+	UpdateTimeVIP             time.Time `json:"vip_update_time"`
+	UpdateTimeVIPActions      time.Time `json:"vip_actions_update_time"`
+	UpdateTimeVIPTelegram     time.Time `json:"vip_telegram_update_time"`
+	UpdateTimeVIPMessages     time.Time `json:"vip_messages_update_time"`
+	UpdateTimeDeletions       time.Time `json:"deletions_update_time"`
+	// Cursors - the last applied cursor by section,
+	// absent at the remotes knowing only the update times.
+	Cursors map[string]Cursor `json:"cursors,omitempty"`
+}
+
+// Cursor - the position in the table stream ordered by (update_time, primary key),
+// the key is the primary key columns in the PostgreSQL text form.
+// Empty key means "from the update time inclusive".
+type Cursor struct {
+	UpdateTime time.Time `json:"update_time"`
+	Key        []string  `json:"key,omitempty"`
 }
 
 // IDsUpdate - is a struct of a table brigades_ids.
@@ -107,10 +124,149 @@ type StartLabelsUpdate struct {
 	CreatedAt  time.Time `json:"created_at,omitempty"`
 	FirstVisit time.Time `json:"first_visit"`
 	UpdateTime time.Time `json:"update_time"`
+
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	UTMContent  string `json:"utm_content,omitempty"`
+	UTMTerm     string `json:"utm_term,omitempty"`
+	Referrer    string `json:"referrer,omitempty"`
+}
+
+// VIPUpdate - is a struct of a table brigadier_vip.
+type VIPUpdate struct {
+	BrigadeID  string     `json:"brigade_id"`
+	VIPExpire  *time.Time `json:"vip_expire,omitempty"`
+	VIPUsers   int        `json:"vip_users"`
+	VIPVariant string     `json:"vip_variant,omitempty"`
+	Finalizer  bool       `json:"finalizer"`
+	UpdateTime time.Time  `json:"update_time"`
+}
+
+// VIPActionsUpdate - is a struct of a table brigadier_vip_actions.
+type VIPActionsUpdate struct {
+	EventID    int64      `json:"event_id"`
+	BrigadeID  string     `json:"brigade_id"`
+	EventName  string     `json:"event_name"`
+	EventInfo  string     `json:"event_info"`
+	EventTime  time.Time  `json:"event_time"`
+	OldExpire  *time.Time `json:"old_expire,omitempty"`
+	NewExpire  *time.Time `json:"new_expire,omitempty"`
+	OldUsers   *int       `json:"old_users,omitempty"`
+	NewUsers   *int       `json:"new_users,omitempty"`
+	UpdateTime time.Time  `json:"update_time"`
+}
+
+// VIPTelegramUpdate - is a struct of a table vip_telegram_ids,
+// the telegram ID is never sent, only its keyed hash.
+type VIPTelegramUpdate struct {
+	BrigadeID    string    `json:"brigade_id"`
+	TelegramHash string    `json:"telegram_hash"`
+	UpdateTime   time.Time `json:"update_time"`
+}
+
+// VIPMessagesUpdate - is a delivery status of a table vip_messages,
+// the mnemonics, configs and payloads are never sent.
+type VIPMessagesUpdate struct {
+	BrigadeID    string    `json:"brigade_id"`
+	MsgType      string    `json:"msg_type"`
+	Finalizer    bool      `json:"finalizer"`
+	LastTry      time.Time `json:"last_try"`
+	PushAttempts int       `json:"push_attempts"`
+	PushNext     time.Time `json:"push_next"`
+	PushFailed   bool      `json:"push_failed"`
+	UpdateTime   time.Time `json:"update_time"`
+}
+
+// DeletionsUpdate - is a struct of a table sync_deletions,
+// the tombstone of the row deleted from the table.
+type DeletionsUpdate struct {
+	EventID    int64     `json:"event_id"`
+	Table      string    `json:"table"`
+	Key        []string  `json:"key"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// Deletion - is a tombstone in the pack. The key is the primary key
+// columns in the PostgreSQL text form. The remote deletes the row
+// unless the row is updated after the deletion (re-inserted).
+type Deletion struct {
+	EventID    int64     `json:"event_id"`
+	Key        []string  `json:"key"`
+	UpdateTime time.Time `json:"update_time"`
 }
 
 // UpdatesPackVersion - is a version of IDUpdatesPack struct.
-const UpdatesPackVersion = 1
+// Version 2 packs are pages: the rows of one table ordered by
+// (update_time, primary key), described by PageInfo.
+// Version 3 packs may carry the VIP sections too,
+// the whole (not paged) version 3 pack has no PageInfo.
+// Version 4 packs may carry the deletions.
+const (
+	UpdatesPackVersion       = 4
+	vipUpdatesPackVersion    = 3
+	pagedUpdatesPackVersion  = 2
+	legacyUpdatesPackVersion = 1
+)
+
+// pagedSinceVersion - the remote UpdateTimeResult version
+// since which sync_ids acks the pages.
+const pagedSinceVersion = 2
+
+// vipSinceVersion - the remote UpdateTimeResult version
+// since which sync_ids accepts the VIP sections.
+const vipSinceVersion = 3
+
+// signedSinceVersion - the remote UpdateTimeResult version since which
+// sync_ids accepts the packs sealed into packsign.Envelope.
+const signedSinceVersion = 4
+
+// deletionsSinceVersion - the remote UpdateTimeResult version
+// since which sync_ids applies the deletions.
+const deletionsSinceVersion = 5
+
+// checksumSinceVersion - the remote UpdateTimeResult version since which
+// sync_ids answers the checksum command and applies the resync pages.
+const checksumSinceVersion = 6
+
+const defaultPageSize = 5000
+
+var (
+	ErrInvalidArgs  = errors.New("invalid args")
+	ErrPageNotAcked = errors.New("page is not acked")
+)
+
+// PageInfo - describes the page of a paged pack. The remote may advance
+// the section last update time up to UpdateTime once the page is applied,
+// but not for the resync page: it repeats the rows already sent.
+type PageInfo struct {
+	Section    string    `json:"section"`
+	Number     int       `json:"number"`
+	Rows       int       `json:"rows"`
+	UpdateTime time.Time `json:"update_time"`
+	Cursor     Cursor    `json:"cursor"`
+	Resync     bool      `json:"resync,omitempty"`
+}
+
+// PageAck - is the answer of sync_ids on the applied page.
+// The remote storing the cursors returns the stored one.
+type PageAck struct {
+	Section string  `json:"section"`
+	Number  int     `json:"number"`
+	Applied int     `json:"applied"`
+	Cursor  *Cursor `json:"cursor,omitempty"`
+}
+
+type config struct {
+	dryRun   bool
+	addr     string
+	pageSize int
+	lag      time.Duration
+	sinks    []string
+	metrics  string
+	verify   bool
+	resync   bool
+}
 
 // UpdatesPack - is a update pack for brigades_ids table.
 type UpdatesPack struct {
@@ -122,8 +278,14 @@ type UpdatesPack struct {
 	IDsUpdates             []IDsUpdate             `json:"updates_ids"`
 	ActionsUpdates         []ActionsUpdate         `json:"updates_actions"`
 	StartLabelsUpdates     []StartLabelsUpdate     `json:"updates_start_labels"`
+	VIPUpdates             []VIPUpdate             `json:"updates_vip,omitempty"`
+	VIPActionsUpdates      []VIPActionsUpdate      `json:"updates_vip_actions,omitempty"`
+	VIPTelegramUpdates     []VIPTelegramUpdate     `json:"updates_vip_telegram,omitempty"`
+	VIPMessagesUpdates     []VIPMessagesUpdate     `json:"updates_vip_messages,omitempty"`
+	Deletions              map[string][]Deletion   `json:"deletions,omitempty"`
 	UpdatesFrom            UpdateTimeResult        `json:"updates_from"`
 	UpdateTime             time.Time               `json:"update_time"`
+	Page                   *PageInfo               `json:"page,omitempty"`
 }
 
 var LogTag = setLogTag()
@@ -140,21 +302,14 @@ func setLogTag() string {
 }
 
 func main() {
-	var addr string
-
-	dryRun, addr2, err := parseArgs()
+	cfg, err := parseArgs()
 	if err != nil {
 		log.Fatalf("Parse args: %s", err)
 	}
 
-	sshKeyFilename, addr1, dbURL, schema, err := readConfigs()
-	if err != nil {
-		log.Fatalf("Can't read configs: %s\n", err)
-	}
-
-	sshconf, err := sshVng.CreateSSHConfig(sshKeyFilename, sshkeyRemoteUsername, sshVng.SSHDefaultTimeOut)
-	if err != nil {
-		log.Fatalf("%s: Can't create ssh configs: %s\n", LogTag, err)
+	addr, dbURL, schema := readConfigs()
+	if addr != "" {
+		cfg.addr = addr
 	}
 
 	db, err := createDBPool(dbURL)
@@ -162,747 +317,189 @@ func main() {
 		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
 	}
 
-	switch {
-	case addr1 != "":
-		addr = addr1
-	case addr2 != "":
-		addr = addr2
-	default:
-		log.Fatalf("Stats server address is not set")
-	}
-
-	fmt.Fprintf(os.Stderr, "Fetching last updates from %s\n", addr)
-
-	lastUpdates, err := fetchLastUpdates(sshconf, addr)
-	if err != nil {
-		log.Fatalf("Can't fetch last updates: %s", err)
-	}
-
+	ctx := context.Background()
 	now := time.Now().UTC()
 
-	realms, err := syncRealms(db, schema, lastUpdates.UpdateTimeRealms)
-	if err != nil {
-		log.Fatalf("Sync realms: %s", err)
-	}
-
-	log.Println(realms)
-
-	partners, err := syncPartners(db, schema, lastUpdates.UpdateTimePartners)
-	if err != nil {
-		log.Fatalf("Sync partners: %s", err)
-	}
-
-	log.Println(partners)
-
-	ids, err := syncIDs(db, schema, lastUpdates.UpdateTimeIDs)
+	sinks, err := newSinks(&cfg, db, schema, now)
 	if err != nil {
-		log.Fatalf("Sync IDs: %s", err)
+		log.Fatalf("%s: Can't create sinks: %s\n", LogTag, err)
 	}
 
-	log.Println(ids)
-
-	actions, err := syncActions(db, schema, lastUpdates.UpdateTimeActions)
-	if err != nil {
-		log.Fatalf("Sync actions: %s", err)
-	}
-
-	log.Println(actions)
-
-	realmsActions, err := syncRealmsActions(db, schema, lastUpdates.UpdateTimeActionsRealms)
+	telegramKey, err := tghash.ReadKey()
 	if err != nil {
-		log.Fatalf("Sync realms actions: %s", err)
+		log.Fatalf("%s: Can't read telegram hash key: %s\n", LogTag, err)
 	}
 
-	log.Println(realmsActions)
-
-	partnersActions, err := syncPartnersActions(db, schema, lastUpdates.UpdateTimeActionsPartners)
-	if err != nil {
-		log.Fatalf("Sync partners actions: %s", err)
+	if telegramKey == nil {
+		fmt.Fprintf(os.Stderr, "%s: No telegram hash key, skip vip_telegram\n", LogTag)
 	}
 
-	log.Println(partnersActions)
+	secs := sections(telegramKey)
 
-	startLabels, err := syncStartLabels(db, schema, lastUpdates.UpdateTimeStartLabels)
+	until, err := highWaterMark(ctx, db, cfg.lag)
 	if err != nil {
-		log.Fatalf("Sync start labels: %s", err)
-	}
-
-	pack := &UpdatesPack{
-		Version:                UpdatesPackVersion,
-		RealmsUpdates:          realms,
-		RealmsActionsUpdates:   realmsActions,
-		PartnersUpdates:        partners,
-		PartnersActionsUpdates: partnersActions,
-		IDsUpdates:             ids,
-		ActionsUpdates:         actions,
-		StartLabelsUpdates:     startLabels,
-		UpdatesFrom:            lastUpdates,
-		UpdateTime:             now,
+		log.Fatalf("High-water mark: %s", err)
 	}
 
-	if dryRun {
-		buf, err := json.MarshalIndent(pack, "", "  ")
+	if cfg.verify {
+		left, err := verify(ctx, sinks, secs, until, cfg.resync, cfg.pageSize)
 		if err != nil {
-			log.Fatalf("Marshal updates pack: %s", err)
+			log.Fatalf("%s: Verify: %s\n", LogTag, err)
 		}
 
-		fmt.Println(string(buf))
+		if left > 0 {
+			log.Fatalf("%s: %d buckets differ\n", LogTag, left)
+		}
 
 		return
 	}
 
-	if err := applyUpdates(sshconf, addr, pack); err != nil {
-		log.Fatalf("Apply updates: %s", err)
-	}
-}
+	results := fanout(ctx, db, schema, sinks, secs, until, cfg.pageSize)
 
-func applyUpdates(sshConfig *ssh.ClientConfig, addr string, updates *UpdatesPack) error {
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", addr), sshConfig)
-	if err != nil {
-		return fmt.Errorf("dial: %w", err)
-	}
-
-	defer client.Close()
+	failed := printSummary(results)
 
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("new session: %w", err)
-	}
-
-	defer session.Close()
-
-	var b, e bytes.Buffer
-
-	session.Stdout = &b
-	session.Stderr = &e
-
-	defer func() {
-		switch errstr := e.String(); errstr {
-		case "":
-			fmt.Fprintf(os.Stderr, "%s: SSH Session StdErr: empty\n", LogTag)
-		default:
-			fmt.Fprintf(os.Stderr, "%s: SSH Session StdErr:\n", LogTag)
-			for _, line := range strings.Split(errstr, "\n") {
-				fmt.Fprintf(os.Stderr, "%s: | %s\n", LogTag, line)
-			}
+	if cfg.metrics != "" {
+		if err := writeMetrics(cfg.metrics, results, now); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Write metrics: %s\n", LogTag, err)
 		}
-	}()
-
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("stdin pipe: %w", err)
-	}
-
-	if err := session.Start("sync_ids -ch sync"); err != nil {
-		return fmt.Errorf("start: %w", err)
 	}
 
-	if err := json.NewEncoder(httputil.NewChunkedWriter(stdin)).Encode(updates); err != nil {
-		return fmt.Errorf("encode: %w", err)
+	if failed > 0 {
+		log.Fatalf("%s: %d of %d sinks failed", LogTag, failed, len(sinks))
 	}
+}
 
-	if err := session.Wait(); err != nil {
-		return fmt.Errorf("wait: %w", err)
+func newUpdatesPack(version int, lastUpdates UpdateTimeResult, now time.Time) *UpdatesPack {
+	return &UpdatesPack{
+		Version:                version,
+		RealmsUpdates:          []RealmsUpdate{},
+		RealmsActionsUpdates:   []ActionsRealmsUpdate{},
+		PartnersUpdates:        []PartnersUpdate{},
+		PartnersActionsUpdates: []ActionsPartnersUpdate{},
+		IDsUpdates:             []IDsUpdate{},
+		ActionsUpdates:         []ActionsUpdate{},
+		StartLabelsUpdates:     []StartLabelsUpdate{},
+		UpdatesFrom:            lastUpdates,
+		UpdateTime:             now,
 	}
-
-	return nil
 }
 
-func syncPartnersActions(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]ActionsPartnersUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst partners actions updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
-
-	updates, err := queryPartnersActionsUpdates(dbPool, schema, lastUpdate)
+func printPack(pack *UpdatesPack) error {
+	buf, err := json.MarshalIndent(pack, "", "  ")
 	if err != nil {
-		return nil, fmt.Errorf("query partners actions updates: %w", err)
+		return fmt.Errorf("marshal updates pack: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Actions partners updates: %d\n", len(updates))
+	fmt.Println(string(buf))
 
-	return updates, nil
+	return nil
 }
 
-func queryPartnersActionsUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]ActionsPartnersUpdate, error) {
-	ctx := context.Background()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetActions := `
-	SELECT 
-		brigade_id, partner_id, event_name, event_info, event_time, update_time 
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetActions,
-			(pgx.Identifier{schema, "brigadier_partners_actions"}).Sanitize(),
-		),
-		lastUpdate,
-	)
+// applyPage - sends the page (as is or sealed into body)
+// and checks the remote has applied it all.
+func applyPage(client *ssh.Client, pack *UpdatesPack, body any) error {
+	out, err := applyUpdates(client, body)
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates    = []ActionsPartnersUpdate{}
-		brigadeID  string
-		partnerID  string
-		eventName  string
-		eventInfo  string
-		eventTime  time.Time
-		updateTime time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&brigadeID,
-			&partnerID,
-			&eventName,
-			&eventInfo,
-			&eventTime,
-			&updateTime,
-		},
-		func() error {
-			updates = append(
-				updates,
-				ActionsPartnersUpdate{
-					BrigadeID:  brigadeID,
-					PartnerID:  partnerID,
-					EventName:  eventName,
-					EventInfo:  eventInfo,
-					EventTime:  eventTime,
-					UpdateTime: updateTime,
-				},
-			)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
+		return fmt.Errorf("apply: %w", err)
 	}
 
-	return updates, nil
-}
-
-func syncRealmsActions(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]ActionsRealmsUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst realms actions updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
-
-	updates, err := queryRealmsActionsUpdates(dbPool, schema, lastUpdate)
+	payload, err := io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(out)))
 	if err != nil {
-		return nil, fmt.Errorf("query realms actions updates: %w", err)
+		return fmt.Errorf("%w: read: %w", ErrPageNotAcked, err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Actions realms updates: %d\n", len(updates))
-
-	return updates, nil
-}
-
-func queryRealmsActionsUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]ActionsRealmsUpdate, error) {
-	ctx := context.Background()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetActions := `
-	SELECT 
-		brigade_id, realm_id, event_name, event_info, event_time, update_time 
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetActions,
-			(pgx.Identifier{schema, "brigadier_realms_actions"}).Sanitize(),
-		),
-		lastUpdate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates    = []ActionsRealmsUpdate{}
-		brigadeID  string
-		realmID    string
-		eventName  string
-		eventInfo  string
-		eventTime  time.Time
-		updateTime time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&brigadeID,
-			&realmID,
-			&eventName,
-			&eventInfo,
-			&eventTime,
-			&updateTime,
-		},
-		func() error {
-			updates = append(
-				updates,
-				ActionsRealmsUpdate{
-					BrigadeID:  brigadeID,
-					RealmID:    realmID,
-					EventName:  eventName,
-					EventInfo:  eventInfo,
-					EventTime:  eventTime,
-					UpdateTime: updateTime,
-				},
-			)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
+	var ack PageAck
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return fmt.Errorf("%w: unmarshal: %w", ErrPageNotAcked, err)
 	}
 
-	return updates, nil
-}
-
-func syncActions(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]ActionsUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst actions updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
+	if ack.Section != pack.Page.Section || ack.Number != pack.Page.Number || ack.Applied != pack.Page.Rows {
+		return fmt.Errorf("%w: sent %s/%d %d rows, acked %s/%d %d rows", ErrPageNotAcked,
+			pack.Page.Section, pack.Page.Number, pack.Page.Rows,
+			ack.Section, ack.Number, ack.Applied,
+		)
+	}
 
-	updates, err := queryActionsUpdates(dbPool, schema, lastUpdate)
-	if err != nil {
-		return nil, fmt.Errorf("query actions updates: %w", err)
+	if ack.Cursor != nil && !pack.Page.Resync && !sameCursor(*ack.Cursor, pack.Page.Cursor) {
+		return fmt.Errorf("%w: sent cursor %s %v, acked %s %v", ErrPageNotAcked,
+			pack.Page.Cursor.UpdateTime.Format(time.RFC3339Nano), pack.Page.Cursor.Key,
+			ack.Cursor.UpdateTime.Format(time.RFC3339Nano), ack.Cursor.Key,
+		)
 	}
 
-	fmt.Fprintf(os.Stderr, "Actions updates: %d\n", len(updates))
+	fmt.Fprintf(os.Stderr, "%s: Page %s/%d acked: %d rows up to %s\n", LogTag,
+		ack.Section, ack.Number, ack.Applied, pack.Page.UpdateTime.Format(time.RFC3339Nano))
 
-	return updates, nil
+	return nil
 }
 
-func queryActionsUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]ActionsUpdate, error) {
-	ctx := context.Background()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetActions := `
-	SELECT 
-		brigade_id, event_name, event_info, event_time, update_time 
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetActions,
-			(pgx.Identifier{schema, "brigades_actions"}).Sanitize(),
-		),
-		lastUpdate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates    = []ActionsUpdate{}
-		brigadeID  string
-		eventName  string
-		eventInfo  string
-		eventTime  time.Time
-		updateTime time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&brigadeID,
-			&eventName,
-			&eventInfo,
-			&eventTime,
-			&updateTime,
-		},
-		func() error {
-			updates = append(
-				updates,
-				ActionsUpdate{
-					BrigadeID:  brigadeID,
-					EventName:  eventName,
-					EventInfo:  eventInfo,
-					EventTime:  eventTime,
-					UpdateTime: updateTime,
-				},
-			)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
-	}
-
-	return updates, nil
+// applyUpdates - runs sync_ids with the pack (or its envelope) in a new
+// session of the client, returns the remote (chunked) output.
+func applyUpdates(client *ssh.Client, updates any) ([]byte, error) {
+	return runRemote(client, "sync_ids -ch sync", updates)
 }
 
-func syncPartners(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]PartnersUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst partners updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
-
-	updates, err := queryPartnersUpdates(dbPool, schema, lastUpdate)
+// runRemote - runs the remote command in a new session of the client
+// with the chunked JSON body on stdin, returns the remote output.
+func runRemote(client *ssh.Client, cmd string, body any) ([]byte, error) {
+	session, err := client.NewSession()
 	if err != nil {
-		return nil, fmt.Errorf("query partners updates: %w", err)
+		return nil, fmt.Errorf("new session: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Realms updates: %d\n", len(updates))
-
-	return updates, nil
-}
-
-func queryPartnersUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]PartnersUpdate, error) {
-	ctx := context.Background()
+	defer session.Close()
 
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetPartners := `
-	SELECT 
-		partner_id, partner, update_time 
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetPartners,
-			(pgx.Identifier{schema, "partners"}).Sanitize(),
-		),
-		lastUpdate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates     = []PartnersUpdate{}
-		partnerID   string
-		partnerName string
-		updateTime  time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&partnerID,
-			&partnerName,
-			&updateTime,
-		},
-		func() error {
-			updates = append(
-				updates,
-				PartnersUpdate{
-					PartnerID:   partnerID,
-					PartnerName: partnerName,
-					UpdateTime:  updateTime,
-				},
-			)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
-	}
+	var b, e bytes.Buffer
 
-	return updates, nil
-}
+	session.Stdout = &b
+	session.Stderr = &e
 
-func syncRealms(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]RealmsUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst realms updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
+	defer func() {
+		switch errstr := e.String(); errstr {
+		case "":
+			fmt.Fprintf(os.Stderr, "%s: SSH Session StdErr: empty\n", LogTag)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: SSH Session StdErr:\n", LogTag)
+			for _, line := range strings.Split(errstr, "\n") {
+				fmt.Fprintf(os.Stderr, "%s: | %s\n", LogTag, line)
+			}
+		}
+	}()
 
-	updates, err := queryRealmsUpdates(dbPool, schema, lastUpdate)
+	stdin, err := session.StdinPipe()
 	if err != nil {
-		return nil, fmt.Errorf("query realms updates: %w", err)
+		return nil, fmt.Errorf("stdin pipe: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Realms updates: %d\n", len(updates))
-
-	return updates, nil
-}
-
-func queryRealmsUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]RealmsUpdate, error) {
-	ctx := context.Background()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetRealms := `
-	SELECT 
-		realm_id, realm_name, update_time 
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetRealms,
-			(pgx.Identifier{schema, "realms"}).Sanitize(),
-		),
-		lastUpdate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates    = []RealmsUpdate{}
-		realmID    string
-		realmName  string
-		updateTime time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&realmID,
-			&realmName,
-			&updateTime,
-		},
-		func() error {
-			updates = append(
-				updates,
-				RealmsUpdate{
-					RealmID:    realmID,
-					RealmName:  realmName,
-					UpdateTime: updateTime,
-				},
-			)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
+	if err := session.Start(cmd); err != nil {
+		return nil, fmt.Errorf("start: %w", err)
 	}
 
-	return updates, nil
-}
-
-func syncIDs(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]IDsUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst IDs updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
+	w := httputil.NewChunkedWriter(stdin)
 
-	updates, err := queryIDsUpdates(dbPool, schema, lastUpdate)
-	if err != nil {
-		return nil, fmt.Errorf("query IDs updates: %w", err)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "IDs updates: %d\n", len(updates))
-
-	return updates, nil
-}
-
-func queryIDsUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]IDsUpdate, error) {
-	ctx := context.Background()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetIDs := `
-	SELECT
-		brigade_id, update_time
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetIDs,
-			(pgx.Identifier{schema, "brigadiers_ids"}).Sanitize(),
-		),
-		lastUpdate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates    = []IDsUpdate{}
-		brigadeID  string
-		updateTime time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&brigadeID,
-			&updateTime,
-		},
-		func() error {
-			updates = append(
-				updates,
-				IDsUpdate{
-					BrigadeID:  brigadeID,
-					UpdateTime: updateTime,
-				},
-			)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close chunked: %w", err)
 	}
 
-	return updates, nil
-}
-
-func syncStartLabels(dbPool *pgxpool.Pool, schema string, lastUpdate time.Time) ([]StartLabelsUpdate, error) {
-	fmt.Fprintf(os.Stderr, "Requst start labels updates from: %s\n", lastUpdate.Format(time.RFC3339Nano))
-
-	updates, err := queryStartLabelsUpdates(dbPool, schema, lastUpdate)
-	if err != nil {
-		return nil, fmt.Errorf("query start labels updates: %w", err)
+	if err := stdin.Close(); err != nil {
+		return nil, fmt.Errorf("close stdin: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "start labels updates: %d\n", len(updates))
-
-	return updates, nil
-}
-
-func queryStartLabelsUpdates(db *pgxpool.Pool, schema string, lastUpdate time.Time) ([]StartLabelsUpdate, error) {
-	ctx := context.Background()
-
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("begin tx: %w", err)
-	}
-
-	defer tx.Rollback(ctx)
-
-	sqlGetStartLabels := `
-	SELECT
-		brigade_id, created_at, partner_id, label_id, label, first_visit, update_time
-	FROM 
-		%s 
-	WHERE 
-		update_time >= $1
-	`
-	rows, err := tx.Query(
-		ctx,
-		fmt.Sprintf(
-			sqlGetStartLabels,
-			(pgx.Identifier{schema, "start_labels"}).Sanitize(),
-		),
-		lastUpdate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
-	}
-
-	defer rows.Close()
-
-	var (
-		updates    = []StartLabelsUpdate{}
-		brigadeID  pgtype.UUID
-		partnerID  uuid.UUID
-		createdAt  pgtype.Timestamp
-		labelID    uuid.UUID
-		label      string
-		firstVisit time.Time
-		updateTime time.Time
-	)
-
-	_, err = pgx.ForEachRow(
-		rows,
-		[]any{
-			&brigadeID,
-			&createdAt,
-			&partnerID,
-			&labelID,
-			&label,
-			&firstVisit,
-			&updateTime,
-		},
-		func() error {
-			l := StartLabelsUpdate{
-				LabelID:    labelID.String(),
-				PartnerID:  partnerID.String(),
-				Label:      label,
-				FirstVisit: firstVisit,
-				UpdateTime: updateTime,
-			}
-
-			if brigadeID.Valid {
-				l.BrigadeID = uuid.UUID(brigadeID.Bytes).String()
-			}
-
-			if createdAt.Valid {
-				l.CreatedAt = createdAt.Time
-			}
-
-			updates = append(updates, l)
-
-			return nil
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("for each row: %w", err)
+	if err := session.Wait(); err != nil {
+		return nil, fmt.Errorf("wait: %w", err)
 	}
 
-	return updates, nil
+	return b.Bytes(), nil
 }
 
 func fetchLastUpdates(sshConfig *ssh.ClientConfig, addr string) (UpdateTimeResult, error) {
 	result := UpdateTimeResult{}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", addr), sshConfig)
+	client, err := ssh.Dial("tcp", addr, sshConfig)
 	if err != nil {
 		return result, fmt.Errorf("dial: %w", err)
 	}
@@ -946,13 +543,47 @@ func fetchLastUpdates(sshConfig *ssh.ClientConfig, addr string) (UpdateTimeResul
 	return result, nil
 }
 
-func parseArgs() (bool, string, error) {
-	addr := flag.String("a", "", "address of stats server")
+func parseArgs() (config, error) {
+	cfg := config{}
+
+	addr := flag.String("a", "", "comma separated stats servers: [ssh://][user@]host[:port][?key=<ssh key file>]")
 	dryRun := flag.Bool("n", false, "dry run")
+	pageSize := flag.Int("page", defaultPageSize, "max rows of a table per page (0 - send single pack)")
+	lag := flag.Duration("lag", defaultCommitLag, "commit-safety lag, the newer rows wait for the next run")
+	sinks := flag.String("sink", defaultSinks, "comma separated sinks: stats, stats:<server>, ndjson:<dir>, csv:<dir>, parquet:<dir>")
+	metrics := flag.String("metrics", "", "write the sink metrics to the node_exporter textfile")
+	verify := flag.Bool("verify", false, "compare the per-day table checksums with the stats servers instead of sync")
+	resync := flag.Bool("resync", false, "resync the differing buckets (implies -verify)")
 
 	flag.Parse()
 
-	return *dryRun, *addr, nil
+	if *pageSize < 0 {
+		return cfg, fmt.Errorf("%w: page: %d", ErrInvalidArgs, *pageSize)
+	}
+
+	if *lag < 0 {
+		return cfg, fmt.Errorf("%w: lag: %s", ErrInvalidArgs, *lag)
+	}
+
+	cfg.dryRun = *dryRun
+	cfg.addr = *addr
+	cfg.pageSize = *pageSize
+	cfg.lag = *lag
+	cfg.metrics = *metrics
+	cfg.verify = *verify || *resync
+	cfg.resync = *resync
+
+	for _, spec := range strings.Split(*sinks, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			cfg.sinks = append(cfg.sinks, spec)
+		}
+	}
+
+	if len(cfg.sinks) == 0 {
+		return cfg, fmt.Errorf("%w: no sinks", ErrInvalidArgs)
+	}
+
+	return cfg, nil
 }
 
 func createDBPool(dburl string) (*pgxpool.Pool, error) {
@@ -969,7 +600,7 @@ func createDBPool(dburl string) (*pgxpool.Pool, error) {
 	return pool, nil
 }
 
-func readConfigs() (string, string, string, string, error) {
+func readConfigs() (string, string, string) {
 	dbURL := os.Getenv("DB_URL")
 	if dbURL == "" {
 		dbURL = defaultDatabaseURL
@@ -980,12 +611,7 @@ func readConfigs() (string, string, string, string, error) {
 		headSchema = defaultHeadSchema
 	}
 
-	sshKeyFilename, err := sshVng.LookupForSSHKeyfile(os.Getenv("SSH_KEY"), sshkeyDefaultPath)
-	if err != nil {
-		return "", "", "", "", fmt.Errorf("lookup for ssh key: %w", err)
-	}
-
 	addr := os.Getenv("STATS_SERVER")
 
-	return sshKeyFilename, addr, dbURL, headSchema, nil
+	return addr, dbURL, headSchema
 }
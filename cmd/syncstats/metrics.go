@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// printSummary - prints the sink results, returns the number of the failed sinks.
+func printSummary(results []sinkResult) int {
+	failed := 0
+
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = "failed: " + r.Err.Error()
+
+			failed++
+		}
+
+		mode := "shared"
+		if r.Detached {
+			mode = "detached"
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: Sink %s: %s: %d pages, %d rows, %s, %s\n", LogTag,
+			r.Name, mode, r.Pages, r.Rows, r.Duration.Round(time.Millisecond), status)
+	}
+
+	return failed
+}
+
+// writeMetrics - replaces the node_exporter textfile with the sink results.
+func writeMetrics(filename string, results []sinkResult, now time.Time) error {
+	var b bytes.Buffer
+
+	gauge := func(name, help string, value func(sinkResult) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+
+		for _, r := range results {
+			fmt.Fprintf(&b, "%s{sink=%q} %g\n", name, r.Name, value(r))
+		}
+	}
+
+	gauge("vg_syncstats_sink_success", "Whether the last sync of the sink succeeded.", func(r sinkResult) float64 {
+		return boolMetric(r.Err == nil)
+	})
+	gauge("vg_syncstats_sink_detached", "Whether the sink left the shared extraction pass.", func(r sinkResult) float64 {
+		return boolMetric(r.Detached)
+	})
+	gauge("vg_syncstats_sink_pages", "Pages sent to the sink in the last sync.", func(r sinkResult) float64 {
+		return float64(r.Pages)
+	})
+	gauge("vg_syncstats_sink_rows", "Rows sent to the sink in the last sync.", func(r sinkResult) float64 {
+		return float64(r.Rows)
+	})
+	gauge("vg_syncstats_sink_duration_seconds", "Duration of the last sync of the sink.", func(r sinkResult) float64 {
+		return r.Duration.Seconds()
+	})
+
+	fmt.Fprintf(&b, "# HELP vg_syncstats_last_run_timestamp_seconds Start of the last sync.\n")
+	fmt.Fprintf(&b, "# TYPE vg_syncstats_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "vg_syncstats_last_run_timestamp_seconds %d\n", now.Unix())
+
+	tmp := filename + ".tmp"
+
+	if err := os.WriteFile(tmp, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
+
+func boolMetric(v bool) float64 {
+	if v {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Sink kinds of the -sink spec list, e.g. "stats,ndjson:/var/lib/vgstats/export".
+// The stats sink is every stats server of -a (STATS_SERVER) or the one
+// given, e.g. "stats:ssh://vgstats2@10.0.0.2?key=/etc/vgdept/id_stats2".
+const (
+	sinkStats   = "stats"
+	sinkNDJSON  = "ndjson"
+	sinkCSV     = "csv"
+	sinkParquet = "parquet"
+)
+
+const defaultSinks = sinkStats
+
+var (
+	ErrUnknownSink   = errors.New("unknown sink")
+	ErrDuplicateSink = errors.New("duplicate sink")
+)
+
+// sink - the destination of the table updates. Every sink keeps
+// its own checkpoints and continues from them independently.
+type sink interface {
+	Name() string
+	// Open - prepares the sink and loads its checkpoints.
+	Open(ctx context.Context) error
+	// Version - the UpdateTimeResult version the sink knows,
+	// the newer sections are skipped.
+	Version() int
+	// Start - the cursor to continue the section from.
+	Start(s section) Cursor
+	// PageSize - the page size the sink accepts, 0 means the whole section at once.
+	PageSize(requested int) int
+	// NewPack - the pack for the next page.
+	NewPack() *UpdatesPack
+	// Emit - writes the page and advances the section checkpoint.
+	Emit(ctx context.Context, p *page) error
+	// Close - finishes the sink, failed tells the streaming has failed
+	// and nothing else may be written.
+	Close(ctx context.Context, failed bool) error
+}
+
+// newSinks - creates the sinks from the spec list.
+func newSinks(cfg *config, db *pgxpool.Pool, schema string, now time.Time) ([]sink, error) {
+	sinks := make([]sink, 0, len(cfg.sinks))
+
+	for _, spec := range cfg.sinks {
+		kind, arg, _ := strings.Cut(spec, ":")
+
+		switch kind {
+		case sinkStats:
+			addrs := splitList(cfg.addr)
+			if arg != "" {
+				addrs = []string{arg}
+			}
+
+			if len(addrs) == 0 {
+				return nil, fmt.Errorf("%s: %w", spec, ErrNoStatsServer)
+			}
+
+			for _, addr := range addrs {
+				d, err := parseDestination(addr)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", spec, err)
+				}
+
+				sk, err := newStatsSink(cfg, d, db, schema, now)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", spec, addr, err)
+				}
+
+				sinks = append(sinks, sk)
+			}
+		case sinkNDJSON, sinkCSV, sinkParquet:
+			if arg == "" {
+				return nil, fmt.Errorf("%w: %s: empty directory", ErrUnknownSink, spec)
+			}
+
+			sinks = append(sinks, newFileSink(kind, arg, now))
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSink, spec)
+		}
+	}
+
+	seen := make(map[string]bool, len(sinks))
+
+	for _, sk := range sinks {
+		if seen[sk.Name()] {
+			return nil, fmt.Errorf("%w: %s twice", ErrDuplicateSink, sk.Name())
+		}
+
+		seen[sk.Name()] = true
+	}
+
+	return sinks, nil
+}
+
+// splitList - the comma or space separated list.
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
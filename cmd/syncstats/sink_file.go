@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+const (
+	checkpointFileName = "checkpoint.json"
+	checkpointVersion  = 1
+)
+
+// fileCheckpoint - the file sink checkpoint, the cursor of the last row
+// written and synced by section.
+type fileCheckpoint struct {
+	Version    int               `json:"version"`
+	Cursors    map[string]Cursor `json:"cursors"`
+	UpdateTime time.Time         `json:"update_time"`
+}
+
+// sectionFile - the output file of the section in the current run.
+type sectionFile struct {
+	f   *os.File
+	csv *csv.Writer
+}
+
+// fileSink - writes every section into <dir>/<section>/<section>-<run time>.<format>,
+// a new file per run. The parquet file is readable only after its footer
+// is written, so parquet is written into <section>-<run time>-<page>.parquet,
+// a new file per page. The checkpoint is advanced after the file is synced,
+// so a crash between them re-exports the page into the next run file.
+type fileSink struct {
+	format string
+	dir    string
+	stamp  string
+	now    time.Time
+
+	checkpoint fileCheckpoint
+	files      map[string]*sectionFile
+}
+
+func newFileSink(format, dir string, now time.Time) *fileSink {
+	return &fileSink{
+		format: format,
+		dir:    dir,
+		stamp:  now.Format("20060102T150405Z"),
+		now:    now,
+		files:  make(map[string]*sectionFile),
+	}
+}
+
+func (s *fileSink) Name() string {
+	return s.format + ":" + s.dir
+}
+
+func (s *fileSink) Open(_ context.Context) error {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	s.checkpoint = fileCheckpoint{
+		Version: checkpointVersion,
+		Cursors: make(map[string]Cursor),
+	}
+
+	buf, err := os.ReadFile(filepath.Join(s.dir, checkpointFileName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("read checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &s.checkpoint); err != nil {
+		return fmt.Errorf("unmarshal checkpoint: %w", err)
+	}
+
+	if s.checkpoint.Cursors == nil {
+		s.checkpoint.Cursors = make(map[string]Cursor)
+	}
+
+	return nil
+}
+
+func (s *fileSink) Version() int {
+	return UpdateTimeResultVersion
+}
+
+// Start - the sections without checkpoint are exported from the beginning.
+func (s *fileSink) Start(sec section) Cursor {
+	return s.checkpoint.Cursors[sec.Name()]
+}
+
+func (s *fileSink) PageSize(requested int) int {
+	return requested
+}
+
+func (s *fileSink) NewPack() *UpdatesPack {
+	return newUpdatesPack(UpdatesPackVersion, UpdateTimeResult{}, s.now)
+}
+
+func (s *fileSink) Emit(_ context.Context, p *page) error {
+	if s.format == sinkParquet {
+		if err := s.writeParquet(p); err != nil {
+			return err
+		}
+
+		s.checkpoint.Cursors[p.Info.Section] = p.Info.Cursor
+
+		return s.saveCheckpoint()
+	}
+
+	sf, err := s.file(p.Info.Section, p.Rows[0])
+	if err != nil {
+		return err
+	}
+
+	switch s.format {
+	case sinkCSV:
+		for _, row := range p.Rows {
+			if err := sf.csv.Write(csvRecord(row)); err != nil {
+				return fmt.Errorf("write csv: %w", err)
+			}
+		}
+
+		sf.csv.Flush()
+
+		if err := sf.csv.Error(); err != nil {
+			return fmt.Errorf("flush csv: %w", err)
+		}
+	default:
+		enc := json.NewEncoder(sf.f)
+
+		for _, row := range p.Rows {
+			if err := enc.Encode(row); err != nil {
+				return fmt.Errorf("write ndjson: %w", err)
+			}
+		}
+	}
+
+	if err := sf.f.Sync(); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+
+	s.checkpoint.Cursors[p.Info.Section] = p.Info.Cursor
+
+	return s.saveCheckpoint()
+}
+
+func (s *fileSink) Close(_ context.Context, _ bool) error {
+	var errs []error
+
+	for name, sf := range s.files {
+		if err := sf.f.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// file - opens the section file of the run, the CSV file starts with the header.
+func (s *fileSink) file(name string, sample any) (*sectionFile, error) {
+	if sf, ok := s.files[name]; ok {
+		return sf, nil
+	}
+
+	dir := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	fn := filepath.Join(dir, fmt.Sprintf("%s-%s.%s", name, s.stamp, s.format))
+
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+
+	sf := &sectionFile{f: f}
+
+	if s.format == sinkCSV {
+		sf.csv = csv.NewWriter(f)
+
+		if err := sf.csv.Write(csvHeader(sample)); err != nil {
+			f.Close()
+
+			return nil, fmt.Errorf("write csv header: %w", err)
+		}
+	}
+
+	s.files[name] = sf
+
+	return sf, nil
+}
+
+// writeParquet - writes the page into its own parquet file, one row group.
+func (s *fileSink) writeParquet(p *page) error {
+	dir := filepath.Join(s.dir, p.Info.Section)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	t := parquetRowType(reflect.TypeOf(p.Rows[0]))
+	schema := parquet.SchemaOf(reflect.Zero(t).Interface())
+
+	fn := filepath.Join(dir, fmt.Sprintf("%s-%s-%06d.%s", p.Info.Section, s.stamp, p.Info.Number, sinkParquet))
+
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	defer f.Close()
+
+	w := parquet.NewWriter(f, schema, parquet.Compression(&zstd.Codec{}))
+
+	for _, row := range p.Rows {
+		if err := w.Write(reflect.ValueOf(row).Convert(t).Interface()); err != nil {
+			return fmt.Errorf("write parquet: %w", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close parquet: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
+
+// saveCheckpoint - replaces the checkpoint file atomically.
+func (s *fileSink) saveCheckpoint() error {
+	s.checkpoint.UpdateTime = time.Now().UTC()
+
+	buf, err := json.MarshalIndent(s.checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+
+	fn := filepath.Join(s.dir, checkpointFileName)
+	tmp := fn + ".tmp"
+
+	if err := os.WriteFile(tmp, buf, 0o640); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmp, fn); err != nil {
+		return fmt.Errorf("rename checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+var (
+	timeType    = reflect.TypeFor[time.Time]()
+	stringsType = reflect.TypeFor[[]string]()
+)
+
+// parquetRowType - the row struct with the parquet columns named as the
+// json fields. The struct differs only in tags, so the row converts to it,
+// reflect and parquet cache the struct type and its schema.
+// The times are UTC microseconds as in PostgreSQL (parquet takes no unit
+// for the nullable ones, they are nanoseconds), nil is null, the keys are lists.
+func parquetRowType(t reflect.Type) reflect.Type {
+	fields := make([]reflect.StructField, 0, t.NumField())
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+
+		switch f.Type {
+		case timeType:
+			name += ",timestamp(microsecond:utc)"
+		case stringsType:
+			name += ",list"
+		}
+
+		fields = append(fields, reflect.StructField{
+			Name: f.Name,
+			Type: f.Type,
+			Tag:  reflect.StructTag(`parquet:"` + name + `"`),
+		})
+	}
+
+	return reflect.StructOf(fields)
+}
+
+// csvHeader - the json names of the row struct fields.
+func csvHeader(row any) []string {
+	t := reflect.TypeOf(row)
+	header := make([]string, 0, t.NumField())
+
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = t.Field(i).Name
+		}
+
+		header = append(header, name)
+	}
+
+	return header
+}
+
+// csvRecord - the row struct fields in the header order,
+// the times are RFC3339, the zero time and nil are empty,
+// the keys are comma separated.
+func csvRecord(row any) []string {
+	v := reflect.ValueOf(row)
+	record := make([]string, 0, v.NumField())
+
+	for i := range v.NumField() {
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				record = append(record, "")
+
+				continue
+			}
+
+			fv = fv.Elem()
+		}
+
+		switch f := fv.Interface().(type) {
+		case time.Time:
+			if f.IsZero() {
+				record = append(record, "")
+
+				continue
+			}
+
+			record = append(record, f.Format(time.RFC3339Nano))
+		case []string:
+			record = append(record, strings.Join(f, ","))
+		default:
+			record = append(record, fmt.Sprint(f))
+		}
+	}
+
+	return record
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetAction - the columns of the actions parquet file read back.
+type parquetAction struct {
+	BrigadeID  string    `parquet:"brigade_id"`
+	EventName  string    `parquet:"event_name"`
+	EventInfo  string    `parquet:"event_info"`
+	EventTime  time.Time `parquet:"event_time"`
+	UpdateTime time.Time `parquet:"update_time"`
+}
+
+func TestFileSinkParquet(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	rows := []any{
+		ActionsUpdate{
+			BrigadeID:  "0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d",
+			EventName:  "create_brigade",
+			EventInfo:  "test",
+			EventTime:  now,
+			UpdateTime: now,
+		},
+		ActionsUpdate{
+			BrigadeID:  "c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65",
+			EventName:  "delete_brigade",
+			EventTime:  now.Add(time.Second),
+			UpdateTime: now.Add(time.Second),
+		},
+	}
+
+	cursor := Cursor{
+		UpdateTime: now.Add(time.Second),
+		Key:        []string{"c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65", "2026-10-19 12:00:01"},
+	}
+
+	s := newFileSink(sinkParquet, dir, now)
+	if err := s.Open(context.Background()); err != nil {
+		t.Fatalf("open: %s", err)
+	}
+
+	p := &page{
+		Info: PageInfo{Section: "actions", Number: 1, Rows: len(rows), Cursor: cursor},
+		Rows: rows,
+	}
+
+	if err := s.Emit(context.Background(), p); err != nil {
+		t.Fatalf("emit: %s", err)
+	}
+
+	if err := s.Close(context.Background(), false); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	fn := filepath.Join(dir, "actions", "actions-20261019T120000Z-000001.parquet")
+
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatalf("open parquet: %s", err)
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+
+	got, err := parquet.Read[parquetAction](f, fi.Size())
+	if err != nil {
+		t.Fatalf("read parquet: %s", err)
+	}
+
+	if len(got) != len(rows) {
+		t.Fatalf("got %d rows, want %d", len(got), len(rows))
+	}
+
+	for i, row := range rows {
+		w := row.(ActionsUpdate)
+		g := got[i]
+
+		if g.BrigadeID != w.BrigadeID || g.EventName != w.EventName || g.EventInfo != w.EventInfo ||
+			!g.EventTime.Equal(w.EventTime) || !g.UpdateTime.Equal(w.UpdateTime) {
+			t.Errorf("row %d: got %+v, want %+v", i, g, w)
+		}
+	}
+
+	reopened := newFileSink(sinkParquet, dir, now)
+	if err := reopened.Open(context.Background()); err != nil {
+		t.Fatalf("reopen: %s", err)
+	}
+
+	start := reopened.checkpoint.Cursors["actions"]
+	if !start.UpdateTime.Equal(cursor.UpdateTime) || !slices.Equal(start.Key, cursor.Key) {
+		t.Errorf("checkpoint: got %v, want %v", start, cursor)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/packsign"
+	sshVng "github.com/vpngen/ministry/internal/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// signKeyDefaultPath - the ed25519 key the packs are signed with.
+const signKeyDefaultPath = sshkeyDefaultPath + "/pack-sign.key"
+
+const sshDefaultPort = "22"
+
+var (
+	ErrNoStatsServer      = errors.New("stats server address is not set")
+	ErrInvalidDestination = errors.New("invalid stats server")
+	ErrNoSignKey          = errors.New("no sign key")
+)
+
+// statsDestination - the stats server: [ssh://][user@]host[:port][?key=<ssh key file>],
+// the default user is vgstats, the default key is the one of SSH_KEY or the lookup.
+type statsDestination struct {
+	host string
+	port string
+	user string
+	key  string
+}
+
+// parseDestination - parses the stats server spec.
+func parseDestination(spec string) (statsDestination, error) {
+	d := statsDestination{port: sshDefaultPort, user: sshkeyRemoteUsername}
+
+	if !strings.Contains(spec, "://") {
+		spec = "ssh://" + spec
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return d, fmt.Errorf("%w: %s: %w", ErrInvalidDestination, spec, err)
+	}
+
+	if u.Scheme != "ssh" || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return d, fmt.Errorf("%w: %s", ErrInvalidDestination, spec)
+	}
+
+	d.host = u.Hostname()
+
+	if port := u.Port(); port != "" {
+		d.port = port
+	}
+
+	if name := u.User.Username(); name != "" {
+		d.user = name
+	}
+
+	d.key = u.Query().Get("key")
+
+	return d, nil
+}
+
+// name - the destination of the stored cursors, the host only for the default port.
+func (d statsDestination) name() string {
+	if d.port == sshDefaultPort {
+		return d.host
+	}
+
+	return net.JoinHostPort(d.host, d.port)
+}
+
+// statsSink - the remote sync_ids of the stats server over SSH.
+// The checkpoints are the remote last updates (or cursors)
+// and the local copy of the acked cursors in head.sync_cursors.
+type statsSink struct {
+	addr    string
+	dest    string
+	sshconf *ssh.ClientConfig
+	db      *pgxpool.Pool
+	schema  string
+	dryRun  bool
+	now     time.Time
+	signer  *packsign.Signer
+
+	client  *ssh.Client
+	last    UpdateTimeResult
+	local   map[string]Cursor
+	version int // the negotiated pack version
+
+	// the whole pack for the remote which doesn't ack pages
+	single *UpdatesPack
+	sent   map[string]Cursor
+}
+
+func newStatsSink(cfg *config, d statsDestination, db *pgxpool.Pool, schema string, now time.Time) (*statsSink, error) {
+	keyFilename := d.key
+	if keyFilename == "" {
+		fn, err := sshVng.LookupForSSHKeyfile(os.Getenv("SSH_KEY"), sshkeyDefaultPath)
+		if err != nil {
+			return nil, fmt.Errorf("lookup for ssh key: %w", err)
+		}
+
+		keyFilename = fn
+	}
+
+	sshconf, err := sshVng.CreateSSHConfig(keyFilename, d.user, sshVng.SSHDefaultTimeOut)
+	if err != nil {
+		return nil, fmt.Errorf("create ssh configs: %w", err)
+	}
+
+	signer, err := loadSigner()
+	if err != nil {
+		return nil, fmt.Errorf("load sign key: %w", err)
+	}
+
+	return &statsSink{
+		addr:    net.JoinHostPort(d.host, d.port),
+		dest:    d.name(),
+		sshconf: sshconf,
+		signer:  signer,
+		db:      db,
+		schema:  schema,
+		dryRun:  cfg.dryRun,
+		now:     now,
+		sent:    make(map[string]Cursor),
+	}, nil
+}
+
+func (s *statsSink) Name() string {
+	return sinkStats + ":" + s.dest
+}
+
+func (s *statsSink) Open(ctx context.Context) error {
+	fmt.Fprintf(os.Stderr, "Fetching last updates from %s\n", s.addr)
+
+	last, err := fetchLastUpdates(s.sshconf, s.addr)
+	if err != nil {
+		return fmt.Errorf("fetch last updates: %w", err)
+	}
+
+	s.last = last
+	s.version = packVersion(last.Version)
+
+	// The remote accepting the signed packs is never sent the unsigned ones.
+	if s.signer == nil && last.Version >= signedSinceVersion {
+		return fmt.Errorf("%w: %s accepts signed packs, set PACK_SIGN_KEY or put the key into %s", ErrNoSignKey, s.addr, signKeyDefaultPath)
+	}
+
+	local, err := loadCursors(ctx, s.db, s.schema, s.dest)
+	if err != nil {
+		return fmt.Errorf("load cursors: %w", err)
+	}
+
+	s.local = local
+
+	if s.dryRun {
+		return nil
+	}
+
+	client, err := ssh.Dial("tcp", s.addr, s.sshconf)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+
+	s.client = client
+
+	return nil
+}
+
+func (s *statsSink) Version() int {
+	return s.last.Version
+}
+
+func (s *statsSink) Start(sec section) Cursor {
+	return startCursor(sec, s.last, s.local)
+}
+
+// PageSize - the remote which doesn't ack pages gets the whole pack at once.
+func (s *statsSink) PageSize(requested int) int {
+	if s.last.Version < pagedSinceVersion && requested > 0 {
+		fmt.Fprintf(os.Stderr, "Stats server version %d doesn't support pages, send single pack\n", s.last.Version)
+
+		requested = 0
+	}
+
+	if requested == 0 {
+		version := legacyUpdatesPackVersion
+		if s.last.Version >= vipSinceVersion {
+			version = packVersion(s.last.Version)
+		}
+
+		s.single = newUpdatesPack(version, s.last, s.now)
+	}
+
+	return requested
+}
+
+func (s *statsSink) NewPack() *UpdatesPack {
+	if s.single != nil {
+		return s.single
+	}
+
+	return newUpdatesPack(s.version, s.last, s.now)
+}
+
+// packVersion - the newest pack version the remote of the version accepts.
+func packVersion(remote int) int {
+	switch {
+	case remote >= deletionsSinceVersion:
+		return UpdatesPackVersion
+	case remote >= vipSinceVersion:
+		return vipUpdatesPackVersion
+	case remote >= pagedSinceVersion:
+		return pagedUpdatesPackVersion
+	default:
+		return legacyUpdatesPackVersion
+	}
+}
+
+func (s *statsSink) Emit(ctx context.Context, p *page) error {
+	switch {
+	case s.single != nil:
+		s.sent[p.Info.Section] = p.Info.Cursor
+
+		return nil
+	case s.dryRun:
+		return printPack(p.Pack)
+	}
+
+	body, err := s.seal(p.Pack)
+	if err != nil {
+		return err
+	}
+
+	if err := applyPage(s.client, p.Pack, body); err != nil {
+		return err
+	}
+
+	return storeCursor(ctx, s.db, s.schema, s.dest, p.Info.Section, p.Info.Cursor)
+}
+
+func (s *statsSink) Close(ctx context.Context, failed bool) error {
+	if s.client != nil {
+		defer s.client.Close()
+	}
+
+	if failed || s.single == nil {
+		return nil
+	}
+
+	if s.dryRun {
+		return printPack(s.single)
+	}
+
+	body, err := s.seal(s.single)
+	if err != nil {
+		return err
+	}
+
+	if _, err := applyUpdates(s.client, body); err != nil {
+		return fmt.Errorf("apply updates: %w", err)
+	}
+
+	for name, cur := range s.sent {
+		if err := storeCursor(ctx, s.db, s.schema, s.dest, name, cur); err != nil {
+			return fmt.Errorf("store %s cursor: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// seal - the pack sealed into the signed envelope for the remote
+// accepting them, the pack itself for the older ones.
+func (s *statsSink) seal(pack *UpdatesPack) (any, error) {
+	if s.last.Version < signedSinceVersion {
+		return pack, nil
+	}
+
+	if s.signer == nil {
+		return nil, ErrNoSignKey
+	}
+
+	buf, err := json.Marshal(pack)
+	if err != nil {
+		return nil, fmt.Errorf("marshal updates pack: %w", err)
+	}
+
+	env, err := s.signer.Seal(buf)
+	if err != nil {
+		return nil, fmt.Errorf("seal: %w", err)
+	}
+
+	return env, nil
+}
+
+// loadSigner - reads the sign key from PACK_SIGN_KEY or the default path,
+// nil signer if there is no key file, the remotes accepting the signed
+// packs are refused then.
+func loadSigner() (*packsign.Signer, error) {
+	fn := os.Getenv("PACK_SIGN_KEY")
+	if fn == "" {
+		fn = signKeyDefaultPath
+	}
+
+	if _, err := os.Stat(fn); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	signer, err := packsign.LoadSigner(fn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: Sign packs with %s\n", LogTag, signer.KeyID())
+
+	return signer, nil
+}
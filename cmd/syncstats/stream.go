@@ -0,0 +1,536 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgTimestampLayout - the text form of timestamp without time zone,
+// the cursor keys are cast back with ::timestamp.
+const pgTimestampLayout = "2006-01-02 15:04:05.999999"
+
+// keyColumn - the primary key column and its type for the cursor casts.
+type keyColumn struct {
+	name string
+	typ  string
+}
+
+// page - the rows of one section fetched at once.
+// The pack is given by the sink, the rows are put into it too.
+type page struct {
+	Pack *UpdatesPack
+	Info PageInfo
+	Rows []any
+}
+
+// section - the table streamed to the sinks page by page.
+type section interface {
+	Name() string
+	// SinceVersion - the remote version since which the section is sent.
+	SinceVersion() int
+	// Since - the remote last update time of the table.
+	Since(UpdateTimeResult) time.Time
+	// Before - the cursor a is before the cursor b in the stream.
+	Before(a, b Cursor) bool
+	// Fetch - fetches one page of limit rows (0 - unlimited) of the table
+	// updates after the cursor and before the high-water mark.
+	Fetch(ctx context.Context, db *pgxpool.Pool, schema string, from Cursor, until time.Time, limit int) (chunk, error)
+	// Log - the section is the log for the remote, not a table copy.
+	Log() bool
+	// Checksums - the day buckets of the table before the time.
+	Checksums(ctx context.Context, db *pgxpool.Pool, schema string, until time.Time) ([]Checksum, error)
+	// Stream - fetches the table updates after the cursor and before
+	// the high-water mark in pages of limit rows (0 - unlimited),
+	// newPack gives the pack to put the page into, emit is called
+	// for every non-empty page.
+	Stream(ctx context.Context, db *pgxpool.Pool, schema string, from Cursor, until time.Time, limit int,
+		newPack func() *UpdatesPack, emit func(*page) error) (int, error)
+}
+
+// chunk - the fetched rows of the section, shared by the sinks.
+type chunk interface {
+	Len() int
+	// Last - the cursor of the last row.
+	Last() Cursor
+	// After - the index of the first row after the cursor.
+	After(cur Cursor) int
+	// Page - puts the rows from the index into the pack,
+	// paged tells the pack is a page of the section.
+	Page(from int, pack *UpdatesPack, number int, paged bool) *page
+}
+
+// table - the generic section of the pack.
+type table[T any] struct {
+	section string
+	name    string
+	since   int  // remote version, 0 - any
+	log     bool // the log for the remote, not a table copy
+	columns string
+	key     []keyColumn
+	from    func(UpdateTimeResult) time.Time
+	scan    func(pgx.Rows) (T, error)
+	cursor  func(T) Cursor
+	put     func(*UpdatesPack, []T)
+}
+
+func (t *table[T]) Name() string {
+	return t.section
+}
+
+func (t *table[T]) Log() bool {
+	return t.log
+}
+
+func (t *table[T]) SinceVersion() int {
+	return t.since
+}
+
+func (t *table[T]) Since(last UpdateTimeResult) time.Time {
+	return t.from(last)
+}
+
+func (t *table[T]) Before(a, b Cursor) bool {
+	return compareCursors(t.key, a, b) < 0
+}
+
+func (t *table[T]) Fetch(ctx context.Context, db *pgxpool.Pool, schema string, from Cursor, until time.Time, limit int) (chunk, error) {
+	updates, err := t.fetch(ctx, db, schema, from, until, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableChunk[T]{t: t, rows: updates}, nil
+}
+
+func (t *table[T]) Stream(ctx context.Context, db *pgxpool.Pool, schema string, from Cursor, until time.Time, limit int,
+	newPack func() *UpdatesPack, emit func(*page) error,
+) (int, error) {
+	cur := from
+
+	fmt.Fprintf(os.Stderr, "Request %s updates from: %s %v until: %s\n", t.section,
+		cur.UpdateTime.Format(time.RFC3339Nano), cur.Key, until.Format(time.RFC3339Nano))
+
+	total := 0
+
+	for number := 1; ; number++ {
+		c, err := t.Fetch(ctx, db, schema, cur, until, limit)
+		if err != nil {
+			return total, fmt.Errorf("fetch %s page %d: %w", t.section, number, err)
+		}
+
+		if c.Len() == 0 {
+			break
+		}
+
+		p := c.Page(0, newPack(), number, limit > 0)
+		cur = p.Info.Cursor
+
+		if err := emit(p); err != nil {
+			return total, fmt.Errorf("%s page %d: %w", t.section, number, err)
+		}
+
+		total += c.Len()
+
+		if limit == 0 || c.Len() < limit {
+			break
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%s updates: %d\n", t.section, total)
+
+	return total, nil
+}
+
+// tableChunk - the rows of the table page.
+type tableChunk[T any] struct {
+	t    *table[T]
+	rows []T
+}
+
+func (c *tableChunk[T]) Len() int {
+	return len(c.rows)
+}
+
+func (c *tableChunk[T]) Last() Cursor {
+	return c.t.cursor(c.rows[len(c.rows)-1])
+}
+
+func (c *tableChunk[T]) After(cur Cursor) int {
+	return sort.Search(len(c.rows), func(i int) bool {
+		return compareCursors(c.t.key, c.t.cursor(c.rows[i]), cur) > 0
+	})
+}
+
+func (c *tableChunk[T]) Page(from int, pack *UpdatesPack, number int, paged bool) *page {
+	rows := c.rows[from:]
+	last := c.t.cursor(rows[len(rows)-1])
+
+	p := &page{
+		Pack: pack,
+		Info: PageInfo{
+			Section:    c.t.section,
+			Number:     number,
+			Rows:       len(rows),
+			UpdateTime: last.UpdateTime,
+			Cursor:     last,
+		},
+		Rows: make([]any, 0, len(rows)),
+	}
+
+	c.t.put(p.Pack, rows)
+
+	for _, u := range rows {
+		p.Rows = append(p.Rows, u)
+	}
+
+	if paged {
+		p.Pack.Page = &p.Info
+	}
+
+	return p
+}
+
+func (t *table[T]) fetch(ctx context.Context, db *pgxpool.Pool, schema string, cur Cursor, until time.Time, limit int) ([]T, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	query, args := t.query(schema, cur, until, limit)
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	updates := []T{}
+
+	for rows.Next() {
+		u, err := t.scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		updates = append(updates, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return updates, nil
+}
+
+// query - the keyset page query:
+// (update_time, pk...) > ($1, $3...) after the cursor and
+// update_time >= $1 if the cursor has no key (the remote knows
+// only the last update time). The rows at and after the high-water
+// mark $2 are left for the next run as their transactions may be
+// still in progress or a later transaction may commit rows before them.
+func (t *table[T]) query(schema string, cur Cursor, until time.Time, limit int) (string, []any) {
+	order := t.order()
+
+	args := []any{cur.UpdateTime, until}
+
+	where := "update_time >= $1"
+
+	if len(cur.Key) == len(t.key) {
+		params := []string{"$1"}
+
+		for i, k := range t.key {
+			args = append(args, cur.Key[i])
+			params = append(params, fmt.Sprintf("$%d::%s", len(args), k.typ))
+		}
+
+		where = fmt.Sprintf("(%s) > (%s)", strings.Join(order, ", "), strings.Join(params, ", "))
+	}
+
+	query := fmt.Sprintf(`
+	SELECT
+		%s
+	FROM
+		%s
+	WHERE
+		%s
+		AND update_time < $2
+	ORDER BY
+		%s
+	`,
+		t.columns,
+		(pgx.Identifier{schema, t.name}).Sanitize(),
+		where,
+		strings.Join(order, ", "),
+	)
+
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf("LIMIT $%d\n", len(args))
+	}
+
+	return query, args
+}
+
+// order - the stream order columns, the text keys
+// are ordered bytewise so Go compares them the same way.
+func (t *table[T]) order() []string {
+	order := make([]string, 0, len(t.key)+1)
+	order = append(order, "update_time")
+
+	for _, k := range t.key {
+		if k.typ == "text" {
+			order = append(order, k.name+` COLLATE "C"`)
+
+			continue
+		}
+
+		order = append(order, k.name)
+	}
+
+	return order
+}
+
+func pgTime(t time.Time) string {
+	return t.Format(pgTimestampLayout)
+}
+
+// compareCursors - orders the cursors as the keyset query does.
+// The cursor without key goes before the rows of its update time.
+func compareCursors(key []keyColumn, a, b Cursor) int {
+	if c := a.UpdateTime.Compare(b.UpdateTime); c != 0 {
+		return c
+	}
+
+	ak, bk := len(a.Key) == len(key), len(b.Key) == len(key)
+
+	switch {
+	case !ak && !bk:
+		return 0
+	case !ak:
+		return -1
+	case !bk:
+		return 1
+	}
+
+	for i, k := range key {
+		if c := compareKey(k.typ, a.Key[i], b.Key[i]); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// compareKey - compares the key column values in the PostgreSQL text form:
+// the uuids are lower case hex, the text keys are ordered with COLLATE "C".
+func compareKey(typ, a, b string) int {
+	switch typ {
+	case "bigint":
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+
+		return cmp.Compare(x, y)
+	case "timestamp":
+		x, _ := time.Parse(pgTimestampLayout, a)
+		y, _ := time.Parse(pgTimestampLayout, b)
+
+		return x.Compare(y)
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// sections - the tables in the order they are sent,
+// the dictionaries go before the tables referring them.
+// The telegram IDs are hashed with the key, no key - no section.
+func sections(telegramKey []byte) []section {
+	secs := []section{
+		&table[RealmsUpdate]{
+			section: "realms",
+			name:    "realms",
+			columns: "realm_id, realm_name, update_time",
+			key:     []keyColumn{{"realm_id", "uuid"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeRealms },
+			scan: func(rows pgx.Rows) (RealmsUpdate, error) {
+				var u RealmsUpdate
+
+				err := rows.Scan(&u.RealmID, &u.RealmName, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u RealmsUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.RealmID}}
+			},
+			put: func(p *UpdatesPack, u []RealmsUpdate) { p.RealmsUpdates = append(p.RealmsUpdates, u...) },
+		},
+		&table[PartnersUpdate]{
+			section: "partners",
+			name:    "partners",
+			columns: "partner_id, partner, update_time",
+			key:     []keyColumn{{"partner_id", "uuid"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimePartners },
+			scan: func(rows pgx.Rows) (PartnersUpdate, error) {
+				var u PartnersUpdate
+
+				err := rows.Scan(&u.PartnerID, &u.PartnerName, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u PartnersUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.PartnerID}}
+			},
+			put: func(p *UpdatesPack, u []PartnersUpdate) { p.PartnersUpdates = append(p.PartnersUpdates, u...) },
+		},
+		&table[IDsUpdate]{
+			section: "ids",
+			name:    "brigadiers_ids",
+			columns: "brigade_id, update_time",
+			key:     []keyColumn{{"brigade_id", "uuid"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeIDs },
+			scan: func(rows pgx.Rows) (IDsUpdate, error) {
+				var u IDsUpdate
+
+				err := rows.Scan(&u.BrigadeID, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u IDsUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID}}
+			},
+			put: func(p *UpdatesPack, u []IDsUpdate) { p.IDsUpdates = append(p.IDsUpdates, u...) },
+		},
+		&table[ActionsUpdate]{
+			section: "actions",
+			name:    "brigades_actions",
+			columns: "brigade_id, event_name, event_info, event_time, update_time",
+			key:     []keyColumn{{"brigade_id", "uuid"}, {"event_time", "timestamp"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeActions },
+			scan: func(rows pgx.Rows) (ActionsUpdate, error) {
+				var u ActionsUpdate
+
+				err := rows.Scan(&u.BrigadeID, &u.EventName, &u.EventInfo, &u.EventTime, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u ActionsUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID, pgTime(u.EventTime)}}
+			},
+			put: func(p *UpdatesPack, u []ActionsUpdate) { p.ActionsUpdates = append(p.ActionsUpdates, u...) },
+		},
+		&table[ActionsRealmsUpdate]{
+			section: "realms_actions",
+			name:    "brigadier_realms_actions",
+			columns: "brigade_id, realm_id, event_name, event_info, event_time, update_time",
+			key:     []keyColumn{{"brigade_id", "uuid"}, {"realm_id", "uuid"}, {"event_time", "timestamp"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeActionsRealms },
+			scan: func(rows pgx.Rows) (ActionsRealmsUpdate, error) {
+				var u ActionsRealmsUpdate
+
+				err := rows.Scan(&u.BrigadeID, &u.RealmID, &u.EventName, &u.EventInfo, &u.EventTime, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u ActionsRealmsUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID, u.RealmID, pgTime(u.EventTime)}}
+			},
+			put: func(p *UpdatesPack, u []ActionsRealmsUpdate) {
+				p.RealmsActionsUpdates = append(p.RealmsActionsUpdates, u...)
+			},
+		},
+		&table[ActionsPartnersUpdate]{
+			section: "partners_actions",
+			name:    "brigadier_partners_actions",
+			columns: "brigade_id, partner_id, event_name, event_info, event_time, update_time",
+			key:     []keyColumn{{"brigade_id", "uuid"}, {"partner_id", "uuid"}, {"event_time", "timestamp"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeActionsPartners },
+			scan: func(rows pgx.Rows) (ActionsPartnersUpdate, error) {
+				var u ActionsPartnersUpdate
+
+				err := rows.Scan(&u.BrigadeID, &u.PartnerID, &u.EventName, &u.EventInfo, &u.EventTime, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u ActionsPartnersUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID, u.PartnerID, pgTime(u.EventTime)}}
+			},
+			put: func(p *UpdatesPack, u []ActionsPartnersUpdate) {
+				p.PartnersActionsUpdates = append(p.PartnersActionsUpdates, u...)
+			},
+		},
+		&table[StartLabelsUpdate]{
+			section: "start_labels",
+			name:    "start_labels",
+			columns: startLabelsColumns,
+			key:     []keyColumn{{"label_id", "uuid"}, {"partner_id", "uuid"}, {"first_visit", "timestamp"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeStartLabels },
+			scan:    scanStartLabel,
+			cursor: func(u StartLabelsUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.LabelID, u.PartnerID, pgTime(u.FirstVisit)}}
+			},
+			put: func(p *UpdatesPack, u []StartLabelsUpdate) {
+				p.StartLabelsUpdates = append(p.StartLabelsUpdates, u...)
+			},
+		},
+	}
+
+	secs = append(secs, vipSections(telegramKey)...)
+
+	// the deletions go last: the row inserted and deleted
+	// since the last run is deleted after it is sent
+	return append(secs, deletionsSection())
+}
+
+// startLabelsColumns - the UTM fields are empty for the labels without them.
+const startLabelsColumns = `brigade_id, created_at, partner_id, label_id, label, first_visit, update_time,
+	COALESCE(utm_source, ''), COALESCE(utm_medium, ''), COALESCE(utm_campaign, ''),
+	COALESCE(utm_content, ''), COALESCE(utm_term, ''), COALESCE(referrer, '')`
+
+func scanStartLabel(rows pgx.Rows) (StartLabelsUpdate, error) {
+	var (
+		brigadeID  pgtype.UUID
+		partnerID  uuid.UUID
+		createdAt  pgtype.Timestamp
+		labelID    uuid.UUID
+		label      string
+		firstVisit time.Time
+		updateTime time.Time
+	)
+
+	var l StartLabelsUpdate
+
+	if err := rows.Scan(&brigadeID, &createdAt, &partnerID, &labelID, &label, &firstVisit, &updateTime,
+		&l.UTMSource, &l.UTMMedium, &l.UTMCampaign, &l.UTMContent, &l.UTMTerm, &l.Referrer,
+	); err != nil {
+		return StartLabelsUpdate{}, err
+	}
+
+	l.LabelID = labelID.String()
+	l.PartnerID = partnerID.String()
+	l.Label = label
+	l.FirstVisit = firstVisit
+	l.UpdateTime = updateTime
+
+	if brigadeID.Valid {
+		l.BrigadeID = uuid.UUID(brigadeID.Bytes).String()
+	}
+
+	if createdAt.Valid {
+		l.CreatedAt = createdAt.Time
+	}
+
+	return l, nil
+}
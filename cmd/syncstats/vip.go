@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vpngen/ministry/internal/tghash"
+)
+
+// vipSections - the VIP tables, sent to the remotes since vipSinceVersion.
+func vipSections(telegramKey []byte) []section {
+	secs := []section{
+		&table[VIPUpdate]{
+			section: "vip",
+			name:    "brigadier_vip",
+			since:   vipSinceVersion,
+			columns: "brigade_id, vip_expire, vip_users, COALESCE(vip_variant, ''), finalizer, update_time",
+			key:     []keyColumn{{"brigade_id", "uuid"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeVIP },
+			scan: func(rows pgx.Rows) (VIPUpdate, error) {
+				var u VIPUpdate
+
+				err := rows.Scan(&u.BrigadeID, &u.VIPExpire, &u.VIPUsers, &u.VIPVariant, &u.Finalizer, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u VIPUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID}}
+			},
+			put: func(p *UpdatesPack, u []VIPUpdate) { p.VIPUpdates = append(p.VIPUpdates, u...) },
+		},
+		&table[VIPActionsUpdate]{
+			section: "vip_actions",
+			name:    "brigadier_vip_actions",
+			since:   vipSinceVersion,
+			columns: "event_id, brigade_id, event_name, event_info, event_time, old_expire, new_expire, old_users, new_users, update_time",
+			key:     []keyColumn{{"event_id", "bigint"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeVIPActions },
+			scan: func(rows pgx.Rows) (VIPActionsUpdate, error) {
+				var u VIPActionsUpdate
+
+				err := rows.Scan(&u.EventID, &u.BrigadeID, &u.EventName, &u.EventInfo, &u.EventTime,
+					&u.OldExpire, &u.NewExpire, &u.OldUsers, &u.NewUsers, &u.UpdateTime)
+
+				return u, err
+			},
+			cursor: func(u VIPActionsUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{strconv.FormatInt(u.EventID, 10)}}
+			},
+			put: func(p *UpdatesPack, u []VIPActionsUpdate) { p.VIPActionsUpdates = append(p.VIPActionsUpdates, u...) },
+		},
+	}
+
+	if telegramKey != nil {
+		secs = append(secs, &table[VIPTelegramUpdate]{
+			section: "vip_telegram",
+			name:    "vip_telegram_ids",
+			since:   vipSinceVersion,
+			columns: "brigade_id, telegram_id, COALESCE(telegram_hash, ''), update_time",
+			key:     []keyColumn{{"brigade_id", "uuid"}},
+			from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeVIPTelegram },
+			scan: func(rows pgx.Rows) (VIPTelegramUpdate, error) {
+				var (
+					u          VIPTelegramUpdate
+					telegramID *int64
+				)
+
+				if err := rows.Scan(&u.BrigadeID, &telegramID, &u.TelegramHash, &u.UpdateTime); err != nil {
+					return u, err
+				}
+
+				// the retained row keeps the hash only
+				if telegramID != nil {
+					u.TelegramHash = tghash.Hash(telegramKey, *telegramID)
+				}
+
+				return u, nil
+			},
+			cursor: func(u VIPTelegramUpdate) Cursor {
+				return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID}}
+			},
+			put: func(p *UpdatesPack, u []VIPTelegramUpdate) { p.VIPTelegramUpdates = append(p.VIPTelegramUpdates, u...) },
+		})
+	}
+
+	return append(secs, &table[VIPMessagesUpdate]{
+		section: "vip_messages",
+		name:    "vip_messages",
+		since:   vipSinceVersion,
+		columns: "brigade_id, msg_type, finalizer, last_try, push_attempts, push_next, push_error <> '', update_time",
+		key:     []keyColumn{{"brigade_id", "uuid"}, {"msg_type", "text"}},
+		from:    func(r UpdateTimeResult) time.Time { return r.UpdateTimeVIPMessages },
+		scan: func(rows pgx.Rows) (VIPMessagesUpdate, error) {
+			var u VIPMessagesUpdate
+
+			err := rows.Scan(&u.BrigadeID, &u.MsgType, &u.Finalizer, &u.LastTry,
+				&u.PushAttempts, &u.PushNext, &u.PushFailed, &u.UpdateTime)
+
+			return u, err
+		},
+		cursor: func(u VIPMessagesUpdate) Cursor {
+			return Cursor{UpdateTime: u.UpdateTime, Key: []string{u.BrigadeID, u.MsgType}}
+		},
+		put: func(p *UpdatesPack, u []VIPMessagesUpdate) { p.VIPMessagesUpdates = append(p.VIPMessagesUpdates, u...) },
+	})
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Grant history event names.
+const (
+	grantEventGrant  = "grant"
+	grantEventExtend = "extend"
+	grantEventRevoke = "revoke"
+)
+
+var (
+	ErrGrantExists    = errors.New("active grant exists, use extend")
+	ErrNoGrant        = errors.New("no grant")
+	ErrNotExtended    = errors.New("new expiration is not later than the current one")
+	ErrBrigadeDeleted = errors.New("brigade is deleted")
+	ErrExpireInPast   = errors.New("expiration is in the past")
+)
+
+// Grant - the record of head.brigadier_vip_grants.
+type Grant struct {
+	BrigadeID  uuid.UUID `json:"brigade_id"`
+	Expire     time.Time `json:"expire"`
+	Users      int       `json:"users"`
+	Plan       string    `json:"plan,omitempty"`
+	Product    string    `json:"product,omitempty"`
+	Reason     string    `json:"reason"`
+	Operator   string    `json:"operator"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+const sqlGrantFields = `
+	brigade_id,
+	vip_expire,
+	vip_users,
+	COALESCE(vip_variant, ''),
+	product,
+	reason,
+	operator,
+	update_time
+`
+
+const sqlGetGrant = `
+SELECT
+` + sqlGrantFields + `
+FROM
+	head.brigadier_vip_grants
+WHERE
+	brigade_id = $1
+FOR UPDATE
+`
+
+const sqlListGrants = `
+SELECT
+` + sqlGrantFields + `
+FROM
+	head.brigadier_vip_grants
+WHERE
+	$1 OR vip_expire > NOW() AT TIME ZONE 'UTC'
+ORDER BY
+	vip_expire
+`
+
+const sqlIsDeleted = `
+SELECT
+	EXISTS (
+		SELECT
+			1
+		FROM
+			head.deleted_brigadiers
+		WHERE
+			brigade_id = $1
+	)
+`
+
+const sqlUpsertGrant = `
+INSERT INTO
+	head.brigadier_vip_grants
+		(brigade_id, vip_expire, vip_users, vip_variant, product, reason, operator)
+	VALUES
+		($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+ON CONFLICT (brigade_id) DO UPDATE
+	SET
+		vip_expire = EXCLUDED.vip_expire,
+		vip_users = EXCLUDED.vip_users,
+		vip_variant = EXCLUDED.vip_variant,
+		product = EXCLUDED.product,
+		reason = EXCLUDED.reason,
+		operator = EXCLUDED.operator
+RETURNING
+` + sqlGrantFields
+
+const sqlExtendGrant = `
+UPDATE
+	head.brigadier_vip_grants
+SET
+	vip_expire = $2,
+	vip_users = $3,
+	reason = $4,
+	operator = $5
+WHERE
+	brigade_id = $1
+RETURNING
+` + sqlGrantFields
+
+const sqlRevokeGrant = `
+DELETE FROM
+	head.brigadier_vip_grants
+WHERE
+	brigade_id = $1
+`
+
+const sqlAddGrantEvent = `
+INSERT INTO
+	head.brigadier_vip_grants_actions
+		(brigade_id, event_name, vip_expire, vip_users, vip_variant, reason, operator)
+	VALUES
+		($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+`
+
+func scanGrant(row pgx.Row, g *Grant) error {
+	return row.Scan(&g.BrigadeID, &g.Expire, &g.Users, &g.Plan, &g.Product, &g.Reason, &g.Operator, &g.UpdateTime)
+}
+
+func addGrantEvent(ctx context.Context, tx pgx.Tx, name string, g *Grant, reason, operator string) error {
+	if _, err := tx.Exec(ctx, sqlAddGrantEvent,
+		g.BrigadeID, name, g.Expire, g.Users, g.Plan, reason, operator,
+	); err != nil {
+		return fmt.Errorf("add grant event: %w", err)
+	}
+
+	return nil
+}
+
+// grantVIP - creates the grant or replaces the expired one.
+func grantVIP(ctx context.Context, db *pgxpool.Pool, cfg *config) (*Grant, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	g, err := grantBrigade(ctx, tx, cfg, cfg.brigadeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	return g, nil
+}
+
+// grantBrigade - creates the grant of the brigade in the transaction.
+func grantBrigade(ctx context.Context, tx pgx.Tx, cfg *config, brigadeID uuid.UUID) (*Grant, error) {
+	var deleted bool
+	if err := tx.QueryRow(ctx, sqlIsDeleted, brigadeID).Scan(&deleted); err != nil {
+		return nil, fmt.Errorf("check deleted: %w", err)
+	}
+
+	if deleted {
+		return nil, fmt.Errorf("%w: %s", ErrBrigadeDeleted, brigadeID)
+	}
+
+	now := time.Now().UTC()
+
+	var old Grant
+	switch err := scanGrant(tx.QueryRow(ctx, sqlGetGrant, brigadeID), &old); {
+	case err == nil:
+		if old.Expire.After(now) {
+			return nil, fmt.Errorf("%w: %s until %s", ErrGrantExists, brigadeID, old.Expire.Format(time.RFC3339))
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("get grant: %w", err)
+	}
+
+	expire := cfg.until
+	if cfg.days > 0 {
+		expire = now.AddDate(0, 0, cfg.days)
+	}
+
+	if !expire.After(now) {
+		return nil, fmt.Errorf("%w: %s", ErrExpireInPast, expire.Format(time.RFC3339))
+	}
+
+	var g Grant
+	if err := scanGrant(tx.QueryRow(ctx, sqlUpsertGrant,
+		brigadeID, expire, cfg.users, cfg.plan, cfg.product, cfg.reason, cfg.operator,
+	), &g); err != nil {
+		return nil, fmt.Errorf("upsert grant: %w", err)
+	}
+
+	if err := addGrantEvent(ctx, tx, grantEventGrant, &g, cfg.reason, cfg.operator); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// extendVIP - moves the grant expiration forward, -days counts
+// from the current expiration or from now if already expired.
+func extendVIP(ctx context.Context, db *pgxpool.Pool, cfg *config) (*Grant, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var old Grant
+	if err := scanGrant(tx.QueryRow(ctx, sqlGetGrant, cfg.brigadeID), &old); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrNoGrant, cfg.brigadeID)
+		}
+
+		return nil, fmt.Errorf("get grant: %w", err)
+	}
+
+	expire := cfg.until
+	if cfg.days > 0 {
+		from := time.Now().UTC()
+		if old.Expire.After(from) {
+			from = old.Expire
+		}
+
+		expire = from.AddDate(0, 0, cfg.days)
+	}
+
+	if !expire.After(old.Expire) {
+		return nil, fmt.Errorf("%w: %s", ErrNotExtended, old.Expire.Format(time.RFC3339))
+	}
+
+	users := old.Users
+	if cfg.users > 0 {
+		users = cfg.users
+	}
+
+	var g Grant
+	if err := scanGrant(tx.QueryRow(ctx, sqlExtendGrant,
+		cfg.brigadeID, expire, users, cfg.reason, cfg.operator,
+	), &g); err != nil {
+		return nil, fmt.Errorf("extend grant: %w", err)
+	}
+
+	if err := addGrantEvent(ctx, tx, grantEventExtend, &g, cfg.reason, cfg.operator); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	return &g, nil
+}
+
+// revokeVIP - deletes the grant, ckvip resets the VIP record
+// on the next run as the entitlement has disappeared.
+func revokeVIP(ctx context.Context, db *pgxpool.Pool, cfg *config) (*Grant, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	var g Grant
+	if err := scanGrant(tx.QueryRow(ctx, sqlGetGrant, cfg.brigadeID), &g); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrNoGrant, cfg.brigadeID)
+		}
+
+		return nil, fmt.Errorf("get grant: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, sqlRevokeGrant, cfg.brigadeID); err != nil {
+		return nil, fmt.Errorf("revoke grant: %w", err)
+	}
+
+	if err := addGrantEvent(ctx, tx, grantEventRevoke, &g, cfg.reason, cfg.operator); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	g.Reason, g.Operator = cfg.reason, cfg.operator
+
+	return &g, nil
+}
+
+func listGrants(ctx context.Context, db *pgxpool.Pool, all bool) ([]Grant, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlListGrants, all)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	grants := make([]Grant, 0)
+
+	for rows.Next() {
+		var g Grant
+		if err := scanGrant(rows, &g); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		grants = append(grants, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return grants, nil
+}
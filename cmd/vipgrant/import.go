@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNoBrigade = errors.New("no brigade")
+
+const sqlBrigadeExists = `
+SELECT
+	EXISTS (
+		SELECT
+			1
+		FROM
+			head.brigadiers
+		WHERE
+			brigade_id = $1
+	)
+`
+
+// readBrigadeIDs - reads the legacy VIP brigades file: the brigade ID
+// (UUID or base32) is the first field of the line, the rest of the line
+// and the lines starting with # are comments. Repeated IDs are skipped.
+func readBrigadeIDs(r io.Reader) ([]uuid.UUID, error) {
+	var (
+		ids  []uuid.UUID
+		seen = make(map[uuid.UUID]bool)
+		n    int
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		n++
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		id, err := parseBrigadeID(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	return ids, nil
+}
+
+// importGrants - grants VIP to the brigades of the legacy VIP brigades
+// file in one transaction. The brigades with an active grant, deleted
+// or unknown are skipped, so the import may be run again.
+func importGrants(ctx context.Context, db *pgxpool.Pool, cfg *config) ([]Grant, error) {
+	f, err := os.Open(cfg.file)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	defer f.Close()
+
+	ids, err := readBrigadeIDs(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.file, err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	grants := make([]Grant, 0, len(ids))
+	skipped := 0
+
+	for _, id := range ids {
+		var exists bool
+		if err := tx.QueryRow(ctx, sqlBrigadeExists, id).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("check brigade: %w", err)
+		}
+
+		if !exists {
+			fmt.Fprintf(os.Stderr, "%s: Skip brigade %s: %s\n", LogTag, id, ErrNoBrigade)
+
+			skipped++
+
+			continue
+		}
+
+		g, err := grantBrigade(ctx, tx, cfg, id)
+		if err != nil {
+			if errors.Is(err, ErrGrantExists) || errors.Is(err, ErrBrigadeDeleted) {
+				fmt.Fprintf(os.Stderr, "%s: Skip brigade %s: %s\n", LogTag, id, err)
+
+				skipped++
+
+				continue
+			}
+
+			return nil, fmt.Errorf("grant %s: %w", id, err)
+		}
+
+		grants = append(grants, *g)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: Imported %d grants, skipped %d of %d brigades\n", LogTag, len(grants), skipped, len(ids))
+
+	return grants, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReadBrigadeIDs(t *testing.T) {
+	id1 := uuid.MustParse("0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d")
+	id2 := uuid.MustParse("c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65")
+
+	b32 := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(id2[:])
+
+	tests := []struct {
+		name string
+		in   string
+		want []uuid.UUID
+		err  error
+	}{
+		{
+			name: "empty",
+			in:   "",
+			want: nil,
+		},
+		{
+			name: "uuid and base32",
+			in:   id1.String() + "\n" + b32 + "\n",
+			want: []uuid.UUID{id1, id2},
+		},
+		{
+			name: "comments and blank lines",
+			in:   "# protected by hand\n\n  " + id1.String() + "  partner test brigade\n#" + id2.String() + "\n",
+			want: []uuid.UUID{id1},
+		},
+		{
+			name: "repeated",
+			in:   id1.String() + "\n" + id2.String() + "\n" + id1.String() + "\n",
+			want: []uuid.UUID{id1, id2},
+		},
+		{
+			name: "invalid",
+			in:   id1.String() + "\nnot-a-brigade\n",
+			err:  ErrInvalidUUID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readBrigadeIDs(strings.NewReader(tt.in))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
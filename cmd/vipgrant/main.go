@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vpngen/ministry/internal/pgsql"
+)
+
+const (
+	LogTag             = "vipgrant"
+	defaultDatabaseURL = "postgresql:///vgdept"
+)
+
+const (
+	commandGrant  = "grant"
+	commandExtend = "extend"
+	commandRevoke = "revoke"
+	commandList   = "list"
+	commandImport = "import"
+)
+
+var (
+	ErrInvalidArgs    = errors.New("invalid args")
+	ErrInvalidUUID    = errors.New("invalid uuid")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+type config struct {
+	command string
+	jout    bool
+	all     bool
+
+	brigadeID uuid.UUID
+	file      string
+	days      int
+	until     time.Time
+	users     int
+	plan      string
+	product   string
+	reason    string
+	operator  string
+
+	dbURL string
+}
+
+func main() {
+	cfg, err := parseArgs()
+	if err != nil {
+		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
+	}
+
+	db, err := pgsql.CreateDBPool(cfg.dbURL)
+	if err != nil {
+		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+	}
+
+	ctx := context.Background()
+
+	var grants []Grant
+
+	switch cfg.command {
+	case commandGrant:
+		g, err := grantVIP(ctx, db, &cfg)
+		if err != nil {
+			log.Fatalf("%s: Can't grant VIP: %s\n", LogTag, err)
+		}
+
+		grants = append(grants, *g)
+	case commandExtend:
+		g, err := extendVIP(ctx, db, &cfg)
+		if err != nil {
+			log.Fatalf("%s: Can't extend VIP: %s\n", LogTag, err)
+		}
+
+		grants = append(grants, *g)
+	case commandRevoke:
+		g, err := revokeVIP(ctx, db, &cfg)
+		if err != nil {
+			log.Fatalf("%s: Can't revoke VIP: %s\n", LogTag, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: Revoked grant of brigade %s\n", LogTag, g.BrigadeID)
+
+		grants = append(grants, *g)
+	case commandList:
+		grants, err = listGrants(ctx, db, cfg.all)
+		if err != nil {
+			log.Fatalf("%s: Can't list grants: %s\n", LogTag, err)
+		}
+	case commandImport:
+		grants, err = importGrants(ctx, db, &cfg)
+		if err != nil {
+			log.Fatalf("%s: Can't import grants: %s\n", LogTag, err)
+		}
+	}
+
+	switch cfg.jout {
+	case true:
+		payload, err := json.MarshalIndent(grants, "", "  ")
+		if err != nil {
+			log.Fatalf("%s: Can't marshal grants: %s\n", LogTag, err)
+		}
+
+		fmt.Fprintf(os.Stdout, "%s\n", payload)
+	default:
+		printGrants(os.Stdout, grants)
+	}
+}
+
+func printGrants(w io.Writer, grants []Grant) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "BRIGADE\tEXPIRE\tUSERS\tPLAN\tPRODUCT\tOPERATOR\tREASON\n")
+
+	for _, g := range grants {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			g.BrigadeID, g.Expire.Format(time.RFC3339), g.Users,
+			dashIfEmpty(g.Plan), dashIfEmpty(g.Product), dashIfEmpty(g.Operator), g.Reason,
+		)
+	}
+
+	tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func parseArgs() (config, error) {
+	cfg := config{}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = defaultDatabaseURL
+	}
+
+	cfg.dbURL = dbURL
+
+	jout := flag.Bool("j", false, "json output")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-j] grant|extend|revoke|list|import [flags] [brigade_id|file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	cfg.jout = *jout
+	cfg.command = flag.Arg(0)
+
+	fs := flag.NewFlagSet(cfg.command, flag.ContinueOnError)
+
+	switch cfg.command {
+	case commandGrant, commandExtend, commandImport:
+		fs.IntVar(&cfg.days, "days", 0, "Grant for N days from now (extend: from the current expiration)")
+		fs.Func("until", "Grant until the date (2006-01-02 or RFC3339, UTC)", func(s string) error {
+			t, err := parseTime(s)
+			if err != nil {
+				return err
+			}
+
+			cfg.until = t
+
+			return nil
+		})
+
+		fs.IntVar(&cfg.users, "users", 0, "VIP users count (grant: 1 if not set, extend: keep if not set)")
+		fs.StringVar(&cfg.plan, "plan", "", "VIP plan (grant and import only)")
+		fs.StringVar(&cfg.product, "product", "", "Product (grant and import only)")
+	case commandRevoke:
+	case commandList:
+		fs.BoolVar(&cfg.all, "a", false, "Include expired grants")
+	default:
+		return cfg, fmt.Errorf("%w: %q", ErrUnknownCommand, cfg.command)
+	}
+
+	if cfg.command != commandList {
+		fs.StringVar(&cfg.reason, "reason", "", "Reason (required)")
+		fs.StringVar(&cfg.operator, "o", defaultOperator(), "Operator")
+	}
+
+	if err := fs.Parse(flag.Args()[1:]); err != nil {
+		return cfg, fmt.Errorf("%s: %w", cfg.command, err)
+	}
+
+	if cfg.command == commandList {
+		if fs.NArg() != 0 {
+			return cfg, fmt.Errorf("%s: %w: unexpected args", cfg.command, ErrInvalidArgs)
+		}
+
+		return cfg, nil
+	}
+
+	switch {
+	case fs.NArg() != 1 && cfg.command == commandImport:
+		return cfg, fmt.Errorf("%s: file: %w", cfg.command, ErrInvalidArgs)
+	case fs.NArg() != 1:
+		return cfg, fmt.Errorf("%s: brigade id: %w", cfg.command, ErrInvalidArgs)
+	case cfg.command == commandImport:
+		cfg.file = fs.Arg(0)
+	default:
+		id, err := parseBrigadeID(fs.Arg(0))
+		if err != nil {
+			return cfg, fmt.Errorf("%s: brigade id: %w", cfg.command, err)
+		}
+
+		cfg.brigadeID = id
+	}
+
+	if cfg.reason == "" {
+		return cfg, fmt.Errorf("%s: %w: reason is required", cfg.command, ErrInvalidArgs)
+	}
+
+	if cfg.operator == "" {
+		return cfg, fmt.Errorf("%s: %w: operator is required", cfg.command, ErrInvalidArgs)
+	}
+
+	if cfg.command == commandRevoke {
+		return cfg, nil
+	}
+
+	switch {
+	case cfg.days > 0 && !cfg.until.IsZero():
+		return cfg, fmt.Errorf("%s: %w: -days and -until are exclusive", cfg.command, ErrInvalidArgs)
+	case cfg.days < 0:
+		return cfg, fmt.Errorf("%s: %w: days: %d", cfg.command, ErrInvalidArgs, cfg.days)
+	case cfg.days == 0 && cfg.until.IsZero():
+		return cfg, fmt.Errorf("%s: %w: -days or -until is required", cfg.command, ErrInvalidArgs)
+	}
+
+	if cfg.users < 0 {
+		return cfg, fmt.Errorf("%s: %w: users: %d", cfg.command, ErrInvalidArgs, cfg.users)
+	}
+
+	if cfg.command != commandExtend && cfg.users == 0 {
+		cfg.users = 1
+	}
+
+	if cfg.command == commandExtend && (cfg.plan != "" || cfg.product != "") {
+		return cfg, fmt.Errorf("%s: %w: plan and product are set on grant only", cfg.command, ErrInvalidArgs)
+	}
+
+	return cfg, nil
+}
+
+// defaultOperator - the real user behind sudo, if any.
+func defaultOperator() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t.UTC(), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse time: %w", err)
+	}
+
+	return t.UTC(), nil
+}
+
+// parseBrigadeID - accepts UUID or base32 (realm side) form.
+func parseBrigadeID(s string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(s); err == nil {
+		return id, nil
+	}
+
+	buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidUUID, err)
+	}
+
+	id, err := uuid.FromBytes(buf)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidUUID, err)
+	}
+
+	return id, nil
+}
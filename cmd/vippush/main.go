@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/vpngen/ministry/internal/idcodec"
+	"github.com/vpngen/ministry/internal/pgsql"
+)
+
+const (
+	LogTag             = "vippush"
+	defaultDatabaseURL = "postgresql:///vgdept"
+)
+
+const (
+	defaultBatch   = 100
+	defaultTimeout = 10 * time.Second
+
+	// leaseMargin - the lease outlives the push request.
+	leaseMargin = time.Minute
+)
+
+var ErrInvalidArgs = errors.New("invalid args")
+
+type config struct {
+	silent  bool
+	batch   int
+	timeout time.Duration
+	dbURL   string
+	codec   *idcodec.Codec
+}
+
+func main() {
+	cfg, err := parseArgs()
+	if err != nil {
+		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
+	}
+
+	db, err := pgsql.CreateDBPool(cfg.dbURL)
+	if err != nil {
+		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+	}
+
+	c := &http.Client{
+		Timeout:       cfg.timeout,
+		CheckRedirect: refuseRedirect,
+	}
+
+	stat, err := dispatch(context.Background(), db, c, cfg.codec, cfg.batch, cfg.timeout+leaseMargin)
+	if err != nil {
+		log.Fatalf("%s: Can't dispatch messages: %s\n", LogTag, err)
+	}
+
+	if !cfg.silent || stat.failed > 0 || stat.dead > 0 {
+		fmt.Fprintf(os.Stderr, "%s: Delivered: %d, failed: %d, dead-lettered: %d\n", LogTag, stat.delivered, stat.failed, stat.dead)
+	}
+}
+
+func parseArgs() (config, error) {
+	cfg := config{}
+
+	silent := flag.Bool("s", false, "silent mode")
+	batch := flag.Int("n", defaultBatch, "max messages to push in one run")
+	timeout := flag.Duration("timeout", defaultTimeout, "webhook request timeout")
+
+	flag.Parse()
+
+	if *batch <= 0 {
+		return cfg, fmt.Errorf("%w: batch: %d", ErrInvalidArgs, *batch)
+	}
+
+	if *timeout <= 0 {
+		return cfg, fmt.Errorf("%w: timeout: %s", ErrInvalidArgs, *timeout)
+	}
+
+	cfg.silent = *silent
+	cfg.batch = *batch
+	cfg.timeout = *timeout
+
+	cfg.dbURL = os.Getenv("DB_URL")
+	if cfg.dbURL == "" {
+		cfg.dbURL = defaultDatabaseURL
+	}
+
+	codec, err := idcodec.NewFromEnv()
+	if err != nil {
+		return cfg, fmt.Errorf("id codec: %w", err)
+	}
+
+	cfg.codec = codec
+
+	return cfg, nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry"
+	"github.com/vpngen/ministry/internal/core"
+	"github.com/vpngen/ministry/internal/idcodec"
+)
+
+const (
+	backoffBase = 30 * time.Second
+	backoffMax  = 6 * time.Hour
+
+	maxErrorLen = 512
+)
+
+// Webhook request headers. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	headerSignature = "X-Vg-Signature"
+	headerTimestamp = "X-Vg-Timestamp"
+	headerMsgType   = "X-Vg-Message-Type"
+	headerRequestID = "X-Vg-Request-Id"
+)
+
+var (
+	ErrInsecureURL    = errors.New("webhook url must be https")
+	ErrWebhookRefused = errors.New("webhook refused")
+	ErrRedirect       = errors.New("webhook redirect refused")
+)
+
+// The due message of a partner with an active webhook. The message
+// is leased before the push and the push result is recorded only if
+// the lease still holds, so no transaction is open during the push.
+// SKIP LOCKED lets parallel dispatchers take other messages.
+// The lease_id and lease_until columns come from
+// sql/patch/025-vip-lease.sql, vippush requires it applied after 024.
+const sqlGetDueMessage = `
+SELECT
+	vm.brigade_id,
+	vm.msg_type,
+	COALESCE(vt.telegram_id, 0),
+	vm.vpnconfig,
+	vm.payload,
+	vm.push_attempts,
+	bp.partner_id,
+	pw.url,
+	pw.secret,
+	pw.max_attempts
+FROM
+	head.vip_messages vm
+JOIN
+	head.brigadier_partners bp ON bp.brigade_id = vm.brigade_id
+JOIN
+	head.partners_webhooks pw ON pw.partner_id = bp.partner_id
+LEFT JOIN
+	head.vip_telegram_ids vt ON vm.brigade_id = vt.brigade_id
+WHERE
+	pw.is_active = true
+	AND vm.finalizer = true
+	AND (
+		(vm.msg_type = 'config' AND vm.vpnconfig != '' AND vt.brigade_id IS NOT NULL)
+		OR vm.msg_type != 'config'
+	)
+	AND vm.push_next <= NOW() AT TIME ZONE 'UTC'
+	AND (vm.lease_until IS NULL OR vm.lease_until < NOW() AT TIME ZONE 'UTC')
+ORDER BY
+	vm.push_next
+LIMIT 1
+FOR UPDATE OF vm SKIP LOCKED
+`
+
+const sqlLeaseMessage = `
+UPDATE
+	head.vip_messages
+SET
+	last_try = NOW() AT TIME ZONE 'UTC',
+	lease_id = $3,
+	lease_until = $4
+WHERE
+	brigade_id = $1
+	AND msg_type = $2
+`
+
+// The message requeued by ckvip during the push has no lease,
+// it is left for the next push.
+const sqlDeleteMessage = `
+DELETE FROM
+	head.vip_messages
+WHERE
+	brigade_id = $1
+	AND msg_type = $2
+	AND lease_id = $3
+`
+
+const sqlDeleteTelegramID = `
+DELETE FROM
+	head.vip_telegram_ids
+WHERE
+	brigade_id = $1
+`
+
+const sqlPushFailed = `
+UPDATE
+	head.vip_messages
+SET
+	push_attempts = $4,
+	push_next = NOW() AT TIME ZONE 'UTC' + $5 * INTERVAL '1 SECOND',
+	push_error = $6,
+	lease_id = NULL,
+	lease_until = NULL
+WHERE
+	brigade_id = $1
+	AND msg_type = $2
+	AND lease_id = $3
+`
+
+// The body holds the VPN config and the request ID,
+// only the metadata is kept.
+const sqlDeadLetter = `
+INSERT INTO
+	head.vip_messages_dead
+		(brigade_id, partner_id, msg_type, attempts, last_error)
+	VALUES
+		($1, $2, $3, $4, $5)
+`
+
+type dueMessage struct {
+	BrigadeID   uuid.UUID
+	MsgType     string
+	TelegramID  int64
+	VPNConfig   string
+	Payload     string
+	Attempts    int
+	PartnerID   uuid.UUID
+	URL         string
+	Secret      string
+	MaxAttempts int
+}
+
+type dispatchStat struct {
+	delivered int
+	failed    int
+	dead      int
+}
+
+// dispatch - pushes up to batch due messages to the partner webhooks.
+func dispatch(ctx context.Context, db *pgxpool.Pool, c *http.Client, codec *idcodec.Codec, batch int, ttl time.Duration) (dispatchStat, error) {
+	var stat dispatchStat
+
+	for range batch {
+		found, err := pushNext(ctx, db, c, codec, ttl, &stat)
+		if err != nil {
+			return stat, err
+		}
+
+		if !found {
+			break
+		}
+	}
+
+	return stat, nil
+}
+
+// pushNext - leases one due message, pushes it and records the result.
+func pushNext(ctx context.Context, db *pgxpool.Pool, c *http.Client, codec *idcodec.Codec, ttl time.Duration, stat *dispatchStat) (bool, error) {
+	m, lease, err := leaseDueMessage(ctx, db, ttl)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("lease due message: %w", err)
+	}
+
+	body, requestID, pushErr := buildBody(m, codec)
+	if pushErr == nil {
+		pushErr = push(ctx, c, m, requestID, body)
+	}
+
+	if err := recordPush(ctx, db, m, lease, pushErr, stat); err != nil {
+		return false, fmt.Errorf("record push: %w", err)
+	}
+
+	return true, nil
+}
+
+// leaseDueMessage - takes the due message for the lease time.
+func leaseDueMessage(ctx context.Context, db *pgxpool.Pool, ttl time.Duration) (dueMessage, uuid.UUID, error) {
+	var m dueMessage
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return m, uuid.Nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := tx.QueryRow(ctx, sqlGetDueMessage).Scan(
+		&m.BrigadeID, &m.MsgType, &m.TelegramID, &m.VPNConfig, &m.Payload,
+		&m.Attempts, &m.PartnerID, &m.URL, &m.Secret, &m.MaxAttempts,
+	); err != nil {
+		return m, uuid.Nil, err
+	}
+
+	lease := uuid.New()
+
+	if _, err := tx.Exec(ctx, sqlLeaseMessage, m.BrigadeID, m.MsgType, lease, time.Now().UTC().Add(ttl)); err != nil {
+		return m, uuid.Nil, fmt.Errorf("lease: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return m, uuid.Nil, fmt.Errorf("commit: %w", err)
+	}
+
+	return m, lease, nil
+}
+
+// recordPush - records the push result of the leased message.
+func recordPush(ctx context.Context, db *pgxpool.Pool, m dueMessage, lease uuid.UUID, pushErr error, stat *dispatchStat) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	switch {
+	case pushErr == nil:
+		tag, err := tx.Exec(ctx, sqlDeleteMessage, m.BrigadeID, m.MsgType, lease)
+		if err != nil {
+			return fmt.Errorf("delete message: %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			fmt.Fprintf(os.Stderr, "%s: Lease of %s message of brigade %s is lost, delivered anyway\n", LogTag, m.MsgType, m.BrigadeID)
+
+			break
+		}
+
+		if m.MsgType == ministry.VIPMessageConfig {
+			if _, err := tx.Exec(ctx, sqlDeleteTelegramID, m.BrigadeID); err != nil {
+				return fmt.Errorf("delete tg id: %w", err)
+			}
+		}
+
+		stat.delivered++
+	case m.Attempts+1 >= m.MaxAttempts:
+		tag, err := tx.Exec(ctx, sqlDeleteMessage, m.BrigadeID, m.MsgType, lease)
+		if err != nil {
+			return fmt.Errorf("delete message: %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			fmt.Fprintf(os.Stderr, "%s: Lease of %s message of brigade %s is lost: %s\n", LogTag, m.MsgType, m.BrigadeID, pushErr)
+
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: Dead-letter %s message of brigade %s: %s\n", LogTag, m.MsgType, m.BrigadeID, pushErr)
+
+		if _, err := tx.Exec(ctx, sqlDeadLetter,
+			m.BrigadeID, m.PartnerID, m.MsgType, m.Attempts+1, errorText(pushErr),
+		); err != nil {
+			return fmt.Errorf("dead letter: %w", err)
+		}
+
+		stat.dead++
+	default:
+		delay := backoff(m.Attempts + 1)
+
+		fmt.Fprintf(os.Stderr, "%s: Push %s message of brigade %s failed, retry in %s: %s\n", LogTag, m.MsgType, m.BrigadeID, delay, pushErr)
+
+		if _, err := tx.Exec(ctx, sqlPushFailed,
+			m.BrigadeID, m.MsgType, lease, m.Attempts+1, int64(delay.Seconds()), errorText(pushErr),
+		); err != nil {
+			return fmt.Errorf("push failed: %w", err)
+		}
+
+		stat.failed++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+// buildBody - the same answer the partner gets from readmsgs.
+func buildBody(m dueMessage, codec *idcodec.Codec) ([]byte, uuid.UUID, error) {
+	requestID, err := codec.Encode(m.BrigadeID)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("encode request id: %w", err)
+	}
+
+	answ, err := core.VIPMessageAnswer(m.MsgType, m.VPNConfig, m.Payload, m.TelegramID, requestID)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("answer: %w", err)
+	}
+
+	body, err := json.Marshal(answ)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return body, requestID, nil
+}
+
+// push - posts the signed body, any 2xx status means delivered.
+func push(ctx context.Context, c *http.Client, m dueMessage, requestID uuid.UUID, body []byte) error {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return fmt.Errorf("parse url: %w", err)
+	}
+
+	if u.Scheme != "https" {
+		return fmt.Errorf("%w: %s", ErrInsecureURL, u.Redacted())
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerTimestamp, ts)
+	req.Header.Set(headerSignature, "sha256="+sign(m.Secret, ts, body))
+	req.Header.Set(headerMsgType, m.MsgType)
+	req.Header.Set(headerRequestID, requestID.String())
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrWebhookRefused, resp.Status)
+	}
+
+	return nil
+}
+
+func sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff - the delay before the next attempt, doubles with every failure.
+func backoff(attempts int) time.Duration {
+	delay := backoffBase
+
+	for i := 1; i < attempts && delay < backoffMax; i++ {
+		delay *= 2
+	}
+
+	return min(delay, backoffMax)
+}
+
+func errorText(err error) string {
+	s := err.Error()
+	if len(s) > maxErrorLen {
+		s = s[:maxErrorLen]
+	}
+
+	return s
+}
+
+// refuseRedirect - the https check covers only the webhook url,
+// a redirect would re-post the VPN config to an unchecked one.
+func refuseRedirect(*http.Request, []*http.Request) error {
+	return ErrRedirect
+}
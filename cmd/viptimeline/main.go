@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry/internal/core"
+	"github.com/vpngen/ministry/internal/pgsql"
+)
+
+const (
+	LogTag             = "viptimeline"
+	defaultDatabaseURL = "postgresql:///vgdept"
+)
+
+var (
+	ErrInvalidArgs = errors.New("invalid args")
+	ErrInvalidUUID = errors.New("invalid uuid")
+)
+
+// VIPState - the current state of head.brigadier_vip record.
+type VIPState struct {
+	Expire    *time.Time `json:"expire,omitempty"`
+	Users     int        `json:"users"`
+	Finalizer bool       `json:"finalizer"`
+}
+
+type Timeline struct {
+	BrigadeID uuid.UUID       `json:"brigade_id"`
+	Current   *VIPState       `json:"current,omitempty"`
+	Events    []core.VIPEvent `json:"events"`
+}
+
+func main() {
+	brigadeID, jout, err := parseArgs()
+	if err != nil {
+		log.Fatalf("%s: Can't parse args: %s\n", LogTag, err)
+	}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = defaultDatabaseURL
+	}
+
+	db, err := pgsql.CreateDBPool(dbURL)
+	if err != nil {
+		log.Fatalf("%s: Can't create db pool: %s\n", LogTag, err)
+	}
+
+	tl, err := fetchTimeline(context.Background(), db, brigadeID)
+	if err != nil {
+		log.Fatalf("%s: Can't fetch timeline: %s\n", LogTag, err)
+	}
+
+	switch jout {
+	case true:
+		payload, err := json.MarshalIndent(tl, "", "  ")
+		if err != nil {
+			log.Fatalf("%s: Can't marshal timeline: %s\n", LogTag, err)
+		}
+
+		fmt.Fprintf(os.Stdout, "%s\n", payload)
+	default:
+		printTimeline(os.Stdout, tl)
+	}
+}
+
+const sqlVIPState = `
+SELECT
+	vip_expire,
+	vip_users,
+	finalizer
+FROM
+	head.brigadier_vip
+WHERE
+	brigade_id = $1
+`
+
+func fetchTimeline(ctx context.Context, db *pgxpool.Pool, brigadeID uuid.UUID) (*Timeline, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	tl := &Timeline{
+		BrigadeID: brigadeID,
+	}
+
+	var state VIPState
+	switch err := tx.QueryRow(ctx, sqlVIPState, brigadeID).Scan(&state.Expire, &state.Users, &state.Finalizer); {
+	case err == nil:
+		tl.Current = &state
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("vip state: %w", err)
+	}
+
+	events, err := core.FetchVIPTimeline(ctx, tx, brigadeID)
+	if err != nil {
+		return nil, fmt.Errorf("timeline: %w", err)
+	}
+
+	tl.Events = events
+
+	return tl, nil
+}
+
+func printTimeline(w io.Writer, tl *Timeline) {
+	fmt.Fprintf(w, "Brigade: %s\n", tl.BrigadeID)
+
+	switch tl.Current {
+	case nil:
+		fmt.Fprintf(w, "Current: no VIP record\n")
+	default:
+		fmt.Fprintf(w, "Current: expire: %s, users: %d, realm vip: %t\n", fmtTime(tl.Current.Expire), tl.Current.Users, tl.Current.Finalizer)
+	}
+
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "TIME\tEVENT\tEXPIRE\tUSERS\tINFO\n")
+
+	for _, ev := range tl.Events {
+		fmt.Fprintf(tw, "%s\t%s\t%s -> %s\t%s -> %s\t%s\n",
+			ev.Time.Format(time.RFC3339), ev.Name,
+			fmtTime(ev.OldExpire), fmtTime(ev.NewExpire),
+			fmtInt(ev.OldUsers), fmtInt(ev.NewUsers),
+			ev.Info,
+		)
+	}
+
+	tw.Flush()
+}
+
+func fmtTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func fmtInt(n *int) string {
+	if n == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d", *n)
+}
+
+func parseArgs() (uuid.UUID, bool, error) {
+	jout := flag.Bool("j", false, "json output")
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		return uuid.Nil, false, fmt.Errorf("brigade id: %w", ErrInvalidArgs)
+	}
+
+	id, err := parseBrigadeID(flag.Arg(0))
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("brigade id: %w", err)
+	}
+
+	return id, *jout, nil
+}
+
+// parseBrigadeID - accepts UUID or base32 (realm side) form.
+func parseBrigadeID(s string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(s); err == nil {
+		return id, nil
+	}
+
+	buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidUUID, err)
+	}
+
+	id, err := uuid.FromBytes(buf)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrInvalidUUID, err)
+	}
+
+	return id, nil
+}
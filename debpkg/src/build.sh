@@ -14,6 +14,11 @@ go build -C ministry/cmd/syncstats -o ../../../bin/syncstats
 go build -C ministry/cmd/recodesnaps -o ../../../bin/recodesnaps
 go build -C ministry/cmd/recodesnapmap -o ../../../bin/recodesnapmap
 go build -C ministry/cmd/synclabels -o ../../../bin/synclabels
+go build -C ministry/cmd/viptimeline -o ../../../bin/viptimeline
+go build -C ministry/cmd/attribution -o ../../../bin/attribution
+go build -C ministry/cmd/retention -o ../../../bin/retention
+go build -C ministry/cmd/vippush -o ../../../bin/vippush
+go build -C ministry/cmd/vipgrant -o ../../../bin/vipgrant
 
 go install github.com/goreleaser/nfpm/v2/cmd/nfpm@v2.43.1 # fix go 1.24
 
@@ -77,6 +77,8 @@ cleanInstall() {
 	systemctl start vg-sync-ids.timer ||:
         systemctl enable vg-ckvip.timer ||:
         systemctl start vg-ckvip.timer ||:
+        systemctl enable vg-vippush.timer ||:
+        systemctl start vg-vippush.timer ||:
 }
 
 upgrade() {
@@ -90,6 +92,8 @@ upgrade() {
 	systemctl start vg-sync-ids.timer ||:
         systemctl enable vg-ckvip.timer ||:
         systemctl start vg-ckvip.timer ||:
+        systemctl enable vg-vippush.timer ||:
+        systemctl start vg-vippush.timer ||:
 }
 
 # Step 2, check if this is a clean install or an upgrade
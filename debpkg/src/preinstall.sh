@@ -57,6 +57,8 @@ upgrade() {
         systemctl stop vg-sync-ids.service ||:
         systemctl stop vg-ckvip.timer ||:
         systemctl stop vg-ckvip.service ||:
+        systemctl stop vg-vippush.timer ||:
+        systemctl stop vg-vippush.service ||:
 }
 
 # Step 2, check if this is a clean install or an upgrade
@@ -8,6 +8,8 @@ remove() {
         systemctl stop --force vg-sync-ids.service ||:
         systemctl stop --force vg-ckvip.timer ||:
         systemctl stop --force vg-ckvip.service ||:
+        systemctl stop --force vg-vippush.timer ||:
+        systemctl stop --force vg-vippush.service ||:
 }
 
 upgrade() {
@@ -10,6 +10,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vpngen/ministry"
 	"github.com/vpngen/wordsgens/namesgenerator"
 	"github.com/vpngen/wordsgens/seedgenerator"
 )
@@ -235,19 +236,30 @@ func defineBrigadeID(ctx context.Context, tx pgx.Tx) (uuid.UUID, time.Time, erro
 }
 
 func storeBrigadierLabel(ctx context.Context, tx pgx.Tx,
-	id uuid.UUID, pid uuid.UUID, now time.Time, label string, labelID string, firstVisit int64,
+	id uuid.UUID, pid uuid.UUID, now time.Time, label string, labelID string, firstVisit int64, utm ministry.UTM,
 ) error {
 	fv := time.Unix(firstVisit, 0)
 
+	// the UTM fields synced before the brigade creation are kept if not given
 	sql := `
 	INSERT INTO
-		head.start_labels (brigade_id, created_at, label, label_id, first_visit, update_time, partner_id)
+		head.start_labels (brigade_id, created_at, label, label_id, first_visit, update_time, partner_id,
+			utm_source, utm_medium, utm_campaign, utm_content, utm_term, referrer)
 	VALUES
-		($1, $2::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', $3, $4, $5::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', NOW() AT TIME ZONE 'UTC', $6)
+		($1, $2::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', $3, $4, $5::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', NOW() AT TIME ZONE 'UTC', $6,
+			NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''))
 	ON CONFLICT (label_id, partner_id, first_visit) DO UPDATE
-		SET brigade_id=$1, created_at=$2::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', label=$3, first_visit=$5::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', update_time=NOW() AT TIME ZONE 'UTC', partner_id=$6
+		SET brigade_id=$1, created_at=$2::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', label=$3, first_visit=$5::TIMESTAMP WITHOUT TIME ZONE AT TIME ZONE 'UTC', update_time=NOW() AT TIME ZONE 'UTC', partner_id=$6,
+			utm_source=COALESCE(EXCLUDED.utm_source, start_labels.utm_source),
+			utm_medium=COALESCE(EXCLUDED.utm_medium, start_labels.utm_medium),
+			utm_campaign=COALESCE(EXCLUDED.utm_campaign, start_labels.utm_campaign),
+			utm_content=COALESCE(EXCLUDED.utm_content, start_labels.utm_content),
+			utm_term=COALESCE(EXCLUDED.utm_term, start_labels.utm_term),
+			referrer=COALESCE(EXCLUDED.referrer, start_labels.referrer)
 	`
-	if _, err := tx.Exec(ctx, sql, id, now, label, labelID, fv, pid); err != nil {
+	if _, err := tx.Exec(ctx, sql, id, now, label, labelID, fv, pid,
+		utm.Source, utm.Medium, utm.Campaign, utm.Content, utm.Term, utm.Referrer,
+	); err != nil {
 		return fmt.Errorf("store label: %w", err)
 	}
 
@@ -257,7 +269,7 @@ func storeBrigadierLabel(ctx context.Context, tx pgx.Tx,
 func CreateBrigade(ctx context.Context, db *pgxpool.Pool,
 	seedExtra string, partnerID uuid.UUID, creationInfo string,
 	forcePerson *namesgenerator.Person, customName string,
-	label string, labelID string, firstVisit int64,
+	label string, labelID string, firstVisit int64, utm ministry.UTM,
 ) (uuid.UUID, string, string, *namesgenerator.Person, error) {
 	tx, err := db.Begin(ctx)
 	if err != nil {
@@ -289,7 +301,7 @@ func CreateBrigade(ctx context.Context, db *pgxpool.Pool,
 		return uuid.Nil, "", "", nil, fmt.Errorf("store brigadier partner: %w", err)
 	}
 
-	if err := storeBrigadierLabel(ctx, tx, id, partnerID, now, label, labelID, firstVisit); err != nil {
+	if err := storeBrigadierLabel(ctx, tx, id, partnerID, now, label, labelID, firstVisit, utm); err != nil {
 		return uuid.Nil, "", "", nil, fmt.Errorf("store brigadier label: %w", err)
 	}
 
@@ -307,7 +319,7 @@ const sqlStoreTGID = `
 	VALUES
 		($1, $2)
 	ON CONFLICT (brigade_id) DO UPDATE
-		SET telegram_id = EXCLUDED.telegram_id
+		SET telegram_id = EXCLUDED.telegram_id, telegram_hash = NULL
 	`
 
 func storeVIPTelegramID(ctx context.Context, tx pgx.Tx, id uuid.UUID, tgID int64) error {
@@ -377,7 +389,7 @@ func RequestVIPBrigade(ctx context.Context, db *pgxpool.Pool,
 		return uuid.Nil, fmt.Errorf("store brigadier partner: %w", err)
 	}
 
-	if err := storeBrigadierLabel(ctx, tx, id, partnerID, now, label, labelID, firstVisit); err != nil {
+	if err := storeBrigadierLabel(ctx, tx, id, partnerID, now, label, labelID, firstVisit, ministry.UTM{}); err != nil {
 		return uuid.Nil, fmt.Errorf("store brigadier label: %w", err)
 	}
 
@@ -428,10 +440,10 @@ func UpdateVIPBrigade(ctx context.Context, db *pgxpool.Pool,
 const sqlVIPStoreMnemo = `
 INSERT INTO
 	head.vip_messages
-	(brigade_id, mnemo)
+	(brigade_id, msg_type, mnemo)
 VALUES
-	($1, $2)
-ON CONFLICT (brigade_id) DO UPDATE
+	($1, 'config', $2)
+ON CONFLICT (brigade_id, msg_type) DO UPDATE
 	SET mnemo = EXCLUDED.mnemo
 `
 
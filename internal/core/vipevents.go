@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// VIP lifecycle event names.
+const (
+	VIPEventGrant   = "grant"   // new entitlement
+	VIPEventExtend  = "extend"  // expiration moved forward
+	VIPEventShorten = "shorten" // expiration moved backward
+	VIPEventSeats   = "seats"   // users count changed
+	VIPEventReset   = "reset"   // entitlement disappeared, expiration reset to now
+	VIPEventBegin   = "begin"   // realm vipon
+	VIPEventEnd     = "end"     // realm vipoff
+	VIPEventPurge   = "purge"   // record purged
+	VIPEventNotify  = "notify"  // message queued, info is the message type
+	VIPEventCorrect = "correct" // realm drift corrected, info is the action
+)
+
+// VIPEvent - the record of head.brigadier_vip_actions.
+// Nil expiration and users mean unknown (or absent) state.
+type VIPEvent struct {
+	ID        int64      `json:"id,omitempty"`
+	BrigadeID uuid.UUID  `json:"brigade_id"`
+	Time      time.Time  `json:"time"`
+	Name      string     `json:"event"`
+	Info      string     `json:"info,omitempty"`
+	OldExpire *time.Time `json:"old_expire,omitempty"`
+	NewExpire *time.Time `json:"new_expire,omitempty"`
+	OldUsers  *int       `json:"old_users,omitempty"`
+	NewUsers  *int       `json:"new_users,omitempty"`
+}
+
+const sqlAddVIPEvent = `
+INSERT INTO
+	head.brigadier_vip_actions
+		(brigade_id, event_name, event_info, event_time, old_expire, new_expire, old_users, new_users)
+	VALUES
+		($1, $2, $3, NOW() AT TIME ZONE 'UTC', $4, $5, $6, $7)
+`
+
+// AddVIPEvent - records the VIP lifecycle event.
+func AddVIPEvent(ctx context.Context, tx pgx.Tx, ev VIPEvent) error {
+	if _, err := tx.Exec(ctx, sqlAddVIPEvent,
+		ev.BrigadeID, ev.Name, ev.Info,
+		ev.OldExpire, ev.NewExpire, ev.OldUsers, ev.NewUsers,
+	); err != nil {
+		return fmt.Errorf("add vip event: %w", err)
+	}
+
+	return nil
+}
+
+// VIPChangeEvents - compares the old and the new VIP state
+// and returns the events to record, if any.
+// Nil old expiration means there was no VIP record.
+func VIPChangeEvents(brigadeID uuid.UUID, info string,
+	oldExpire *time.Time, oldUsers *int,
+	newExpire time.Time, newUsers int,
+) []VIPEvent {
+	ev := VIPEvent{
+		BrigadeID: brigadeID,
+		Info:      info,
+		OldExpire: oldExpire,
+		NewExpire: &newExpire,
+		OldUsers:  oldUsers,
+		NewUsers:  &newUsers,
+	}
+
+	if oldExpire == nil {
+		ev.Name = VIPEventGrant
+
+		return []VIPEvent{ev}
+	}
+
+	events := make([]VIPEvent, 0, 2)
+
+	switch {
+	case oldExpire.Before(newExpire):
+		ev.Name = VIPEventExtend
+		events = append(events, ev)
+	case oldExpire.After(newExpire):
+		ev.Name = VIPEventShorten
+		events = append(events, ev)
+	}
+
+	if oldUsers == nil || *oldUsers != newUsers {
+		ev.Name = VIPEventSeats
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+const sqlVIPTimeline = `
+SELECT
+	event_id,
+	brigade_id,
+	event_time,
+	event_name,
+	event_info,
+	old_expire,
+	new_expire,
+	old_users,
+	new_users
+FROM
+	head.brigadier_vip_actions
+WHERE
+	brigade_id = $1
+ORDER BY
+	event_time, event_id
+`
+
+// FetchVIPTimeline - returns all the VIP events of the brigade in order.
+func FetchVIPTimeline(ctx context.Context, tx pgx.Tx, brigadeID uuid.UUID) ([]VIPEvent, error) {
+	rows, err := tx.Query(ctx, sqlVIPTimeline, brigadeID)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	defer rows.Close()
+
+	events := make([]VIPEvent, 0)
+
+	for rows.Next() {
+		var ev VIPEvent
+
+		if err := rows.Scan(&ev.ID, &ev.BrigadeID, &ev.Time, &ev.Name, &ev.Info,
+			&ev.OldExpire, &ev.NewExpire, &ev.OldUsers, &ev.NewUsers,
+		); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		events = append(events, ev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVIPChangeEvents(t *testing.T) {
+	id := uuid.MustParse("0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d")
+
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	later := now.AddDate(0, 1, 0)
+	users, more := 3, 5
+
+	tests := []struct {
+		name      string
+		oldExpire *time.Time
+		oldUsers  *int
+		newExpire time.Time
+		newUsers  int
+		want      []string
+	}{
+		{"new record", nil, nil, later, users, []string{VIPEventGrant}},
+		{"new record with users", nil, &users, later, more, []string{VIPEventGrant}},
+		{"same", &now, &users, now, users, []string{}},
+		{"extend", &now, &users, later, users, []string{VIPEventExtend}},
+		{"shorten", &later, &users, now, users, []string{VIPEventShorten}},
+		{"seats", &now, &users, now, more, []string{VIPEventSeats}},
+		{"unknown users", &now, nil, now, users, []string{VIPEventSeats}},
+		{"extend and seats", &now, &users, later, more, []string{VIPEventExtend, VIPEventSeats}},
+		{"shorten and seats", &later, &more, now, users, []string{VIPEventShorten, VIPEventSeats}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := VIPChangeEvents(id, "test", tt.oldExpire, tt.oldUsers, tt.newExpire, tt.newUsers)
+
+			names := make([]string, 0, len(events))
+
+			for _, ev := range events {
+				names = append(names, ev.Name)
+
+				if ev.BrigadeID != id || ev.Info != "test" {
+					t.Errorf("%s: got brigade %s, info %q", ev.Name, ev.BrigadeID, ev.Info)
+				}
+
+				if ev.OldExpire != tt.oldExpire || ev.OldUsers != tt.oldUsers {
+					t.Errorf("%s: old state is not kept", ev.Name)
+				}
+
+				if ev.NewExpire == nil || !ev.NewExpire.Equal(tt.newExpire) || ev.NewUsers == nil || *ev.NewUsers != tt.newUsers {
+					t.Errorf("%s: got new expire %v, users %v", ev.Name, ev.NewExpire, ev.NewUsers)
+				}
+			}
+
+			if !slices.Equal(names, tt.want) {
+				t.Fatalf("got %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
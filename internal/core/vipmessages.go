@@ -0,0 +1,43 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vpngen/keydesk/keydesk"
+	"github.com/vpngen/ministry"
+)
+
+// VIPMessageAnswer - builds the partner answer from the vip_messages record.
+// Configs carry the keydesk answer, notices carry the JSON payload.
+func VIPMessageAnswer(msgType, vpnconfig, payload string, tgID int64, requestID uuid.UUID) (*ministry.VIPAnswer, error) {
+	var (
+		msg    ministry.Answer
+		notice *ministry.VIPNotice
+	)
+
+	switch msgType {
+	case ministry.VIPMessageConfig:
+		if err := json.Unmarshal([]byte(vpnconfig), &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal payload: %w", err)
+		}
+	default:
+		if err := json.Unmarshal([]byte(payload), &notice); err != nil {
+			return nil, fmt.Errorf("unmarshal notice: %w", err)
+		}
+
+		msg.Code = http.StatusOK
+		msg.Desc = http.StatusText(http.StatusOK)
+		msg.Status = keydesk.AnswerStatusSuccess
+	}
+
+	return &ministry.VIPAnswer{
+		Answer:     msg,
+		Type:       msgType,
+		Notice:     notice,
+		TelegramID: tgID,
+		RequestID:  requestID,
+	}, nil
+}
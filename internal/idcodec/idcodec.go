@@ -0,0 +1,272 @@
+// Package idcodec obfuscates brigade IDs handed out to partners
+// and payment services.
+//
+// The brigade ID is a random (version 4) UUID, it has 122 random bits.
+// The codec encrypts these bits with a keyed pseudorandom permutation
+// (a balanced Feistel network with HMAC-SHA256 round function) and puts
+// the key ID into the version nibble of the result. So the encoded IDs
+// are still well-formed UUIDs and every encoded ID says which key
+// decodes it, old and new keys can coexist during the rotation.
+//
+// Key ID 4 is reserved for the legacy XOR with OBFS_UUID: the IDs
+// obfuscated in the legacy way keep version 4, so they are decoded
+// with the legacy key as long as it is configured.
+package idcodec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// LegacyKeyID - the key ID of the legacy XOR obfuscation.
+	LegacyKeyID byte = 4
+
+	// MaxKeyID - the key ID fits the version nibble.
+	MaxKeyID byte = 15
+
+	// MinKeyLen - the minimal length of the permutation key.
+	MinKeyLen = 16
+
+	rounds   = 10
+	halfBits = 61
+	halfMask = 1<<halfBits - 1
+)
+
+var (
+	ErrUnknownKey    = errors.New("unknown key")
+	ErrInvalidKey    = errors.New("invalid key")
+	ErrInvalidID     = errors.New("invalid id")
+	ErrNoCurrentKey  = errors.New("no current key")
+	ErrReservedKeyID = errors.New("reserved key id")
+)
+
+// Codec - encodes brigade IDs with the current key and decodes
+// them with any known key.
+type Codec struct {
+	current byte
+	keys    map[byte][]byte
+	legacy  *uuid.UUID
+}
+
+// New - creates the codec. The legacy XOR key is optional, without it
+// the legacy IDs can't be decoded. The current key ID may be LegacyKeyID,
+// this is the compatibility mode: the IDs are encoded in the legacy way.
+func New(current byte, keys map[byte][]byte, legacy *uuid.UUID) (*Codec, error) {
+	c := &Codec{
+		current: current,
+		keys:    make(map[byte][]byte, len(keys)),
+	}
+
+	for id, key := range keys {
+		switch {
+		case id == LegacyKeyID:
+			return nil, fmt.Errorf("%w: %d", ErrReservedKeyID, id)
+		case id == 0 || id > MaxKeyID:
+			return nil, fmt.Errorf("%w: key id %d", ErrInvalidKey, id)
+		case len(key) < MinKeyLen:
+			return nil, fmt.Errorf("%w: key %d: too short", ErrInvalidKey, id)
+		}
+
+		c.keys[id] = key
+	}
+
+	if legacy != nil {
+		l := *legacy
+		l[6] &= 0x0F
+		l[8] &= 0x3F
+
+		c.legacy = &l
+	}
+
+	switch current {
+	case LegacyKeyID:
+		if c.legacy == nil {
+			return nil, fmt.Errorf("%w: legacy", ErrNoCurrentKey)
+		}
+	default:
+		if _, ok := c.keys[current]; !ok {
+			return nil, fmt.Errorf("%w: %d", ErrNoCurrentKey, current)
+		}
+	}
+
+	return c, nil
+}
+
+// NewFromEnv - creates the codec from the environment:
+//
+//	IDCODEC_KEYS - comma separated list of id:hexkey pairs, e.g. 8:00112233...,9:8899aabb...
+//	IDCODEC_KEY_ID - the current key ID, LegacyKeyID (4) by default.
+//	OBFS_UUID - the legacy XOR key.
+func NewFromEnv() (*Codec, error) {
+	keys := make(map[byte][]byte)
+
+	for _, pair := range strings.Split(os.Getenv("IDCODEC_KEYS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		sid, skey, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidKey, sid)
+		}
+
+		id, err := strconv.ParseUint(sid, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key id %q: %w", ErrInvalidKey, sid, err)
+		}
+
+		key, err := hex.DecodeString(skey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d: %w", ErrInvalidKey, id, err)
+		}
+
+		keys[byte(id)] = key
+	}
+
+	current := LegacyKeyID
+	if s := os.Getenv("IDCODEC_KEY_ID"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: current key id %q: %w", ErrInvalidKey, s, err)
+		}
+
+		current = byte(id)
+	}
+
+	var legacy *uuid.UUID
+
+	if s := os.Getenv("OBFS_UUID"); s != "" {
+		l, err := uuid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse obfs uuid: %w", err)
+		}
+
+		legacy = &l
+	}
+
+	return New(current, keys, legacy)
+}
+
+// CurrentKeyID - the ID of the key used to encode.
+func (c *Codec) CurrentKeyID() byte {
+	return c.current
+}
+
+// Encode - obfuscates the brigade ID with the current key.
+func (c *Codec) Encode(id uuid.UUID) (uuid.UUID, error) {
+	if c.current == LegacyKeyID {
+		return xor(id, *c.legacy), nil
+	}
+
+	if id.Version() != 4 || id.Variant() != uuid.RFC4122 {
+		return uuid.Nil, fmt.Errorf("%w: not a random uuid: %s", ErrInvalidID, id)
+	}
+
+	l, r := split(id)
+	l, r = feistel(c.keys[c.current], l, r)
+
+	return join(l, r, c.current), nil
+}
+
+// Decode - recovers the brigade ID with the key the ID is encoded with.
+func (c *Codec) Decode(id uuid.UUID) (uuid.UUID, error) {
+	if id.Variant() != uuid.RFC4122 {
+		return uuid.Nil, fmt.Errorf("%w: variant: %s", ErrInvalidID, id)
+	}
+
+	keyID := byte(id.Version())
+
+	if keyID == LegacyKeyID {
+		if c.legacy == nil {
+			return uuid.Nil, fmt.Errorf("%w: legacy", ErrUnknownKey)
+		}
+
+		return xor(id, *c.legacy), nil
+	}
+
+	key, ok := c.keys[keyID]
+	if !ok {
+		return uuid.Nil, fmt.Errorf("%w: %d", ErrUnknownKey, keyID)
+	}
+
+	l, r := split(id)
+	l, r = feistelInverse(key, l, r)
+
+	return join(l, r, 4), nil
+}
+
+func xor(in, key uuid.UUID) uuid.UUID {
+	var id uuid.UUID
+
+	for i := range len(in) {
+		id[i] = in[i] ^ key[i]
+	}
+
+	return id
+}
+
+// split - extracts 122 bits, all but the version and variant,
+// as two 61-bit halves.
+func split(id uuid.UUID) (uint64, uint64) {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	x := (hi>>16)<<12 | hi&0xFFF // 60 bits
+	y := lo & (1<<62 - 1)        // 62 bits
+
+	return x<<1 | y>>61, y & halfMask
+}
+
+// join - the reverse of split, sets the version nibble and RFC4122 variant.
+func join(l, r uint64, version byte) uuid.UUID {
+	x := l >> 1
+	y := (l&1)<<61 | r
+
+	hi := (x>>12)<<16 | uint64(version&0x0F)<<12 | x&0xFFF
+	lo := 0b10<<62 | y
+
+	var id uuid.UUID
+
+	binary.BigEndian.PutUint64(id[:8], hi)
+	binary.BigEndian.PutUint64(id[8:], lo)
+
+	return id
+}
+
+func round(key []byte, n int, half uint64) uint64 {
+	var buf [9]byte
+
+	buf[0] = byte(n)
+	binary.BigEndian.PutUint64(buf[1:], half)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(buf[:])
+
+	return binary.BigEndian.Uint64(mac.Sum(nil)[:8]) & halfMask
+}
+
+func feistel(key []byte, l, r uint64) (uint64, uint64) {
+	for n := range rounds {
+		l, r = r, l^round(key, n, r)
+	}
+
+	return l, r
+}
+
+func feistelInverse(key []byte, l, r uint64) (uint64, uint64) {
+	for n := rounds - 1; n >= 0; n-- {
+		l, r = r^round(key, n, l), l
+	}
+
+	return l, r
+}
@@ -0,0 +1,224 @@
+package idcodec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// legacyKey - the OBFS_UUID of the vectors, the codec masks its
+// version and variant bits as ckvip, readmsgs and reqvipid did.
+var legacyKey = uuid.MustParse("f47ac10b-58cc-4372-a567-0e02b2c3d479")
+
+var testKeys = map[byte][]byte{
+	1:        bytes.Repeat([]byte{0x01}, MinKeyLen),
+	8:        []byte("0123456789abcdef0123456789abcdef"),
+	MaxKeyID: bytes.Repeat([]byte{0xFF}, MinKeyLen),
+}
+
+// TestLegacyVectors - the IDs obfuscated by the old
+// brigadeID[i] ^ obfsUUID[i] code must stay the same.
+func TestLegacyVectors(t *testing.T) {
+	codec, err := New(LegacyKeyID, nil, &legacyKey)
+	if err != nil {
+		t.Fatalf("new: %s", err)
+	}
+
+	tests := []struct {
+		id      string
+		encoded string
+	}{
+		{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d", "ff158b85-74d1-4c48-bb1c-538ea8e8e834"},
+		{"c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65", "3ddb24fb-23f1-4d58-aa0b-130c2949a81c"},
+		{"00000000-0000-4000-8000-000000000000", "f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			id := uuid.MustParse(tt.id)
+			want := uuid.MustParse(tt.encoded)
+
+			got, err := codec.Encode(id)
+			if err != nil {
+				t.Fatalf("encode: %s", err)
+			}
+
+			if got != want {
+				t.Fatalf("encode: got %s, want %s", got, want)
+			}
+
+			back, err := codec.Decode(want)
+			if err != nil {
+				t.Fatalf("decode: %s", err)
+			}
+
+			if back != id {
+				t.Fatalf("decode: got %s, want %s", back, id)
+			}
+		})
+	}
+}
+
+// TestVectors - the encoded IDs are handed out, the permutation
+// must not change for the existing keys.
+func TestVectors(t *testing.T) {
+	codec, err := New(8, testKeys, nil)
+	if err != nil {
+		t.Fatalf("new: %s", err)
+	}
+
+	tests := []struct {
+		id      string
+		encoded string
+	}{
+		{"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d", "e4781d23-83ef-85a2-b12e-de7643ec5c26"},
+		{"c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65", "fa3f4ac8-dc6d-8306-a711-5ef08a89a86e"},
+	}
+
+	for _, tt := range tests {
+		got, err := codec.Encode(uuid.MustParse(tt.id))
+		if err != nil {
+			t.Fatalf("encode %s: %s", tt.id, err)
+		}
+
+		if got.String() != tt.encoded {
+			t.Errorf("encode %s: got %s, want %s", tt.id, got, tt.encoded)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ids := []uuid.UUID{
+		uuid.MustParse("00000000-0000-4000-8000-000000000000"),
+		uuid.MustParse("ffffffff-ffff-4fff-bfff-ffffffffffff"),
+		uuid.MustParse("0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d"),
+	}
+
+	for range 100 {
+		ids = append(ids, uuid.New())
+	}
+
+	for keyID := range testKeys {
+		codec, err := New(keyID, testKeys, &legacyKey)
+		if err != nil {
+			t.Fatalf("new %d: %s", keyID, err)
+		}
+
+		for _, id := range ids {
+			enc, err := codec.Encode(id)
+			if err != nil {
+				t.Fatalf("key %d: encode %s: %s", keyID, id, err)
+			}
+
+			if enc == id {
+				t.Errorf("key %d: %s encoded to itself", keyID, id)
+			}
+
+			if byte(enc.Version()) != keyID || enc.Variant() != uuid.RFC4122 {
+				t.Errorf("key %d: %s: version %d, variant %s", keyID, enc, enc.Version(), enc.Variant())
+			}
+
+			dec, err := codec.Decode(enc)
+			if err != nil {
+				t.Fatalf("key %d: decode %s: %s", keyID, enc, err)
+			}
+
+			if dec != id {
+				t.Errorf("key %d: got %s, want %s", keyID, dec, id)
+			}
+		}
+	}
+}
+
+// TestRotation - the IDs encoded with the old key or the legacy
+// XOR are decoded by the codec with the new current key.
+func TestRotation(t *testing.T) {
+	id := uuid.MustParse("c9a1e5f0-7b3d-4e2a-8f6c-1d0e9b8a7c65")
+
+	legacy, err := New(LegacyKeyID, nil, &legacyKey)
+	if err != nil {
+		t.Fatalf("new legacy: %s", err)
+	}
+
+	old, err := New(1, testKeys, nil)
+	if err != nil {
+		t.Fatalf("new old: %s", err)
+	}
+
+	current, err := New(8, testKeys, &legacyKey)
+	if err != nil {
+		t.Fatalf("new current: %s", err)
+	}
+
+	for name, codec := range map[string]*Codec{"legacy": legacy, "old": old} {
+		enc, err := codec.Encode(id)
+		if err != nil {
+			t.Fatalf("%s: encode: %s", name, err)
+		}
+
+		dec, err := current.Decode(enc)
+		if err != nil {
+			t.Fatalf("%s: decode: %s", name, err)
+		}
+
+		if dec != id {
+			t.Errorf("%s: got %s, want %s", name, dec, id)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		current byte
+		keys    map[byte][]byte
+		legacy  *uuid.UUID
+		err     error
+	}{
+		{"reserved key id", 8, map[byte][]byte{LegacyKeyID: testKeys[8]}, nil, ErrReservedKeyID},
+		{"zero key id", 8, map[byte][]byte{0: testKeys[8]}, nil, ErrInvalidKey},
+		{"key id overflow", 8, map[byte][]byte{MaxKeyID + 1: testKeys[8]}, nil, ErrInvalidKey},
+		{"short key", 8, map[byte][]byte{8: []byte("short")}, nil, ErrInvalidKey},
+		{"no current key", 9, testKeys, nil, ErrNoCurrentKey},
+		{"no legacy key", LegacyKeyID, testKeys, nil, ErrNoCurrentKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.current, tt.keys, tt.legacy); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	codec, err := New(8, testKeys, nil)
+	if err != nil {
+		t.Fatalf("new: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		err  error
+	}{
+		{"unknown key", "0b6f4a8e-2c1d-9f3a-9e7b-5d8c1a2b3c4d", ErrUnknownKey},
+		{"no legacy key", "0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d", ErrUnknownKey},
+		{"variant", "0b6f4a8e-2c1d-8f3a-1e7b-5d8c1a2b3c4d", ErrInvalidID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.Decode(uuid.MustParse(tt.id)); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := codec.Encode(uuid.MustParse("0b6f4a8e-2c1d-1f3a-9e7b-5d8c1a2b3c4d")); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("encode not random uuid: got %v, want %v", err, ErrInvalidID)
+	}
+}
@@ -0,0 +1,286 @@
+// Package packsign seals the JSON update packs into signed envelopes
+// and verifies them on the receiving side.
+//
+// The envelope carries the compressed pack, the SHA-256 of every
+// top-level member of the pack (the table sections among them) and
+// the Ed25519 signature of the compressed payload hash and the section
+// hashes. The key ID is the SHA256 fingerprint of the public key in
+// the OpenSSH form, so the keys are made with ssh-keygen -t ed25519
+// and the receiver keeps the public ones in the authorized_keys format.
+package packsign
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/ssh"
+)
+
+// EnvelopeVersion - is a version of Envelope struct.
+const EnvelopeVersion = 1
+
+// Compression algorithms of the payload.
+const (
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// MaxPackSize - the limit of the uncompressed pack.
+const MaxPackSize = 1 << 30
+
+var (
+	ErrNotEd25519             = errors.New("not an ed25519 key")
+	ErrUnsupportedVersion     = errors.New("unsupported envelope version")
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+	ErrUnknownKey             = errors.New("unknown key")
+	ErrBadSignature           = errors.New("bad signature")
+	ErrPackTooLarge           = errors.New("pack is too large")
+	ErrSectionMismatch        = errors.New("section mismatch")
+)
+
+// Envelope - is a signed pack.
+type Envelope struct {
+	Version     int               `json:"version"`
+	KeyID       string            `json:"key_id"`
+	Compression string            `json:"compression"`
+	PayloadHash string            `json:"payload_hash"`
+	Sections    map[string]string `json:"sections"`
+	Payload     []byte            `json:"payload"`
+	Signature   []byte            `json:"signature"`
+}
+
+// Signer - the private key and its ID.
+type Signer struct {
+	key ed25519.PrivateKey
+	id  string
+}
+
+// NewSigner - creates the signer of the key.
+func NewSigner(key ed25519.PrivateKey) (*Signer, error) {
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+
+	return &Signer{key: key, id: ssh.FingerprintSHA256(pub)}, nil
+}
+
+// LoadSigner - reads the unencrypted ed25519 private key (OpenSSH or PKCS#8 PEM).
+func LoadSigner(filename string) (*Signer, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read private key: %w", err)
+	}
+
+	raw, err := ssh.ParseRawPrivateKey(buf)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+
+	switch key := raw.(type) {
+	case ed25519.PrivateKey:
+		return NewSigner(key)
+	case *ed25519.PrivateKey:
+		return NewSigner(*key)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNotEd25519, raw)
+	}
+}
+
+// KeyID - the ID of the signer key.
+func (s *Signer) KeyID() string {
+	return s.id
+}
+
+// Seal - compresses with zstd and signs the JSON object pack.
+func (s *Signer) Seal(pack []byte) (*Envelope, error) {
+	sections, err := hashSections(pack)
+	if err != nil {
+		return nil, err
+	}
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+
+	defer zw.Close()
+
+	e := &Envelope{
+		Version:     EnvelopeVersion,
+		KeyID:       s.id,
+		Compression: CompressionZstd,
+		Sections:    sections,
+		Payload:     zw.EncodeAll(pack, nil),
+	}
+
+	e.PayloadHash = hashHex(e.Payload)
+	e.Signature = ed25519.Sign(s.key, signedData(e))
+
+	return e, nil
+}
+
+// LoadPublicKeys - reads the ed25519 keys of the authorized_keys format file by key ID,
+// the other keys are skipped.
+func LoadPublicKeys(filename string) (map[string]ed25519.PublicKey, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	defer f.Close()
+
+	keys := make(map[string]ed25519.PublicKey)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+
+		cpub, ok := pub.(ssh.CryptoPublicKey)
+		if !ok {
+			continue
+		}
+
+		if key, ok := cpub.CryptoPublicKey().(ed25519.PublicKey); ok {
+			keys[ssh.FingerprintSHA256(pub)] = key
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Verify - checks the envelope is signed by one of the keys and
+// the pack is whole, returns the JSON pack.
+func Verify(e *Envelope, keys map[string]ed25519.PublicKey) ([]byte, error) {
+	if e.Version != EnvelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, e.Version)
+	}
+
+	key, ok := keys[e.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, e.KeyID)
+	}
+
+	if hashHex(e.Payload) != e.PayloadHash || !ed25519.Verify(key, signedData(e), e.Signature) {
+		return nil, ErrBadSignature
+	}
+
+	pack, err := decompress(e.Compression, e.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := hashSections(pack)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, sum := range e.Sections {
+		if sections[name] != sum {
+			return nil, fmt.Errorf("%w: %s", ErrSectionMismatch, name)
+		}
+	}
+
+	if len(sections) != len(e.Sections) {
+		return nil, fmt.Errorf("%w: %d sections signed, %d in pack", ErrSectionMismatch, len(e.Sections), len(sections))
+	}
+
+	return pack, nil
+}
+
+// decompress - the payload up to MaxPackSize.
+func decompress(compression string, payload []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return payload, nil
+	case CompressionZstd:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, compression)
+	}
+
+	zr, err := zstd.NewReader(bytes.NewReader(payload), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+
+	defer zr.Close()
+
+	pack, err := io.ReadAll(io.LimitReader(zr, MaxPackSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+
+	if len(pack) > MaxPackSize {
+		return nil, ErrPackTooLarge
+	}
+
+	return pack, nil
+}
+
+// hashSections - the SHA-256 of every top-level member of the pack
+// as it is in the pack JSON.
+func hashSections(pack []byte) (map[string]string, error) {
+	var members map[string]json.RawMessage
+
+	if err := json.Unmarshal(pack, &members); err != nil {
+		return nil, fmt.Errorf("unmarshal pack: %w", err)
+	}
+
+	sections := make(map[string]string, len(members))
+
+	for name, raw := range members {
+		sections[name] = hashHex(raw)
+	}
+
+	return sections, nil
+}
+
+// signedData - the signed text: the envelope fields and
+// the section hashes sorted by name, one per line.
+func signedData(e *Envelope) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "vgpack %d\n%s\n%s\n%s\n", e.Version, e.KeyID, e.Compression, e.PayloadHash)
+
+	names := make([]string, 0, len(e.Sections))
+	for name := range e.Sections {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %s\n", name, e.Sections[name])
+	}
+
+	return b.Bytes()
+}
+
+func hashHex(buf []byte) string {
+	sum := sha256.Sum256(buf)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,223 @@
+package packsign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testPack = `{"version":4,"ids":[{"brigade_id":"0b6f4a8e-2c1d-4f3a-9e7b-5d8c1a2b3c4d"}],"vip":[]}`
+
+func newTestSigner(t *testing.T) (*Signer, map[string]ed25519.PublicKey) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	s, err := NewSigner(key)
+	if err != nil {
+		t.Fatalf("new signer: %s", err)
+	}
+
+	return s, map[string]ed25519.PublicKey{s.KeyID(): pub}
+}
+
+func TestSealVerify(t *testing.T) {
+	s, keys := newTestSigner(t)
+
+	e, err := s.Seal([]byte(testPack))
+	if err != nil {
+		t.Fatalf("seal: %s", err)
+	}
+
+	if e.Compression != CompressionZstd {
+		t.Errorf("compression: got %s, want %s", e.Compression, CompressionZstd)
+	}
+
+	if len(e.Sections) != 3 {
+		t.Errorf("sections: got %d, want 3", len(e.Sections))
+	}
+
+	pack, err := Verify(e, keys)
+	if err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+
+	if string(pack) != testPack {
+		t.Fatalf("got %s, want %s", pack, testPack)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	s, keys := newTestSigner(t)
+	_, otherKeys := newTestSigner(t)
+
+	tests := []struct {
+		name   string
+		tamper func(e *Envelope)
+		keys   map[string]ed25519.PublicKey
+		err    error
+	}{
+		{
+			name:   "payload byte",
+			tamper: func(e *Envelope) { e.Payload[len(e.Payload)/2] ^= 0x01 },
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "truncated payload",
+			tamper: func(e *Envelope) { e.Payload = e.Payload[:len(e.Payload)-1] },
+			err:    ErrBadSignature,
+		},
+		{
+			name: "truncated payload rehashed",
+			tamper: func(e *Envelope) {
+				e.Payload = e.Payload[:len(e.Payload)-1]
+				e.PayloadHash = hashHex(e.Payload)
+			},
+			err: ErrBadSignature,
+		},
+		{
+			name:   "section hash",
+			tamper: func(e *Envelope) { e.Sections["ids"] = hashHex([]byte("[]")) },
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "dropped section",
+			tamper: func(e *Envelope) { delete(e.Sections, "vip") },
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "signature",
+			tamper: func(e *Envelope) { e.Signature[0] ^= 0x01 },
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "compression",
+			tamper: func(e *Envelope) { e.Compression = CompressionNone },
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "version",
+			tamper: func(e *Envelope) { e.Version++ },
+			err:    ErrUnsupportedVersion,
+		},
+		{
+			name:   "unknown key",
+			tamper: func(*Envelope) {},
+			keys:   otherKeys,
+			err:    ErrUnknownKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := s.Seal([]byte(testPack))
+			if err != nil {
+				t.Fatalf("seal: %s", err)
+			}
+
+			tt.tamper(e)
+
+			k := keys
+			if tt.keys != nil {
+				k = tt.keys
+			}
+
+			if _, err := Verify(e, k); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestVerifySignedTruncated - the signer sealing a broken payload
+// is caught by the decompression and the section hashes.
+func TestVerifySignedTruncated(t *testing.T) {
+	s, keys := newTestSigner(t)
+
+	e, err := s.Seal([]byte(testPack))
+	if err != nil {
+		t.Fatalf("seal: %s", err)
+	}
+
+	e.Payload = e.Payload[:len(e.Payload)/2]
+	e.PayloadHash = hashHex(e.Payload)
+	e.Signature = ed25519.Sign(s.key, signedData(e))
+
+	if _, err := Verify(e, keys); err == nil {
+		t.Fatal("truncated payload verified")
+	}
+
+	sections, err := hashSections([]byte(`{"version":4,"ids":[]}`))
+	if err != nil {
+		t.Fatalf("hash sections: %s", err)
+	}
+
+	e, err = s.Seal([]byte(testPack))
+	if err != nil {
+		t.Fatalf("seal: %s", err)
+	}
+
+	e.Sections = sections
+	e.Signature = ed25519.Sign(s.key, signedData(e))
+
+	if _, err := Verify(e, keys); !errors.Is(err, ErrSectionMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrSectionMismatch)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("marshal private key: %s", err)
+	}
+
+	keyFn := filepath.Join(dir, "pack-sign.key")
+	if err := os.WriteFile(keyFn, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write key: %s", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("public key: %s", err)
+	}
+
+	authFn := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(authFn, append([]byte("# pack keys\n\n"), ssh.MarshalAuthorizedKey(sshPub)...), 0o600); err != nil {
+		t.Fatalf("write authorized keys: %s", err)
+	}
+
+	s, err := LoadSigner(keyFn)
+	if err != nil {
+		t.Fatalf("load signer: %s", err)
+	}
+
+	keys, err := LoadPublicKeys(authFn)
+	if err != nil {
+		t.Fatalf("load public keys: %s", err)
+	}
+
+	e, err := s.Seal([]byte(testPack))
+	if err != nil {
+		t.Fatalf("seal: %s", err)
+	}
+
+	if _, err := Verify(e, keys); err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+}
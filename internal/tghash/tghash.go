@@ -0,0 +1,57 @@
+// Package tghash hashes the telegram IDs with the secret key, so the
+// hashed IDs are linked across the stats and the retained rows while
+// the IDs themselves are neither sent nor kept.
+package tghash
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultKeyPath - the secret of the telegram ID hashes. The key must
+// be the same on every run, otherwise the hashes of the same ID differ.
+const DefaultKeyPath = "/etc/vgdept/telegram-hash.key"
+
+// KeyMinLen - the min length of the key.
+const KeyMinLen = 16
+
+var ErrShortKey = errors.New("telegram hash key is too short")
+
+// ReadKey - reads the telegram hash key from TELEGRAM_HASH_KEY_FILE
+// or the default path, nil key if there is no key file.
+func ReadKey() ([]byte, error) {
+	fn := os.Getenv("TELEGRAM_HASH_KEY_FILE")
+	if fn == "" {
+		fn = DefaultKeyPath
+	}
+
+	buf, err := os.ReadFile(fn)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("read %s: %w", fn, err)
+	}
+
+	key := bytes.TrimSpace(buf)
+	if len(key) < KeyMinLen {
+		return nil, fmt.Errorf("%w: %s: %d < %d bytes", ErrShortKey, fn, len(key), KeyMinLen)
+	}
+
+	return key, nil
+}
+
+// Hash - the keyed hash of the telegram ID, HMAC-SHA256 of its decimal form.
+// A plain hash is useless here: the telegram IDs are easily enumerated.
+func Hash(key []byte, telegramID int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.FormatInt(telegramID, 10)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -22,6 +22,7 @@ if [ -z "${SSH_KEY}" ]; then
         fi
 fi
 
+# legacy hand-protected brigades, until imported with "vipgrant import"
 VIP_BRIGADES_FILE_HOME="${HOME}/.vip_brigades_files"
 if [ -s "${VIP_BRIGADES_FILE_HOME}" ]; then
         VIP_BRIGADES_FILES="${VIP_BRIGADES_FILES} ${VIP_BRIGADES_FILE_HOME}"
@@ -89,6 +90,31 @@ EOF
                 return 1
         fi
 
+        result=$(psql -d "${DBNAME}" -q -t -A \
+                --set ON_ERROR_STOP=yes \
+                --set brigade_id="${brigade_id}" <<EOF
+        SELECT 
+                bg.brigade_id
+        FROM 
+                head.brigadier_vip_grants bg
+        WHERE 
+                bg.brigade_id = :'brigade_id'
+                AND bg.vip_expire > NOW() AT TIME ZONE 'UTC'
+        ;
+EOF
+        )
+
+        rc=$?
+        if [ $rc -ne 0 ]; then
+                echo "[-][is deleteable] Something wrong with db: $rc"
+                return 1
+        fi
+
+        if [ -n "${result}" ]; then
+                echo "[-]         Brigade ${brigade_id} has VIP grant"
+                return 1
+        fi
+
         result=$(psql -d "${DBNAME}" -q -t -A \
                 --set ON_ERROR_STOP=yes \
                 --set brigade_id="${brigade_id}" <<EOF
@@ -21,6 +21,7 @@ if [ -z "${SSH_KEY}" ]; then
         fi
 fi
 
+# legacy hand-protected brigades, until imported with "vipgrant import"
 VIP_BRIGADES_FILE_HOME="${HOME}/.vip_brigades_files"
 if [ -s "${VIP_BRIGADES_FILE_HOME}" ]; then
         VIP_BRIGADES_FILES="${VIP_BRIGADES_FILES} ${VIP_BRIGADES_FILE_HOME}"
@@ -43,9 +44,11 @@ BEGIN;
                 LEFT JOIN  :"schema_name".brigadier_realms AS r ON b.brigade_id=r.brigade_id 
                 LEFT JOIN  :"schema_name".deleted_brigadiers AS d ON b.brigade_id = d.brigade_id 
                 LEFT JOIN  :"schema_name".brigadier_vip AS bv ON b.brigade_id = bv.brigade_id
+                LEFT JOIN  :"schema_name".brigadier_vip_grants AS bg ON b.brigade_id = bg.brigade_id AND bg.vip_expire > NOW() AT TIME ZONE 'UTC'
         WHERE 
                 d.brigade_id IS NULL 
                 AND bv.brigade_id IS NULL
+                AND bg.brigade_id IS NULL
                 AND r.brigade_id IS NULL
                 AND (
                         SELECT 
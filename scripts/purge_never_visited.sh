@@ -24,6 +24,7 @@ if [ -z "${SSH_KEY}" ]; then
         fi
 fi
 
+# legacy hand-protected brigades, until imported with "vipgrant import"
 VIP_BRIGADES_FILE_HOME="${HOME}/.vip_brigades_files"
 if [ -s "${VIP_BRIGADES_FILE_HOME}" ]; then
         VIP_BRIGADES_FILES="${VIP_BRIGADES_FILES} ${VIP_BRIGADES_FILE_HOME}"
@@ -68,11 +69,22 @@ purge_per_realm () {
                 --set brigade_id="${bid}" \
                 --set schema_name="${SCHEMA}" <<EOF
                 SELECT 
-                        COUNT(*)
-                FROM 
-                        :"schema_name".brigadier_vip
-                WHERE 
-                        brigade_id = :'brigade_id'
+                        (
+                                SELECT 
+                                        COUNT(*)
+                                FROM 
+                                        :"schema_name".brigadier_vip
+                                WHERE 
+                                        brigade_id = :'brigade_id'
+                        ) + (
+                                SELECT 
+                                        COUNT(*)
+                                FROM 
+                                        :"schema_name".brigadier_vip_grants
+                                WHERE 
+                                        brigade_id = :'brigade_id'
+                                        AND vip_expire > NOW() AT TIME ZONE 'UTC'
+                        )
 EOF
 )
                 rc=$?
@@ -82,7 +94,7 @@ EOF
                 fi
 
                 if [ -n "${vip}" ] && [ "${vip}" -ne 0 ]; then
-                        echo "[-]         Brigade is not ready for deletion. Is VIP."
+                        echo "[-]         Brigade is not ready for deletion. Is VIP or granted."
                         continue
                 fi
 
@@ -22,8 +22,6 @@ EXCEPTION
         RAISE NOTICE 'Trigger brigadier_vip_update_time_trigger already exists. Ignoring...';
 END$$;
 
-CREATE INDEX brigadier_vip_variant_idx ON :"schema_name".brigadier_vip (vip_variant);
-
 -- Partners actions reference table.
 CREATE TABLE IF NOT EXISTS :"schema_name".brigadier_vip_actions (
         brigade_id          uuid NOT NULL,
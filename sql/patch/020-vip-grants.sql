@@ -0,0 +1,35 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '020-vip-grants', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg']);
+
+-- Manual VIP grants, an entitlement source for ckvip.
+CREATE TABLE IF NOT EXISTS :"schema_name".brigadier_vip_grants (
+        brigade_id                      uuid NOT NULL,
+        vip_expire                      timestamp without time zone NOT NULL,
+        vip_users                       integer NOT NULL DEFAULT 1,
+        product                         text NOT NULL DEFAULT '',
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        FOREIGN KEY (brigade_id)        REFERENCES :"schema_name".brigadiers_ids (brigade_id),
+        PRIMARY KEY (brigade_id)
+);
+
+DO $$
+BEGIN
+    CREATE TRIGGER brigadier_vip_grants_update_time_trigger BEFORE INSERT OR UPDATE ON "head".brigadier_vip_grants FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger brigadier_vip_grants_update_time_trigger already exists. Ignoring...';
+END$$;
+
+GRANT
+        SELECT,UPDATE,INSERT,DELETE
+ON
+        :"schema_name".brigadier_vip_grants
+TO
+        :"head_admin_dbuser";
+
+GRANT SELECT ON :"schema_name".brigadier_vip_grants TO :"head_vpnapi_dbuser";
+GRANT SELECT ON :"schema_name".brigadier_vip_grants TO :"head_stats_dbuser";
+
+COMMIT;
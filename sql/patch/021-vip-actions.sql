@@ -0,0 +1,34 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '021-vip-actions', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants']);
+
+-- Several VIP events can happen to a brigade in one transaction,
+-- so the events are identified by their own sequence.
+ALTER TABLE :"schema_name".brigadier_vip_actions
+        DROP CONSTRAINT IF EXISTS brigadier_vip_actions_pkey;
+
+ALTER TABLE :"schema_name".brigadier_vip_actions
+        ADD COLUMN event_id bigserial NOT NULL,
+        ADD COLUMN old_expire timestamp without time zone DEFAULT NULL,
+        ADD COLUMN new_expire timestamp without time zone DEFAULT NULL,
+        ADD COLUMN old_users integer DEFAULT NULL,
+        ADD COLUMN new_users integer DEFAULT NULL,
+        ADD PRIMARY KEY (event_id);
+
+COMMENT ON COLUMN :"schema_name".brigadier_vip_actions.event_name IS 'grant, extend, shorten, seats, reset, begin (vipon), end (vipoff), purge';
+
+CREATE INDEX IF NOT EXISTS brigadier_vip_actions_brigade_id_idx ON :"schema_name".brigadier_vip_actions (brigade_id, event_time);
+
+GRANT USAGE,SELECT,UPDATE ON SEQUENCE :"schema_name".brigadier_vip_actions_event_id_seq TO :"head_vpnapi_dbuser";
+
+GRANT
+        SELECT
+ON
+        :"schema_name".brigadier_vip,
+        :"schema_name".brigadier_vip_actions
+TO
+        :"head_admin_dbuser",
+        :"head_stats_dbuser";
+
+COMMIT;
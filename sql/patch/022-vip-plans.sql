@@ -0,0 +1,88 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '022-vip-plans', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions']);
+
+-- VIP plans.
+CREATE TABLE IF NOT EXISTS :"schema_name".vip_plans (
+        plan_id                         text NOT NULL,
+        seats                           integer NOT NULL DEFAULT 1,     -- seats per purchase
+        max_seats                       integer NOT NULL DEFAULT 0,     -- 0 means no limit
+        grace_hours                     integer NOT NULL DEFAULT 4,
+        features                        text NOT NULL DEFAULT '',       -- comma separated realm features
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        PRIMARY KEY (plan_id),
+        CHECK (seats >= 0 AND max_seats >= 0 AND grace_hours >= 0)
+);
+
+DO $$
+BEGIN
+    CREATE TRIGGER vip_plans_update_time_trigger BEFORE INSERT OR UPDATE ON "head".vip_plans FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger vip_plans_update_time_trigger already exists. Ignoring...';
+END$$;
+
+-- Payment service products (PaidUser.ProductPrice) to plans mapping.
+CREATE TABLE IF NOT EXISTS :"schema_name".vip_plan_products (
+        product                         text NOT NULL,
+        plan_id                         text NOT NULL,
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        FOREIGN KEY (plan_id)           REFERENCES :"schema_name".vip_plans (plan_id),
+        PRIMARY KEY (product)
+);
+
+DO $$
+BEGIN
+    CREATE TRIGGER vip_plan_products_update_time_trigger BEFORE INSERT OR UPDATE ON "head".vip_plan_products FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger vip_plan_products_update_time_trigger already exists. Ignoring...';
+END$$;
+
+-- NULL variant means no plan: legacy grace period and plain vipon.
+-- The column may already exist, so the foreign keys are added separately.
+ALTER TABLE :"schema_name".brigadier_vip ADD COLUMN IF NOT EXISTS vip_variant text DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS brigadier_vip_variant_idx ON :"schema_name".brigadier_vip (vip_variant);
+
+ALTER TABLE :"schema_name".brigadier_vip_grants ADD COLUMN IF NOT EXISTS vip_variant text DEFAULT NULL;
+
+-- The variants set before the plans become the plans with the defaults.
+INSERT INTO :"schema_name".vip_plans (plan_id)
+        SELECT DISTINCT vip_variant FROM :"schema_name".brigadier_vip WHERE vip_variant IS NOT NULL
+ON CONFLICT (plan_id) DO NOTHING;
+
+DO $$
+BEGIN
+    ALTER TABLE "head".brigadier_vip ADD CONSTRAINT brigadier_vip_vip_variant_fkey FOREIGN KEY (vip_variant) REFERENCES "head".vip_plans (plan_id);
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Constraint brigadier_vip_vip_variant_fkey already exists. Ignoring...';
+END$$;
+
+DO $$
+BEGIN
+    ALTER TABLE "head".brigadier_vip_grants ADD CONSTRAINT brigadier_vip_grants_vip_variant_fkey FOREIGN KEY (vip_variant) REFERENCES "head".vip_plans (plan_id);
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Constraint brigadier_vip_grants_vip_variant_fkey already exists. Ignoring...';
+END$$;
+
+GRANT
+        SELECT,UPDATE,INSERT,DELETE
+ON
+        :"schema_name".vip_plans,
+        :"schema_name".vip_plan_products
+TO
+        :"head_admin_dbuser";
+
+GRANT
+        SELECT
+ON
+        :"schema_name".vip_plans,
+        :"schema_name".vip_plan_products
+TO
+        :"head_vpnapi_dbuser",
+        :"head_stats_dbuser";
+
+COMMIT;
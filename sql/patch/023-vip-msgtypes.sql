@@ -0,0 +1,22 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '023-vip-msgtypes', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans']);
+
+-- Typed messages: one pending message of every type per brigade.
+ALTER TABLE :"schema_name".vip_messages
+        ADD COLUMN msg_type text NOT NULL DEFAULT 'config',
+        ADD COLUMN payload text NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN :"schema_name".vip_messages.msg_type IS 'config, expiring, expired, grace_ending, seats_changed';
+COMMENT ON COLUMN :"schema_name".vip_messages.payload IS 'JSON notice for non config messages';
+
+ALTER TABLE :"schema_name".vip_messages
+        DROP CONSTRAINT IF EXISTS vip_messages_pkey;
+
+ALTER TABLE :"schema_name".vip_messages
+        ADD PRIMARY KEY (brigade_id, msg_type);
+
+COMMENT ON COLUMN :"schema_name".brigadier_vip_actions.event_name IS 'grant, extend, shorten, seats, reset, begin (vipon), end (vipoff), purge, notify';
+
+COMMIT;
@@ -0,0 +1,72 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '024-vip-webhooks', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes']);
+
+-- Partner webhooks for the push delivery of VIP messages.
+-- Partners without an active webhook keep polling readmsgs.
+CREATE TABLE IF NOT EXISTS :"schema_name".partners_webhooks (
+        partner_id                      uuid NOT NULL,
+        url                             text NOT NULL,
+        secret                          text NOT NULL,
+        is_active                       boolean NOT NULL DEFAULT true,
+        max_attempts                    integer NOT NULL DEFAULT 10,
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        FOREIGN KEY (partner_id)        REFERENCES :"schema_name".partners (partner_id),
+        PRIMARY KEY (partner_id)
+);
+
+COMMENT ON COLUMN :"schema_name".partners_webhooks.secret IS 'HMAC-SHA256 key of the X-Vg-Signature header';
+COMMENT ON COLUMN :"schema_name".partners_webhooks.max_attempts IS 'failed pushes before the message is dead-lettered';
+
+DO $$
+BEGIN
+    CREATE TRIGGER partners_webhooks_update_time_trigger BEFORE INSERT OR UPDATE ON "head".partners_webhooks FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger partners_webhooks_update_time_trigger already exists. Ignoring...';
+END$$;
+
+-- Push state of the outbox. The push lease columns are added
+-- by 025-vip-lease, vippush needs both patches.
+ALTER TABLE :"schema_name".vip_messages
+        ADD COLUMN push_attempts integer NOT NULL DEFAULT 0,
+        ADD COLUMN push_next timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        ADD COLUMN push_error text NOT NULL DEFAULT '';
+
+-- Messages the webhook failed to accept max_attempts times.
+-- Only the metadata is kept, the body holds the VPN config.
+CREATE TABLE IF NOT EXISTS :"schema_name".vip_messages_dead (
+        dead_id                         bigserial NOT NULL,
+        brigade_id                      uuid NOT NULL,
+        partner_id                      uuid NOT NULL,
+        msg_type                        text NOT NULL,
+        attempts                        integer NOT NULL,
+        last_error                      text NOT NULL DEFAULT '',
+        dead_time                       timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        FOREIGN KEY (brigade_id)        REFERENCES :"schema_name".brigadiers_ids (brigade_id),
+        FOREIGN KEY (partner_id)        REFERENCES :"schema_name".partners (partner_id),
+        PRIMARY KEY (dead_id)
+);
+
+CREATE INDEX IF NOT EXISTS vip_messages_dead_partner_idx ON :"schema_name".vip_messages_dead (partner_id, dead_time);
+
+DO $$
+BEGIN
+    CREATE TRIGGER vip_messages_dead_update_time_trigger BEFORE INSERT OR UPDATE ON "head".vip_messages_dead FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger vip_messages_dead_update_time_trigger already exists. Ignoring...';
+END$$;
+
+GRANT SELECT ON :"schema_name".partners_webhooks TO :"head_vpnapi_dbuser";
+GRANT SELECT,UPDATE,INSERT,DELETE ON :"schema_name".partners_webhooks TO :"head_admin_dbuser";
+GRANT SELECT,UPDATE,INSERT,DELETE ON :"schema_name".partners_webhooks TO :"partners_admin_dbuser";
+
+GRANT SELECT,INSERT ON :"schema_name".vip_messages_dead TO :"head_vpnapi_dbuser";
+GRANT USAGE,SELECT ON SEQUENCE :"schema_name".vip_messages_dead_dead_id_seq TO :"head_vpnapi_dbuser";
+GRANT SELECT,DELETE ON :"schema_name".vip_messages_dead TO :"head_admin_dbuser";
+GRANT SELECT ON :"schema_name".vip_messages_dead TO :"partners_admin_dbuser";
+
+COMMIT;
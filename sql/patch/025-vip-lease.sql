@@ -0,0 +1,14 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '025-vip-lease', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks']);
+
+-- Messages fetched by readmsgs are leased, the lease replaces the last_try window.
+ALTER TABLE :"schema_name".vip_messages
+        ADD COLUMN lease_id uuid,
+        ADD COLUMN lease_until timestamp without time zone;
+
+COMMENT ON COLUMN :"schema_name".vip_messages.lease_id IS 'the lease token of the last readmsgs fetch';
+COMMENT ON COLUMN :"schema_name".vip_messages.lease_until IS 'the message is not fetched again until the lease expires';
+
+COMMIT;
@@ -0,0 +1,33 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '026-vip-realm-checks', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease']);
+
+-- The last realm VIP state verification by ckvip.
+CREATE TABLE IF NOT EXISTS :"schema_name".vip_realm_checks (
+        realm_id                        uuid NOT NULL,
+        check_time                      timestamp without time zone NOT NULL,
+        vip_count                       integer NOT NULL DEFAULT 0,
+        corrected                       integer NOT NULL DEFAULT 0,
+        unknown                         integer NOT NULL DEFAULT 0,     -- VIP without a record, left as is
+        error                           text NOT NULL DEFAULT '',
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        FOREIGN KEY (realm_id)          REFERENCES :"schema_name".realms (realm_id),
+        PRIMARY KEY (realm_id)
+);
+
+DO $$
+BEGIN
+    CREATE TRIGGER vip_realm_checks_update_time_trigger BEFORE INSERT OR UPDATE ON "head".vip_realm_checks FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger vip_realm_checks_update_time_trigger already exists. Ignoring...';
+END$$;
+
+COMMENT ON COLUMN :"schema_name".brigadier_vip_actions.event_name IS 'grant, extend, shorten, seats, reset, begin (vipon), end (vipoff), purge, notify, correct (realm drift, info is the action)';
+
+GRANT SELECT,UPDATE,INSERT,DELETE ON :"schema_name".vip_realm_checks TO :"head_vpnapi_dbuser";
+GRANT SELECT ON :"schema_name".vip_realm_checks TO :"head_admin_dbuser";
+GRANT SELECT ON :"schema_name".vip_realm_checks TO :"head_stats_dbuser";
+
+COMMIT;
@@ -0,0 +1,42 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '027-vip-grants-log', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease', '026-vip-realm-checks']);
+
+-- Who granted the complimentary VIP and why, the last grant/extend wins.
+ALTER TABLE :"schema_name".brigadier_vip_grants
+        ADD COLUMN IF NOT EXISTS reason text NOT NULL DEFAULT '',
+        ADD COLUMN IF NOT EXISTS operator text NOT NULL DEFAULT '';
+
+-- Manual VIP grants history, the revoked grant is deleted
+-- from head.brigadier_vip_grants and stays here only.
+CREATE TABLE IF NOT EXISTS :"schema_name".brigadier_vip_grants_actions (
+        event_id                        bigserial NOT NULL,
+        brigade_id                      uuid NOT NULL,
+        event_name                      text NOT NULL,
+        vip_expire                      timestamp without time zone DEFAULT NULL,
+        vip_users                       integer DEFAULT NULL,
+        vip_variant                     text DEFAULT NULL,
+        reason                          text NOT NULL DEFAULT '',
+        operator                        text NOT NULL DEFAULT '',
+        event_time                      timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        FOREIGN KEY (brigade_id)        REFERENCES :"schema_name".brigadiers_ids (brigade_id),
+        PRIMARY KEY (event_id)
+);
+
+COMMENT ON COLUMN :"schema_name".brigadier_vip_grants_actions.event_name IS 'grant, extend, revoke';
+
+CREATE INDEX IF NOT EXISTS brigadier_vip_grants_actions_brigade_id_idx ON :"schema_name".brigadier_vip_grants_actions (brigade_id, event_time);
+
+GRANT SELECT,INSERT ON :"schema_name".brigadier_vip_grants_actions TO :"head_admin_dbuser";
+GRANT USAGE,SELECT ON SEQUENCE :"schema_name".brigadier_vip_grants_actions_event_id_seq TO :"head_admin_dbuser";
+
+GRANT
+        SELECT
+ON
+        :"schema_name".brigadier_vip_grants_actions
+TO
+        :"head_vpnapi_dbuser",
+        :"head_stats_dbuser";
+
+COMMIT;
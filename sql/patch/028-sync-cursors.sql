@@ -0,0 +1,32 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '028-sync-cursors', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease', '026-vip-realm-checks', '027-vip-grants-log']);
+
+-- The last acked syncstats position per destination and table section:
+-- (update_time, primary key as text) of the last row applied.
+CREATE TABLE IF NOT EXISTS :"schema_name".sync_cursors (
+        destination                     text NOT NULL,
+        section                         text NOT NULL,
+        cursor_time                     timestamp without time zone NOT NULL,
+        cursor_key                      text[] NOT NULL DEFAULT '{}',
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        PRIMARY KEY (destination, section)
+);
+
+DO $$
+BEGIN
+    CREATE TRIGGER sync_cursors_update_time_trigger BEFORE INSERT OR UPDATE ON "head".sync_cursors FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger sync_cursors_update_time_trigger already exists. Ignoring...';
+END$$;
+
+GRANT SELECT,UPDATE,INSERT,DELETE ON :"schema_name".sync_cursors TO :"head_stats_dbuser";
+GRANT SELECT ON :"schema_name".sync_cursors TO :"head_admin_dbuser";
+
+-- syncstats holds the high-water mark below the oldest running write
+-- transaction, it needs to see xact_start of the other roles sessions.
+GRANT pg_read_all_stats TO :"head_stats_dbuser";
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '029-sync-vip', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease', '026-vip-realm-checks', '027-vip-grants-log', '028-sync-cursors']);
+
+-- syncstats sends the VIP tables to the stats server. The telegram IDs
+-- are hashed by syncstats, the messages go as the delivery status only:
+-- the stats role can't read the mnemonics, configs and payloads at all.
+GRANT SELECT ON :"schema_name".vip_telegram_ids TO :"head_stats_dbuser";
+
+REVOKE SELECT ON :"schema_name".vip_messages FROM :"head_stats_dbuser";
+
+GRANT
+        SELECT (brigade_id, msg_type, finalizer, last_try, push_attempts, push_next, push_error, update_time)
+ON
+        :"schema_name".vip_messages
+TO
+        :"head_stats_dbuser";
+
+COMMIT;
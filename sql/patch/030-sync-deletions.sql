@@ -0,0 +1,79 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '030-sync-deletions', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease', '026-vip-realm-checks', '027-vip-grants-log', '028-sync-cursors', '029-sync-vip']);
+
+-- Tombstones of the deleted rows for syncstats: the table
+-- and the primary key columns of the row in the text form.
+CREATE TABLE IF NOT EXISTS :"schema_name".sync_deletions (
+        event_id                        bigserial NOT NULL,
+        table_name                      text NOT NULL,
+        row_key                         text[] NOT NULL,
+        update_time                     timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        PRIMARY KEY (event_id)
+);
+
+CREATE INDEX IF NOT EXISTS sync_deletions_update_time_idx ON :"schema_name".sync_deletions (update_time, event_id);
+
+DO $$
+BEGIN
+    CREATE TRIGGER sync_deletions_update_time_trigger BEFORE INSERT OR UPDATE ON "head".sync_deletions FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger sync_deletions_update_time_trigger already exists. Ignoring...';
+END$$;
+
+-- The trigger arguments are the key columns of the table. The function
+-- is run with the owner rights, the deleting roles don't need any grants.
+CREATE OR REPLACE FUNCTION log_deletion()
+RETURNS TRIGGER AS $$
+DECLARE
+  row_key text[] := '{}';
+  col text;
+  i integer;
+BEGIN
+  FOR i IN 0 .. TG_NARGS - 1 LOOP
+    EXECUTE format('SELECT ($1).%I::text', TG_ARGV[i]) USING OLD INTO col;
+    row_key := row_key || col;
+  END LOOP;
+
+  INSERT INTO head.sync_deletions (table_name, row_key) VALUES (TG_TABLE_NAME, row_key);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = pg_catalog, pg_temp;
+
+DO $$
+BEGIN
+    CREATE TRIGGER start_labels_deletion_trigger AFTER DELETE ON "head".start_labels FOR EACH ROW EXECUTE PROCEDURE log_deletion('label_id', 'partner_id', 'first_visit');
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger start_labels_deletion_trigger already exists. Ignoring...';
+END$$;
+
+DO $$
+BEGIN
+    CREATE TRIGGER partners_realms_deletion_trigger AFTER DELETE ON "head".partners_realms FOR EACH ROW EXECUTE PROCEDURE log_deletion('partner_id', 'realm_id');
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger partners_realms_deletion_trigger already exists. Ignoring...';
+END$$;
+
+DO $$
+BEGIN
+    CREATE TRIGGER brigadier_vip_deletion_trigger AFTER DELETE ON "head".brigadier_vip FOR EACH ROW EXECUTE PROCEDURE log_deletion('brigade_id');
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger brigadier_vip_deletion_trigger already exists. Ignoring...';
+END$$;
+
+DO $$
+BEGIN
+    CREATE TRIGGER vip_messages_deletion_trigger AFTER DELETE ON "head".vip_messages FOR EACH ROW EXECUTE PROCEDURE log_deletion('brigade_id', 'msg_type');
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger vip_messages_deletion_trigger already exists. Ignoring...';
+END$$;
+
+GRANT SELECT ON :"schema_name".sync_deletions TO :"head_stats_dbuser", :"head_admin_dbuser";
+
+COMMIT;
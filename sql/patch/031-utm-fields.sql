@@ -0,0 +1,17 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '031-utm-fields', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease', '026-vip-realm-checks', '027-vip-grants-log', '028-sync-cursors', '029-sync-vip', '030-sync-deletions']);
+
+-- Structured campaign attribution of the start label, NULL is unknown.
+ALTER TABLE :"schema_name".start_labels
+        ADD COLUMN IF NOT EXISTS utm_source text DEFAULT NULL,
+        ADD COLUMN IF NOT EXISTS utm_medium text DEFAULT NULL,
+        ADD COLUMN IF NOT EXISTS utm_campaign text DEFAULT NULL,
+        ADD COLUMN IF NOT EXISTS utm_content text DEFAULT NULL,
+        ADD COLUMN IF NOT EXISTS utm_term text DEFAULT NULL,
+        ADD COLUMN IF NOT EXISTS referrer text DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS start_labels_utm_campaign_idx ON :"schema_name".start_labels (partner_id, utm_source, utm_campaign);
+
+COMMIT;
@@ -0,0 +1,48 @@
+BEGIN;
+
+SELECT _v.assert_user_is_superuser();
+SELECT _v.register_patch(  '032-retention', ARRAY['001-init', '002-roles', '003-patch', '004-realms','005-updates', '006-split-realms', '007-split-partners', '008-utm','009-roles', '010-utm', '011-fixes', '012-roles', '013-utmnew', '014-utmnew2', '015-utmnew3', '016-vip', '017-vip2', '018-roles', '019-vipmsg', '020-vip-grants', '021-vip-actions', '022-vip-plans', '023-vip-msgtypes', '024-vip-webhooks', '025-vip-lease', '026-vip-realm-checks', '027-vip-grants-log', '028-sync-cursors', '029-sync-vip', '030-sync-deletions', '031-utm-fields']);
+
+-- The retained telegram ID is replaced with its keyed hash,
+-- the same the stats get, see internal/tghash.
+ALTER TABLE :"schema_name".vip_telegram_ids ALTER COLUMN telegram_id DROP NOT NULL;
+ALTER TABLE :"schema_name".vip_telegram_ids ADD COLUMN IF NOT EXISTS telegram_hash text DEFAULT NULL;
+
+DO $$
+BEGIN
+    ALTER TABLE "head".vip_telegram_ids ADD CONSTRAINT vip_telegram_ids_id_or_hash CHECK (telegram_id IS NOT NULL OR telegram_hash IS NOT NULL);
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Constraint vip_telegram_ids_id_or_hash already exists. Ignoring...';
+END$$;
+
+DO $$
+BEGIN
+    CREATE TRIGGER vip_telegram_ids_deletion_trigger AFTER DELETE ON "head".vip_telegram_ids FOR EACH ROW EXECUTE PROCEDURE log_deletion('brigade_id');
+EXCEPTION
+    WHEN duplicate_object THEN
+        RAISE NOTICE 'Trigger vip_telegram_ids_deletion_trigger already exists. Ignoring...';
+END$$;
+
+-- What the retention job removed: the table, the primary key columns
+-- of the row in the text form, the action (delete, hash, erase) and
+-- the policy reason. The rows of one run share the run ID.
+CREATE TABLE IF NOT EXISTS :"schema_name".retention_log (
+        event_id                        bigserial NOT NULL,
+        run_id                          uuid NOT NULL,
+        table_name                      text NOT NULL,
+        row_key                         text[] NOT NULL,
+        action                          text NOT NULL,
+        reason                          text NOT NULL,
+        event_time                      timestamp without time zone NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+        PRIMARY KEY (event_id)
+);
+
+CREATE INDEX IF NOT EXISTS retention_log_run_id_idx ON :"schema_name".retention_log (run_id);
+
+GRANT SELECT,INSERT ON :"schema_name".retention_log TO :"head_admin_dbuser";
+GRANT USAGE,SELECT ON SEQUENCE :"schema_name".retention_log_event_id_seq TO :"head_admin_dbuser";
+GRANT SELECT,DELETE ON :"schema_name".start_labels TO :"head_admin_dbuser";
+GRANT SELECT,UPDATE,DELETE ON :"schema_name".vip_telegram_ids TO :"head_admin_dbuser";
+
+COMMIT;
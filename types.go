@@ -1,6 +1,10 @@
 package ministry
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 	dcmgmt "github.com/vpngen/dc-mgmt"
 	"github.com/vpngen/wordsgens/namesgenerator"
@@ -17,8 +21,70 @@ type VIPReserve struct {
 	RequestID uuid.UUID `json:"request_id"`
 }
 
+// VIP message types.
+const (
+	VIPMessageConfig       = "config"        // brigade credentials
+	VIPMessageExpiring     = "expiring"      // VIP expires in a few days
+	VIPMessageExpired      = "expired"       // VIP is expired, grace period started
+	VIPMessageGraceEnding  = "grace_ending"  // grace period is about to end
+	VIPMessageSeatsChanged = "seats_changed" // number of VIP seats changed
+)
+
+// VIPNotice - the details of non config VIP messages.
+type VIPNotice struct {
+	ExpireAt   *time.Time `json:"expire_at,omitempty"`
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+	DaysLeft   int        `json:"days_left,omitempty"`
+	Users      int        `json:"users,omitempty"`
+	OldUsers   int        `json:"old_users,omitempty"`
+}
+
 type VIPAnswer struct {
 	Answer
-	TelegramID int64     `json:"telegram_id,omitempty"`
-	RequestID  uuid.UUID `json:"request_id,omitempty"`
+	Type       string     `json:"type,omitempty"`
+	Notice     *VIPNotice `json:"notice,omitempty"`
+	TelegramID int64      `json:"telegram_id,omitempty"`
+	RequestID  uuid.UUID  `json:"request_id,omitempty"`
+}
+
+// VIPBatch - the batch of VIP messages leased to the partner.
+// The messages are not fetched again until the lease expires,
+// the lease token may be passed with the acknowledged IDs.
+type VIPBatch struct {
+	Lease      uuid.UUID   `json:"lease"`
+	LeaseUntil time.Time   `json:"lease_until"`
+	Messages   []VIPAnswer `json:"messages"`
+}
+
+// MaxUTMFieldLen - the max length of every UTM field.
+const MaxUTMFieldLen = 512
+
+var ErrUTMFieldTooLong = errors.New("utm field too long")
+
+// UTM - the campaign attribution of the start label.
+type UTM struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+	Content  string `json:"utm_content,omitempty"`
+	Term     string `json:"utm_term,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// Validate - checks the field lengths.
+func (u UTM) Validate() error {
+	for name, v := range map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_content":  u.Content,
+		"utm_term":     u.Term,
+		"referrer":     u.Referrer,
+	} {
+		if len(v) > MaxUTMFieldLen {
+			return fmt.Errorf("%w: %s: %d > %d", ErrUTMFieldTooLong, name, len(v), MaxUTMFieldLen)
+		}
+	}
+
+	return nil
 }